@@ -0,0 +1,209 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+	"user_api_with_concurrency/models"
+	"user_api_with_concurrency/utils"
+)
+
+const (
+	maxRestoreSize     = 256 << 20 // Maximum size of a restore request body (256 MiB).
+	maxRestoreProblems = 10        // Number of invalid users listed when a restore is rejected.
+	maxBackupID        = 1<<31 - 1 // Largest user ID and next ID of a backup, far below overflowing the ID counter.
+)
+
+// adminToken is the bearer token required by the admin endpoints; empty leaves them open.
+var adminToken string
+
+// init reads the admin token from ADMIN_TOKEN.
+func init() {
+	adminToken = os.Getenv("ADMIN_TOKEN")
+}
+
+// requireAdmin wraps an admin handler so it requires the "Authorization: Bearer <ADMIN_TOKEN>" header
+// when ADMIN_TOKEN is set. Without ADMIN_TOKEN, the admin endpoints are open, like the others.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminToken != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized) // Return 401 if the token is missing or wrong.
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// Snapshot returns a consistent point-in-time copy of the user store as a backup.
+// The users are copied while holding the store lock, so no change is half applied.
+func Snapshot(w http.ResponseWriter, r *http.Request) {
+	usersMu.Lock()
+	backup := models.Backup{
+		Version: models.BackupVersion,
+		TakenAt: time.Now().UTC(),
+		NextID:  nextID,
+		Users:   make([]models.User, 0, len(users)),
+	}
+	for _, user := range users {
+		backup.Users = append(backup.Users, user)
+	}
+	usersMu.Unlock()
+
+	slices.SortFunc(backup.Users, func(a, b models.User) int { return a.ID - b.ID })
+	backup.Count = len(backup.Users)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)      // Return 200 (OK) status code.
+	json.NewEncoder(w).Encode(backup) // Return the backup as JSON.
+}
+
+// Restore loads a backup sent as the request body into the user store.
+// The "mode" query parameter selects merge (default) or replace, and with dry_run=true the changes
+// are only counted. The backup is validated as a whole first, so a restore is applied entirely or not at all.
+// The response is a report of the users created, updated, unchanged and deleted.
+func Restore(w http.ResponseWriter, r *http.Request) {
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = models.RestoreMerge
+	}
+	if mode != models.RestoreMerge && mode != models.RestoreReplace {
+		http.Error(w, fmt.Sprintf("unknown restore mode %q (expected merge or replace)", mode), http.StatusBadRequest)
+		return
+	}
+	dryRun, err := parseDryRun(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest) // Return 400 rather than restore for real.
+		return
+	}
+
+	var backup models.Backup
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRestoreSize)).Decode(&backup); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest) // Return 400 if the payload is invalid.
+		return
+	}
+	if err := validateBackup(backup); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest) // Return 400 if the backup is invalid.
+		return
+	}
+
+	usersMu.Lock()         // Lock the mutex to ensure thread-safe access.
+	defer usersMu.Unlock() // Ensure the mutex is unlocked when the function exits.
+
+	report := restoreBackup(backup, mode, dryRun, newAuditContext(w, r))
+
+	// In full export mode, export the restored user list once.
+	// In incremental mode, every change was already appended to the changelog.
+	if !dryRun && report.Created+report.Updated+report.Deleted > 0 && utils.Changes() == nil {
+		utils.SendUsersToCSV(users)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)      // Return 200 (OK) status code.
+	json.NewEncoder(w).Encode(report) // Return the restore report as JSON.
+}
+
+// validateBackup checks the version and completeness of a backup, that its next ID is in range,
+// and that its users are valid and have distinct positive IDs in range. The first problems found are reported together.
+func validateBackup(backup models.Backup) error {
+	if backup.Version < 1 || backup.Version > models.BackupVersion {
+		return fmt.Errorf("unsupported backup version %d (supported: 1 to %d)", backup.Version, models.BackupVersion)
+	}
+	if backup.Count != len(backup.Users) {
+		return fmt.Errorf("backup is incomplete: expected %d users, found %d", backup.Count, len(backup.Users))
+	}
+	if backup.NextID < 0 || backup.NextID > maxBackupID {
+		return fmt.Errorf("invalid backup: next_id %d is out of range (0 to %d)", backup.NextID, maxBackupID)
+	}
+
+	var problems []string
+	seen := make(map[int]bool, len(backup.Users))
+	for i, user := range backup.Users {
+		switch err := user.Validate(); {
+		case user.ID <= 0:
+			problems = append(problems, fmt.Sprintf("user #%d: id must be positive", i+1))
+		case user.ID > maxBackupID:
+			problems = append(problems, fmt.Sprintf("user #%d: id must be at most %d", i+1, maxBackupID))
+		case seen[user.ID]:
+			problems = append(problems, fmt.Sprintf("user %d: duplicate id", user.ID))
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("user %d: %v", user.ID, err))
+		}
+		seen[user.ID] = true
+
+		if len(problems) == maxRestoreProblems {
+			problems = append(problems, "...")
+			break
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New("invalid backup: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// restoreBackup applies a validated backup to the store and reports the changes; with dryRun, the
+// changes are only counted. The caller must hold usersMu. Every change is recorded in the changelog,
+// the change stream and the audit log. The ID counter never moves backwards, so deleted IDs are not reused.
+func restoreBackup(backup models.Backup, mode string, dryRun bool, audit auditContext) models.RestoreReport {
+	report := models.RestoreReport{DryRun: dryRun, Mode: mode}
+	apply := func(op string, user models.User) {
+		if dryRun {
+			return
+		}
+		var before, after *models.User
+		if existing, exists := users[user.ID]; exists {
+			before = &existing
+		}
+		if op == utils.OpDelete {
+			delete(users, user.ID)
+		} else {
+			users[user.ID] = user
+			after = &user
+		}
+		appendChange(op, user)          // Record the change in incremental mode.
+		events.publish(op, user)        // Notify the change stream.
+		audit.record(op, before, after) // Record who changed which fields.
+	}
+
+	inBackup := make(map[int]bool, len(backup.Users))
+	maxID := 0
+	for _, user := range backup.Users {
+		inBackup[user.ID] = true
+		maxID = max(maxID, user.ID)
+
+		existing, exists := users[user.ID]
+		switch {
+		case !exists:
+			report.Created++
+			apply(utils.OpCreate, user)
+		case existing == user:
+			report.Unchanged++
+		default:
+			report.Updated++
+			apply(utils.OpUpdate, user)
+		}
+	}
+
+	if mode == models.RestoreReplace {
+		for id, user := range users {
+			if !inBackup[id] {
+				report.Deleted++
+				apply(utils.OpDelete, user)
+			}
+		}
+	}
+
+	if !dryRun {
+		nextID = max(nextID, backup.NextID, maxID+1)
+	}
+	return report
+}
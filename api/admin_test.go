@@ -0,0 +1,130 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"user_api_with_concurrency/models"
+)
+
+// restore sends a backup to the Restore handler and returns the response recorder.
+func restore(t *testing.T, backup models.Backup, query string) *httptest.ResponseRecorder {
+	body, err := json.Marshal(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	Restore(w, httptest.NewRequest(http.MethodPost, "/admin/restore"+query, bytes.NewReader(body)))
+	return w
+}
+
+// TestSnapshotAndRestore tests that a snapshot restores to the same store in both modes.
+func TestSnapshotAndRestore(t *testing.T) {
+	frodo := models.User{ID: 1, Name: "Frodo Baggins", Age: 50, Email: "frodo@tolkien.com"}
+	sam := models.User{ID: 3, Name: "Sam Gamgee", Age: 38, Email: "sam@tolkien.com"}
+	setUsers(t, sam, frodo)
+
+	w := httptest.NewRecorder()
+	Snapshot(w, httptest.NewRequest(http.MethodGet, "/admin/snapshot", nil))
+	var backup models.Backup
+	if err := json.NewDecoder(w.Body).Decode(&backup); err != nil {
+		t.Fatal(err)
+	}
+	if backup.Version != models.BackupVersion || backup.Count != 2 || backup.Users[0] != frodo || backup.Users[1] != sam {
+		t.Fatalf("Unexpected backup %+v", backup)
+	}
+
+	// Change the store after the snapshot.
+	usersMu.Lock()
+	users[1] = models.User{ID: 1, Name: "Frodo Baggins", Age: 51, Email: "frodo@tolkien.com"}
+	delete(users, 3)
+	users[4] = models.User{ID: 4, Name: "Pippin Took", Age: 29, Email: "pippin@tolkien.com"}
+	nextID = 5
+	usersMu.Unlock()
+
+	tests := []struct {
+		query    string
+		expected models.RestoreReport
+		users    int
+	}{
+		{"?mode=replace&dry_run=true", models.RestoreReport{DryRun: true, Mode: "replace", Created: 1, Updated: 1, Deleted: 1}, 2},
+		{"", models.RestoreReport{Mode: "merge", Created: 1, Updated: 1}, 3},
+		{"?mode=replace", models.RestoreReport{Mode: "replace", Unchanged: 2, Deleted: 1}, 2},
+	}
+	for _, tt := range tests {
+		w := restore(t, backup, tt.query)
+		var report models.RestoreReport
+		if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+			t.Fatalf("%q: %v", tt.query, err)
+		}
+		usersMu.Lock()
+		n := len(users)
+		usersMu.Unlock()
+		if report != tt.expected || n != tt.users {
+			t.Errorf("%q: expected %+v with %d users, got %+v with %d users", tt.query, tt.expected, tt.users, report, n)
+		}
+	}
+
+	usersMu.Lock()
+	defer usersMu.Unlock()
+	if users[1] != frodo || users[3] != sam || nextID != 5 {
+		t.Errorf("Expected the snapshot to be restored without reusing IDs, got %v (next ID %d)", users, nextID)
+	}
+}
+
+// TestRestore_InvalidBackup tests that invalid backups are rejected without changing the store.
+func TestRestore_InvalidBackup(t *testing.T) {
+	setUsers(t)
+	valid := models.User{ID: 1, Name: "Frodo Baggins", Age: 50, Email: "frodo@tolkien.com"}
+
+	tests := []struct {
+		name     string
+		backup   models.Backup
+		query    string
+		expected string
+	}{
+		{"version", models.Backup{Version: 99}, "", "unsupported backup version 99"},
+		{"truncated", models.Backup{Version: 1, Count: 2, Users: []models.User{valid}}, "", "expected 2 users, found 1"},
+		{"duplicate", models.Backup{Version: 1, Count: 2, Users: []models.User{valid, valid}}, "", "user 1: duplicate id"},
+		{"invalid user", models.Backup{Version: 1, Count: 2, Users: []models.User{valid, {ID: 2, Name: "Sam"}}}, "", "user 2: email is required"},
+		{"mode", models.Backup{Version: 1}, "?mode=overwrite", "unknown restore mode"},
+		{"negative next_id", models.Backup{Version: 1, Count: 1, Users: []models.User{valid}, NextID: -1}, "", "next_id -1 is out of range"},
+		{"huge next_id", models.Backup{Version: 1, Count: 1, Users: []models.User{valid}, NextID: math.MaxInt}, "", "is out of range"},
+		{"huge id", models.Backup{Version: 1, Count: 1, Users: []models.User{{ID: math.MaxInt, Name: "Sam", Email: "sam@tolkien.com"}}}, "", "id must be at most"},
+		{"dry_run", models.Backup{Version: 1, Count: 1, Users: []models.User{valid}}, "?mode=replace&dry_run=yes", "invalid dry_run"},
+	}
+	for _, tt := range tests {
+		w := restore(t, tt.backup, tt.query)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.expected) {
+			t.Errorf("%s: expected 400 with %q, got %d %q", tt.name, tt.expected, w.Code, w.Body.String())
+		}
+	}
+
+	usersMu.Lock()
+	defer usersMu.Unlock()
+	if len(users) != 0 {
+		t.Errorf("Expected the store to be unchanged, got %v", users)
+	}
+}
+
+// TestRequireAdmin tests that the admin token is checked when it is set.
+func TestRequireAdmin(t *testing.T) {
+	old := adminToken
+	adminToken = "s3cr3t"
+	defer func() { adminToken = old }()
+
+	handler := requireAdmin(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	for header, expected := range map[string]int{"": http.StatusUnauthorized, "Bearer wrong": http.StatusUnauthorized, "Bearer s3cr3t": http.StatusOK} {
+		req := httptest.NewRequest(http.MethodGet, "/admin/snapshot", nil)
+		req.Header.Set("Authorization", header)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != expected {
+			t.Errorf("Authorization %q: expected %d, got %d", header, expected, w.Code)
+		}
+	}
+}
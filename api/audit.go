@@ -0,0 +1,194 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"user_api_with_concurrency/models"
+	"user_api_with_concurrency/utils"
+)
+
+// Headers identifying the caller and the request behind a change, recorded in the audit log.
+const (
+	ActorHeader     = "X-Actor"      // Identity of the caller, e.g. set by an authenticating proxy.
+	RequestIDHeader = "X-Request-ID" // ID of the request, echoed in the response; generated if missing.
+)
+
+const (
+	maxAuditEntries = 1000 // Default and maximum number of entries returned by an audit query.
+	maxRequestID    = 128  // Maximum length of a request ID sent by a client.
+)
+
+// auditLog records every change of the user store; nil if it cannot be opened.
+var auditLog *utils.AuditLog
+
+// init opens the audit log at AUDIT_LOG (default "audit.jsonl", relative to the project root).
+// Test binaries of any package keep the audit log in memory unless AUDIT_LOG is set, so running
+// the tests never writes to the repository.
+func init() {
+	path := os.Getenv("AUDIT_LOG")
+	if path == "" && !testing.Testing() {
+		path = "audit.jsonl"
+	}
+	if path != "" && !filepath.IsAbs(path) {
+		path = filepath.Join(utils.GetProjectRoot(), path)
+	}
+
+	var err error
+	if auditLog, err = utils.OpenAuditLog(path); err != nil {
+		log.Println("Audit log disabled:", err)
+	}
+}
+
+// auditContext identifies who made a change, and through which request.
+type auditContext struct {
+	actor      string
+	role       string
+	remoteAddr string
+	requestID  string
+	source     string
+}
+
+// newAuditContext identifies the caller and the request of a mutation for the audit log.
+// It also echoes the request ID, or the one generated for the request, in the response headers.
+func newAuditContext(w http.ResponseWriter, r *http.Request) auditContext {
+	id := r.Header.Get(RequestIDHeader)
+	if id == "" || len(id) > maxRequestID || !isPrintableASCII(id) {
+		b := make([]byte, 8)
+		rand.Read(b)
+		id = hex.EncodeToString(b)
+	}
+	w.Header().Set(RequestIDHeader, id)
+
+	actor := strings.TrimSpace(r.Header.Get(ActorHeader))
+	if actor == "" {
+		actor = "anonymous"
+	}
+	return auditContext{
+		actor:      actor,
+		role:       r.Header.Get(roleHeader),
+		remoteAddr: r.RemoteAddr,
+		requestID:  id,
+		source:     r.Method + " " + r.URL.Path,
+	}
+}
+
+// systemAudit is the audit context of the changes made by the server itself, e.g. seeding at startup.
+func systemAudit(source string) auditContext {
+	return auditContext{actor: "system", source: source}
+}
+
+// record appends a change to the audit log: before is nil for creates, and after for deletes.
+// The caller must hold usersMu, so entries follow the order of the changes. Errors are logged,
+// since the change is already applied.
+func (c auditContext) record(op string, before, after *models.User) {
+	if auditLog == nil {
+		return
+	}
+	id := 0
+	if after != nil {
+		id = after.ID
+	} else if before != nil {
+		id = before.ID
+	}
+
+	_, err := auditLog.Append(models.AuditEntry{
+		Actor:      c.actor,
+		Role:       c.role,
+		RemoteAddr: c.remoteAddr,
+		RequestID:  c.requestID,
+		Source:     c.source,
+		Op:         op,
+		UserID:     id,
+		Changes:    models.DiffUsers(before, after),
+	})
+	if err != nil {
+		log.Printf("Failed to record the %s of user %d in the audit log: %v\n", op, id, err)
+	}
+}
+
+// GetAudit returns the entries of the audit log, oldest first. The "since" (RFC 3339 time or date)
+// and "actor" query parameters filter them; "after" skips the entries up to a sequence number,
+// to page through the log, and "limit" caps the number of entries (default and maximum 1000).
+func GetAudit(w http.ResponseWriter, r *http.Request) {
+	query, err := parseAuditQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest) // Return 400 if a parameter is invalid.
+		return
+	}
+	writeAudit(w, query)
+}
+
+// GetUserHistory returns the audit entries of a user, oldest first, with the same query
+// parameters as GetAudit. The history of a deleted user remains available.
+func GetUserHistory(w http.ResponseWriter, r *http.Request) {
+	id, ok := extractUserID(r, w) // Extract the user ID from the URL.
+	if !ok {
+		return // If the ID is invalid, return an error response.
+	}
+
+	query, err := parseAuditQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest) // Return 400 if a parameter is invalid.
+		return
+	}
+	query.UserID = id
+	writeAudit(w, query)
+}
+
+// writeAudit writes the entries of the audit log selected by a query.
+func writeAudit(w http.ResponseWriter, query utils.AuditQuery) {
+	if auditLog == nil {
+		http.Error(w, "The audit log is disabled", http.StatusServiceUnavailable) // Return 503 if it failed to open.
+		return
+	}
+	entries, err := auditLog.Query(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError) // Return 500 if the log cannot be read.
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)       // Return 200 (OK) status code.
+	json.NewEncoder(w).Encode(entries) // Return the entries as JSON.
+}
+
+// parseAuditQuery reads the "since", "actor", "after" and "limit" query parameters.
+func parseAuditQuery(r *http.Request) (utils.AuditQuery, error) {
+	params := r.URL.Query()
+	query := utils.AuditQuery{Actor: params.Get("actor"), Limit: maxAuditEntries}
+
+	if v := params.Get("since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			if since, err = time.Parse(time.DateOnly, v); err != nil {
+				return query, fmt.Errorf("invalid since %q (expected an RFC 3339 time or a date)", v)
+			}
+		}
+		query.Since = since
+	}
+	if v := params.Get("after"); v != "" {
+		after, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || after < 0 {
+			return query, fmt.Errorf("invalid after %q (expected a sequence number)", v)
+		}
+		query.After = after
+	}
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxAuditEntries {
+			return query, fmt.Errorf("invalid limit %q (expected 1 to %d)", v, maxAuditEntries)
+		}
+		query.Limit = limit
+	}
+	return query, nil
+}
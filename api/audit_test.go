@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"user_api_with_concurrency/models"
+	"user_api_with_concurrency/utils"
+)
+
+// setAuditLog replaces the audit log with an empty in-memory one for the duration of a test.
+func setAuditLog(t *testing.T) {
+	saved := auditLog
+	auditLog, _ = utils.OpenAuditLog("")
+	t.Cleanup(func() { auditLog = saved })
+}
+
+// mutate sends a request to a user handler as the given actor and returns the response recorder.
+func mutate(handler http.HandlerFunc, method, target, body, actor string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set(ActorHeader, actor)
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// history returns the audit entries returned by a handler.
+func history(t *testing.T, handler http.HandlerFunc, target string) []models.AuditEntry {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, target, nil))
+	var entries []models.AuditEntry
+	if err := json.NewDecoder(w.Body).Decode(&entries); err != nil {
+		t.Fatalf("%s: %d %v", target, w.Code, err)
+	}
+	return entries
+}
+
+// TestAudit tests that the user handlers record who changed which fields, and that the
+// history of a user and the audit log can be queried.
+func TestAudit(t *testing.T) {
+	setUsers(t, models.User{ID: 7, Name: "Sam Gamgee", Age: 38, Email: "sam@tolkien.com"})
+	setAuditLog(t)
+
+	r := httptest.NewRequest(http.MethodPut, "/users/7", strings.NewReader(`{"name":"Sam Gamgee","age":39,"email":"samwise@tolkien.com"}`))
+	r.Header.Set(ActorHeader, "rosie")
+	r.Header.Set(RequestIDHeader, "req-42")
+	w := httptest.NewRecorder()
+	UpdateUser(w, r)
+	if w.Header().Get(RequestIDHeader) != "req-42" {
+		t.Errorf("Expected the request ID to be echoed, got %q", w.Header().Get(RequestIDHeader))
+	}
+	mutate(CreateUser, http.MethodPost, "/users", `{"name":"Frodo Baggins","age":50,"email":"frodo@tolkien.com"}`, "bilbo")
+	if w := mutate(DeleteUser, http.MethodDelete, "/users/7", "", ""); w.Header().Get(RequestIDHeader) == "" {
+		t.Error("Expected a request ID to be generated")
+	}
+
+	entries := history(t, GetUserHistory, "/users/7/history")
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries for user 7, got %+v", entries)
+	}
+	update, del := entries[0], entries[1]
+	if update.Actor != "rosie" || update.RequestID != "req-42" || update.Op != utils.OpUpdate || update.Source != "PUT /users/7" {
+		t.Errorf("Unexpected update entry %+v", update)
+	}
+	expected := []models.FieldChange{{Field: "age", Before: 38.0, After: 39.0}, {Field: "email", Before: "sam@tolkien.com", After: "samwise@tolkien.com"}}
+	if len(update.Changes) != 2 || update.Changes[0] != expected[0] || update.Changes[1] != expected[1] {
+		t.Errorf("Expected the changes %+v, got %+v", expected, update.Changes)
+	}
+	if del.Actor != "anonymous" || del.Op != utils.OpDelete || len(del.Changes) != 3 || del.Changes[0].After != nil {
+		t.Errorf("Expected the deleted user's fields, got %+v", del)
+	}
+
+	if entries := history(t, GetAudit, "/audit?actor=bilbo"); len(entries) != 1 || entries[0].Op != utils.OpCreate || entries[0].Changes[0].After != "Frodo Baggins" {
+		t.Errorf("Expected bilbo's create, got %+v", entries)
+	}
+	if entries := history(t, GetAudit, "/audit?since=2000-01-01&after=1&limit=1"); len(entries) != 1 || entries[0].Seq != 2 {
+		t.Errorf("Expected the second entry, got %+v", entries)
+	}
+	if entries := history(t, GetAudit, "/audit?since=2999-01-01T00:00:00Z"); len(entries) != 0 {
+		t.Errorf("Expected no entries in the future, got %+v", entries)
+	}
+
+	for _, target := range []string{"/audit?since=yesterday", "/audit?limit=0", "/audit?after=-1"} {
+		w := httptest.NewRecorder()
+		GetAudit(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", target, w.Code)
+		}
+	}
+}
+
+// TestDiffUsers tests the field changes of creates, updates and deletes.
+func TestDiffUsers(t *testing.T) {
+	before := models.User{ID: 1, Name: "Sam", Age: 38, Email: "sam@tolkien.com"}
+	after := models.User{ID: 1, Name: "Sam", Age: 38, Email: "sam@tolkien.com", Locale: "en"}
+
+	if changes := models.DiffUsers(&before, &after); len(changes) != 1 || changes[0] != (models.FieldChange{Field: "locale", Before: "", After: "en"}) {
+		t.Errorf("Unexpected update changes %+v", changes)
+	}
+	if changes := models.DiffUsers(&before, &before); len(changes) != 0 {
+		t.Errorf("Expected no changes, got %+v", changes)
+	}
+	if changes := models.DiffUsers(nil, &before); len(changes) != 3 || changes[0].Before != nil {
+		t.Errorf("Expected the non-empty fields of the created user, got %+v", changes)
+	}
+}
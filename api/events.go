@@ -0,0 +1,281 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"user_api_with_concurrency/models"
+	"user_api_with_concurrency/utils"
+)
+
+// ErrEventsExpired is returned when a stream is resumed from an event that is no longer buffered.
+var ErrEventsExpired = errors.New("events are no longer available")
+
+// eventTypes lists the types of user change events.
+var eventTypes = []string{utils.OpCreate, utils.OpUpdate, utils.OpDelete}
+
+// Settings of the change stream.
+var (
+	events          = newEventLog(1000, notifyWebhooks) // Log of recent user changes and their subscribers.
+	eventsHeartbeat = 15 * time.Second                  // Interval of the empty lines that keep idle streams alive.
+)
+
+// init initializes the change stream settings.
+// EVENTS_BUFFER sets how many recent events are kept for resuming streams (default 1000), and
+// EVENTS_HEARTBEAT sets the interval of the heartbeats sent on idle streams (default 15s).
+func init() {
+	if v := os.Getenv("EVENTS_BUFFER"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			log.Printf("Ignoring invalid EVENTS_BUFFER %q\n", v)
+		} else {
+			events = newEventLog(n, notifyWebhooks)
+		}
+	}
+
+	if v := os.Getenv("EVENTS_HEARTBEAT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Printf("Ignoring invalid EVENTS_HEARTBEAT %q\n", v)
+		} else {
+			eventsHeartbeat = d
+		}
+	}
+}
+
+// subscriberBuffer is the number of events queued for a subscriber before it is dropped.
+const subscriberBuffer = 256
+
+// eventLog assigns monotonic IDs to user changes, keeps the most recent ones in a ring buffer
+// and fans them out to the subscribers.
+type eventLog struct {
+	mu          sync.Mutex
+	lastID      int64                              // ID of the last published event.
+	buffer      []models.UserEvent                 // Ring buffer of the most recent events.
+	start       int                                // Index of the oldest event in the buffer.
+	size        int                                // Number of events in the buffer.
+	subscribers map[chan models.UserEvent]struct{} // Channels of the live subscribers.
+	notify      func(models.UserEvent)             // Called with every event before it is sent; may be nil.
+}
+
+// newEventLog creates an event log that keeps the given number of recent events
+// and calls notify, if not nil, with every event.
+func newEventLog(capacity int, notify func(models.UserEvent)) *eventLog {
+	return &eventLog{
+		buffer:      make([]models.UserEvent, capacity),
+		subscribers: make(map[chan models.UserEvent]struct{}),
+		notify:      notify,
+	}
+}
+
+// publish records a change of a user and sends it to the subscribers.
+// Handlers call it while holding usersMu, so events follow the order of the mutations.
+// It never blocks: a subscriber whose queue is full is dropped, and may resume from its last event.
+func (l *eventLog) publish(typ string, user models.User) models.UserEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.lastID++
+	event := models.UserEvent{ID: l.lastID, Type: typ, Time: time.Now().UTC(), User: user}
+
+	// Append the event to the ring buffer, overwriting the oldest one when it is full.
+	if l.size < len(l.buffer) {
+		l.buffer[(l.start+l.size)%len(l.buffer)] = event
+		l.size++
+	} else {
+		l.buffer[l.start] = event
+		l.start = (l.start + 1) % len(l.buffer)
+	}
+
+	if l.notify != nil {
+		l.notify(event)
+	}
+
+	for ch := range l.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(l.subscribers, ch) // The subscriber is too slow; closing ends its stream.
+			close(ch)
+		}
+	}
+	return event
+}
+
+// subscribe registers a subscriber and returns the buffered events published after the given ID.
+// A negative since only subscribes to new events. If events after since were already dropped
+// from the buffer, or since is ahead of the log (e.g. after a server restart), ErrEventsExpired is returned.
+// The channel is closed when the subscriber falls behind; call unsubscribe when done.
+func (l *eventLog) subscribe(since int64) ([]models.UserEvent, chan models.UserEvent, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var backlog []models.UserEvent
+	if since >= 0 {
+		oldest := l.lastID - int64(l.size) + 1 // ID of the oldest buffered event.
+		if since > l.lastID || since+1 < oldest {
+			return nil, nil, fmt.Errorf("%w after ID %d (buffered: %d to %d)", ErrEventsExpired, since, oldest, l.lastID)
+		}
+		for i := since + 1 - oldest; i < int64(l.size); i++ {
+			backlog = append(backlog, l.buffer[(l.start+int(i))%len(l.buffer)])
+		}
+	}
+
+	ch := make(chan models.UserEvent, subscriberBuffer)
+	l.subscribers[ch] = struct{}{}
+	return backlog, ch, nil
+}
+
+// unsubscribe removes a subscriber, unless it was already dropped.
+func (l *eventLog) unsubscribe(ch chan models.UserEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.subscribers[ch]; ok {
+		delete(l.subscribers, ch)
+		close(ch)
+	}
+}
+
+// eventFilter selects the events sent to a subscriber.
+type eventFilter struct {
+	types []string // Event types to keep; empty keeps all types.
+	ids   []int    // User IDs to keep; empty keeps all users.
+}
+
+// parseEventFilter reads the "type" and "id" query parameters.
+// Both accept comma-separated lists and may be repeated.
+func parseEventFilter(r *http.Request) (eventFilter, error) {
+	var filter eventFilter
+	query := r.URL.Query()
+
+	for _, value := range query["type"] {
+		for _, typ := range strings.Split(value, ",") {
+			typ = strings.ToLower(strings.TrimSpace(typ))
+			if !slices.Contains(eventTypes, typ) {
+				return filter, fmt.Errorf("unknown event type %q (expected one of %s)", typ, strings.Join(eventTypes, ", "))
+			}
+			filter.types = append(filter.types, typ)
+		}
+	}
+
+	for _, value := range query["id"] {
+		for _, s := range strings.Split(value, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil || id <= 0 {
+				return filter, fmt.Errorf("invalid user ID %q", s)
+			}
+			filter.ids = append(filter.ids, id)
+		}
+	}
+	return filter, nil
+}
+
+// match reports whether an event passes the filter.
+func (f eventFilter) match(event models.UserEvent) bool {
+	if len(f.types) > 0 && !slices.Contains(f.types, event.Type) {
+		return false
+	}
+	return len(f.ids) == 0 || slices.Contains(f.ids, event.User.ID)
+}
+
+// eventView is the representation of an event in a response, with the user redacted for the caller.
+type eventView struct {
+	ID   int64     `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	User any       `json:"user"`
+}
+
+// StreamChanges streams the changes of the user set as JSON Lines, one event per line, as they happen.
+// The "type" and "id" query parameters filter the events by type and user ID. With "since", the
+// buffered events after that event ID are sent first, so a client can resume after a disconnect;
+// 410 (Gone) is returned if they are no longer buffered. Empty lines are sent as heartbeats.
+// Users are redacted like in the other responses (see callerRedaction).
+func StreamChanges(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest) // Return 400 if a filter is invalid.
+		return
+	}
+
+	redaction, err := callerRedaction(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest) // Return 400 if the redaction profile is unknown.
+		return
+	}
+
+	since := int64(-1)
+	if r.URL.Query().Has("since") {
+		since, err = strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+		if err != nil || since < 0 {
+			http.Error(w, "Invalid event ID", http.StatusBadRequest) // Return 400 if since is invalid.
+			return
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	backlog, ch, err := events.subscribe(since)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusGone) // Return 410 if the client must start over.
+		return
+	}
+	defer events.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush() // Send the headers right away, so the client knows it is subscribed.
+
+	enc := json.NewEncoder(w)
+	send := func(event models.UserEvent) error {
+		if !filter.match(event) {
+			return nil
+		}
+		view := eventView{ID: event.ID, Type: event.Type, Time: event.Time, User: redactUser(event.User, redaction)}
+		return enc.Encode(view)
+	}
+
+	for _, event := range backlog {
+		if err := send(event); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return // The client disconnected.
+		case event, ok := <-ch:
+			if !ok {
+				return // The client fell behind; it may resume from its last event.
+			}
+			if err := send(event); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := w.Write([]byte("\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"user_api_with_concurrency/models"
+	"user_api_with_concurrency/utils"
+)
+
+// TestEventLog_Resume tests that events are replayed from the ring buffer,
+// and that resuming from an overwritten or unknown event fails.
+func TestEventLog_Resume(t *testing.T) {
+	el := newEventLog(3, nil)
+	for i := 1; i <= 5; i++ {
+		el.publish(utils.OpCreate, models.User{ID: i})
+	}
+
+	backlog, ch, err := el.subscribe(2)
+	if err != nil {
+		t.Fatalf("subscribe failed: %v", err)
+	}
+	el.unsubscribe(ch)
+	if len(backlog) != 3 || backlog[0].ID != 3 || backlog[2].ID != 5 {
+		t.Errorf("Expected events 3 to 5, got %+v", backlog)
+	}
+
+	for _, since := range []int64{1, 6} {
+		if _, _, err := el.subscribe(since); !errors.Is(err, ErrEventsExpired) {
+			t.Errorf("Expected ErrEventsExpired after %d, got %v", since, err)
+		}
+	}
+}
+
+// TestEventLog_SlowSubscriber tests that a subscriber that falls behind is dropped
+// instead of blocking the writers.
+func TestEventLog_SlowSubscriber(t *testing.T) {
+	el := newEventLog(10, nil)
+	_, ch, _ := el.subscribe(-1)
+	defer el.unsubscribe(ch)
+
+	for i := 0; i <= subscriberBuffer; i++ {
+		el.publish(utils.OpUpdate, models.User{ID: 1})
+	}
+
+	n := 0
+	for range ch {
+		n++
+	}
+	if n != subscriberBuffer {
+		t.Errorf("Expected %d queued events before the drop, got %d", subscriberBuffer, n)
+	}
+}
+
+// TestStreamChanges tests the change stream endpoint with filters and a resume point.
+func TestStreamChanges(t *testing.T) {
+	since := events.publish(utils.OpCreate, models.User{ID: 1, Name: "Frodo Baggins"}).ID - 1
+	events.publish(utils.OpCreate, models.User{ID: 2, Name: "Sam Gamgee"})
+	events.publish(utils.OpDelete, models.User{ID: 1, Name: "Frodo Baggins"})
+
+	ts := httptest.NewServer(http.HandlerFunc(StreamChanges))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/users/changes?id=1&since=" + strconv.FormatInt(since, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Unexpected Content-Type %q", ct)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for _, want := range []string{utils.OpCreate, utils.OpDelete} {
+		if !scanner.Scan() {
+			t.Fatalf("Stream ended early: %v", scanner.Err())
+		}
+		var event models.UserEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatal(err)
+		}
+		if event.Type != want || event.User.ID != 1 {
+			t.Errorf("Expected a %s event of user 1, got %+v", want, event)
+		}
+	}
+}
+
+// TestStreamChanges_Errors tests the responses to invalid filters and expired resume points.
+func TestStreamChanges_Errors(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected int
+	}{
+		{"?type=rename", http.StatusBadRequest},
+		{"?id=abc", http.StatusBadRequest},
+		{"?since=-1", http.StatusBadRequest},
+		{"?since=999999999", http.StatusGone},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		StreamChanges(w, httptest.NewRequest(http.MethodGet, "/users/changes"+tt.query, nil))
+		if w.Code != tt.expected {
+			t.Errorf("%s: expected status %d, got %d", tt.query, tt.expected, w.Code)
+		}
+	}
+}
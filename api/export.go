@@ -0,0 +1,121 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"user_api_with_concurrency/models"
+	"user_api_with_concurrency/utils"
+)
+
+// contentTypes maps export formats to the Content-Type of the response.
+var contentTypes = map[utils.Format]string{
+	utils.FormatCSV:   "text/csv; charset=utf-8",
+	utils.FormatTSV:   "text/tab-separated-values; charset=utf-8",
+	utils.FormatJSONL: "application/x-ndjson",
+	utils.FormatJSON:  "application/json",
+	utils.FormatXML:   "application/xml",
+}
+
+// ExportUsers streams the current user set to the response in the requested format.
+// It accepts the format (default "csv"), an optional comma-separated list of columns,
+// and the data-processing pipeline parameters, which override the configured pipeline.
+// Records are redacted by the configured profile, then by the caller's profile (see callerRedaction).
+// Users are looked up one at a time, so the response is sent with chunked encoding
+// and the encoded dataset is never held in memory.
+func ExportUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	// Validate the format.
+	format := utils.FormatCSV
+	if query.Has("format") {
+		var err error
+		if format, err = utils.ParseFormat(query.Get("format")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest) // Return 400 if the format is unknown.
+			return
+		}
+	}
+
+	// Validate the selected columns.
+	var columns []string
+	if query.Has("columns") {
+		columns = strings.Split(query.Get("columns"), ",")
+	}
+	columns, err := utils.ParseColumns(columns)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest) // Return 400 if a column is unknown.
+		return
+	}
+
+	// Build the pipeline from the configured one, overridden by the request parameters.
+	config, err := utils.PipelineConfigFromParams(utils.ActivePipelineConfig(), query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest) // Return 400 if the pipeline is invalid.
+		return
+	}
+	pipeline, err := config.Build()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Chain the configured redaction with the caller's.
+	redaction, err := callerRedaction(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest) // Return 400 if the redaction profile is unknown.
+		return
+	}
+	if config.Redact != "" {
+		configured, err := utils.LookupRedactionProfile(config.Redact)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError) // The configuration itself is invalid.
+			return
+		}
+		redaction = utils.CombineRedactionProfiles(configured, redaction)
+	}
+
+	w.Header().Set("Content-Type", contentTypes[format])
+	w.Header().Set("Content-Disposition", `attachment; filename="users.`+string(format)+`"`)
+	w.WriteHeader(http.StatusOK) // Return 200 (OK) status code before streaming the body.
+
+	opts := utils.ExportOptions{Format: format, Columns: columns, Pipeline: pipeline, Redaction: &redaction}
+	if _, err := utils.ExportUsers(streamStoredUsers(r), w, opts); err != nil {
+		log.Println("Failed to stream export:", err) // The status is already sent, so only log.
+	}
+}
+
+// streamStoredUsers sends the stored users to a channel in ID order.
+// Only the IDs are snapshotted; each user is read under the lock just before it is sent,
+// so slow clients never hold the lock. Users deleted in the meantime are skipped.
+// The goroutine stops early if the request is cancelled.
+func streamStoredUsers(r *http.Request) <-chan models.User {
+	usersMu.Lock() // Lock the mutex to ensure thread-safe access.
+	ids := make([]int, 0, len(users))
+	for id := range users {
+		ids = append(ids, id)
+	}
+	usersMu.Unlock() // Unlock the mutex.
+	slices.Sort(ids)
+
+	userChan := make(chan models.User)
+	go func() {
+		defer close(userChan) // Close the channel when done.
+		for _, id := range ids {
+			usersMu.Lock()
+			user, exists := users[id]
+			usersMu.Unlock()
+			if !exists {
+				continue
+			}
+
+			select {
+			case userChan <- user:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}()
+
+	return userChan
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"user_api_with_concurrency/models"
+)
+
+// setUsers replaces the stored users for the duration of a test.
+// The ID counter is restored as well, for tests that create users.
+func setUsers(t *testing.T, list ...models.User) {
+	usersMu.Lock()
+	old, oldNextID := users, nextID
+	users = make(map[int]models.User, len(list))
+	for _, u := range list {
+		users[u.ID] = u
+	}
+	usersMu.Unlock()
+
+	t.Cleanup(func() {
+		usersMu.Lock()
+		users, nextID = old, oldNextID
+		usersMu.Unlock()
+	})
+}
+
+// TestExportUsers tests the ExportUsers function.
+// It verifies the format, column selection and pipeline parameters of the export.
+func TestExportUsers(t *testing.T) {
+	setUsers(t,
+		models.User{ID: 2, Name: "aragorn elessar", Age: 37, Email: "aragorn@tolkien.com"},
+		models.User{ID: 1, Name: "erick rettozi", Age: 48, Email: "erettozi@tolkien.com"},
+		models.User{ID: 3, Name: "pippin took", Age: 17, Email: "pippin@tolkien.com"},
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/users/export?format=jsonl&columns=id,name&min_age=0", nil)
+	w := httptest.NewRecorder()
+
+	ExportUsers(w, req)
+
+	// Check if the status code is 200 (OK).
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Expected Content-Type application/x-ndjson, got %q", ct)
+	}
+
+	// Verify the users are exported in ID order with only the selected columns.
+	expected := `{"id":1,"name":"Erick Rettozi"}` + "\n" +
+		`{"id":2,"name":"Aragorn Elessar"}` + "\n" +
+		`{"id":3,"name":"Pippin Took"}` + "\n"
+	if w.Body.String() != expected {
+		t.Errorf("Unexpected export:\n%s", w.Body.String())
+	}
+}
+
+// TestExportUsers_InvalidParams tests that invalid export parameters return 400 (Bad Request).
+func TestExportUsers_InvalidParams(t *testing.T) {
+	for _, query := range []string{"format=parquet", "columns=id,password", "min_age=abc"} {
+		req := httptest.NewRequest(http.MethodGet, "/users/export?"+query, nil)
+		w := httptest.NewRecorder()
+
+		ExportUsers(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d for %q, got %d", http.StatusBadRequest, query, w.Code)
+		}
+		if strings.Contains(w.Header().Get("Content-Disposition"), "attachment") {
+			t.Errorf("Expected no attachment for %q", query)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"user_api_with_concurrency/utils"
+)
+
+// snapshotList is the response of GET /exports.
+type snapshotList struct {
+	Latest    string               `json:"latest"`    // Name of the newest snapshot.
+	Snapshots []utils.SnapshotInfo `json:"snapshots"` // Stored snapshots, newest first.
+}
+
+// ListExports returns the stored export snapshots, newest first.
+// It returns 404 if export snapshots are disabled.
+func ListExports(w http.ResponseWriter, r *http.Request) {
+	store := utils.Snapshots()
+	if store == nil {
+		http.Error(w, "Export snapshots are disabled", http.StatusNotFound) // Return 404 if snapshots are disabled.
+		return
+	}
+
+	snapshots, err := store.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError) // Return 500 if the directory cannot be read.
+		return
+	}
+	list := snapshotList{Snapshots: snapshots}
+	if list.Snapshots == nil {
+		list.Snapshots = []utils.SnapshotInfo{}
+	}
+	list.Latest, _ = store.Latest() // Empty if no snapshot was written yet.
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)    // Return 200 (OK) status code.
+	json.NewEncoder(w).Encode(list) // Return the list of snapshots as JSON.
+}
+
+// DownloadExport sends a stored export snapshot by name; "latest" selects the newest one.
+// Range and conditional requests are supported.
+func DownloadExport(w http.ResponseWriter, r *http.Request) {
+	store := utils.Snapshots()
+	if store == nil {
+		http.Error(w, "Export snapshots are disabled", http.StatusNotFound) // Return 404 if snapshots are disabled.
+		return
+	}
+
+	// Extract the snapshot name from the URL, as for user IDs.
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 3 || parts[2] == "" {
+		http.Error(w, "Invalid URL", http.StatusBadRequest) // Return 400 if the URL is invalid.
+		return
+	}
+
+	file, info, err := store.Open(parts[2])
+	if errors.Is(err, utils.ErrSnapshotNotFound) {
+		http.Error(w, "Snapshot not found", http.StatusNotFound) // Return 404 if the snapshot doesn't exist.
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close() // Ensure the file is closed after sending it.
+
+	// Describe the content from the snapshot extension.
+	contentType := "application/octet-stream"
+	if format, compress, err := utils.FormatFromFilename(info.Name); err == nil {
+		contentType = contentTypes[format]
+		if compress {
+			contentType = "application/gzip"
+		}
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+info.Name+`"`)
+
+	http.ServeContent(w, r, info.Name, info.CreatedAt, file) // Stream the snapshot with Range support.
+}
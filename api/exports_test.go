@@ -0,0 +1,58 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"user_api_with_concurrency/utils"
+)
+
+// TestExportSnapshots tests the ListExports and DownloadExport functions.
+func TestExportSnapshots(t *testing.T) {
+	store, err := utils.NewSnapshotStore(t.TempDir(), "users", 0, 0)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	utils.SetSnapshots(store)
+	defer utils.SetSnapshots(nil)
+
+	snapshot, err := store.Write(".csv", func(w io.Writer) error {
+		_, err := io.WriteString(w, "ID,Name,Age,Email\n")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+
+	// List the snapshots.
+	w := httptest.NewRecorder()
+	ListExports(w, httptest.NewRequest(http.MethodGet, "/exports", nil))
+
+	var list snapshotList
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if list.Latest != snapshot.Name || len(list.Snapshots) != 1 {
+		t.Errorf("Unexpected snapshot list: %+v", list)
+	}
+
+	// Download the latest snapshot.
+	w = httptest.NewRecorder()
+	DownloadExport(w, httptest.NewRequest(http.MethodGet, "/exports/latest", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+	if w.Header().Get("Content-Type") != "text/csv; charset=utf-8" || w.Body.String() != "ID,Name,Age,Email\n" {
+		t.Errorf("Unexpected download: %q %q", w.Header().Get("Content-Type"), w.Body.String())
+	}
+
+	// Unknown snapshots return 404.
+	w = httptest.NewRecorder()
+	DownloadExport(w, httptest.NewRequest(http.MethodGet, "/exports/users-19700101T000000.000000000Z.csv", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
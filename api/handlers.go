@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
@@ -20,33 +21,49 @@ var (
 // CreateUser handles the creation of a new user.
 // It decodes the JSON payload from the request, assigns a unique ID, and stores the user in the map.
 func CreateUser(w http.ResponseWriter, r *http.Request) {
+	audit := newAuditContext(w, r) // Identify the caller for the audit log.
+
+	redaction, err := callerRedaction(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest) // Return 400 if the redaction profile is unknown.
+		return
+	}
+
 	var user models.User
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest) // Return 400 if the payload is invalid.
 		return
 	}
 
-	usersMu.Lock()       // Lock the mutex to ensure thread-safe access.
-	user.ID = nextID     // Assign the next available ID to the user.
-	users[nextID] = user // Add the user to the map.
-	nextID++             // Increment the ID counter.
-	usersMu.Unlock()     // Unlock the mutex.
-
-	utils.SendUsersToCSV(users) // Export the updated user list to a CSV file.
+	usersMu.Lock()                           // Lock the mutex to ensure thread-safe access.
+	user.ID = nextID                         // Assign the next available ID to the user.
+	users[nextID] = user                     // Add the user to the map.
+	nextID++                                 // Increment the ID counter.
+	exportChange(utils.OpCreate, user)       // Export the change while the map is consistent.
+	events.publish(utils.OpCreate, user)     // Notify the change stream.
+	audit.record(utils.OpCreate, nil, &user) // Record who created the user.
+	usersMu.Unlock()                         // Unlock the mutex.
 
-	w.WriteHeader(http.StatusCreated) // Return 201 (Created) status code.
-	json.NewEncoder(w).Encode(user)   // Return the created user as JSON.
+	w.WriteHeader(http.StatusCreated)                      // Return 201 (Created) status code.
+	json.NewEncoder(w).Encode(redactUser(user, redaction)) // Return the created user as JSON.
 }
 
 // GetUsers retrieves all users from the map and returns them as a JSON array.
+// The users are redacted according to the caller's role and the "redact" query parameter.
 func GetUsers(w http.ResponseWriter, r *http.Request) {
+	redaction, err := callerRedaction(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest) // Return 400 if the redaction profile is unknown.
+		return
+	}
+
 	usersMu.Lock()         // Lock the mutex to ensure thread-safe access.
 	defer usersMu.Unlock() // Ensure the mutex is unlocked when the function exits.
 
 	// Convert the map of users to a slice.
-	userList := make([]models.User, 0, len(users))
+	userList := make([]any, 0, len(users))
 	for _, user := range users {
-		userList = append(userList, user)
+		userList = append(userList, redactUser(user, redaction))
 	}
 
 	w.WriteHeader(http.StatusOK)        // Return 200 (OK) status code.
@@ -64,6 +81,12 @@ func GetUserByID(w http.ResponseWriter, r *http.Request) {
 		return // If the ID is invalid, return an error response.
 	}
 
+	redaction, err := callerRedaction(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest) // Return 400 if the redaction profile is unknown.
+		return
+	}
+
 	usersMu.Lock()         // Lock the mutex to ensure thread-safe access.
 	defer usersMu.Unlock() // Ensure the mutex is unlocked when the function exits.
 
@@ -73,8 +96,8 @@ func GetUserByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)    // Return 200 (OK) status code.
-	json.NewEncoder(w).Encode(user) // Return the user as JSON.
+	w.WriteHeader(http.StatusOK)                           // Return 200 (OK) status code.
+	json.NewEncoder(w).Encode(redactUser(user, redaction)) // Return the user as JSON.
 }
 
 // UpdateUser updates an existing user by their ID.
@@ -84,6 +107,13 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		return // If the ID is invalid, return an error response.
 	}
+	audit := newAuditContext(w, r) // Identify the caller for the audit log.
+
+	redaction, err := callerRedaction(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest) // Return 400 if the redaction profile is unknown.
+		return
+	}
 
 	var updatedUser models.User
 	if err := json.NewDecoder(r.Body).Decode(&updatedUser); err != nil {
@@ -101,15 +131,62 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Update the user's fields.
+	before := user // Keep the previous version for the audit log.
 	user.Name = updatedUser.Name
 	user.Age = updatedUser.Age
 	user.Email = updatedUser.Email
+	user.Locale = updatedUser.Locale
 	users[id] = user // Save the updated user back to the map.
 
-	utils.SendUsersToCSV(users) // Export the updated user list to a CSV file.
+	exportChange(utils.OpUpdate, user)           // Export the change.
+	events.publish(utils.OpUpdate, user)         // Notify the change stream.
+	audit.record(utils.OpUpdate, &before, &user) // Record who changed which fields.
 
-	w.WriteHeader(http.StatusOK)    // Return 200 (OK) status code.
-	json.NewEncoder(w).Encode(user) // Return the updated user as JSON.
+	w.WriteHeader(http.StatusOK)                           // Return 200 (OK) status code.
+	json.NewEncoder(w).Encode(redactUser(user, redaction)) // Return the updated user as JSON.
+}
+
+// PatchUser updates only the fields present in the payload of an existing user.
+// Unlike UpdateUser, it never needs the current user, and fields sent back with the redacted value
+// the caller is shown are ignored, so clients that receive redacted users cannot write it back.
+func PatchUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := extractUserID(r, w) // Extract the user ID from the URL.
+	if !ok {
+		return // If the ID is invalid, return an error response.
+	}
+	audit := newAuditContext(w, r) // Identify the caller for the audit log.
+
+	redaction, err := callerRedaction(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest) // Return 400 if the redaction profile is unknown.
+		return
+	}
+
+	var patch models.UserPatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest) // Return 400 if the payload is invalid.
+		return
+	}
+
+	usersMu.Lock()         // Lock the mutex to ensure thread-safe access.
+	defer usersMu.Unlock() // Ensure the mutex is unlocked when the function exits.
+
+	before, exists := users[id] // Retrieve the user from the map.
+	if !exists {
+		http.Error(w, "User not found", http.StatusNotFound) // Return 404 if the user doesn't exist.
+		return
+	}
+
+	dropRedactedEchoes(&patch, before, redaction) // Keep the fields sent back as they were shown.
+	user := patch.Apply(before)                   // Update the fields present in the payload.
+	users[id] = user                              // Save the updated user back to the map.
+
+	exportChange(utils.OpUpdate, user)           // Export the change.
+	events.publish(utils.OpUpdate, user)         // Notify the change stream.
+	audit.record(utils.OpUpdate, &before, &user) // Record who changed which fields.
+
+	w.WriteHeader(http.StatusOK)                           // Return 200 (OK) status code.
+	json.NewEncoder(w).Encode(redactUser(user, redaction)) // Return the updated user as JSON.
 }
 
 // DeleteUser deletes a user by their ID.
@@ -119,18 +196,22 @@ func DeleteUser(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		return // If the ID is invalid, return an error response.
 	}
+	audit := newAuditContext(w, r) // Identify the caller for the audit log.
 
 	usersMu.Lock()         // Lock the mutex to ensure thread-safe access.
 	defer usersMu.Unlock() // Ensure the mutex is unlocked when the function exits.
 
-	if _, exists := users[id]; !exists {
+	user, exists := users[id]
+	if !exists {
 		http.Error(w, "User not found", http.StatusNotFound) // Return 404 if the user doesn't exist.
 		return
 	}
 
 	delete(users, id) // Delete the user from the map.
 
-	utils.SendUsersToCSV(users) // Export the updated user list to a CSV file.
+	exportChange(utils.OpDelete, user)       // Export the change.
+	events.publish(utils.OpDelete, user)     // Notify the change stream.
+	audit.record(utils.OpDelete, &user, nil) // Record who deleted the user.
 
 	w.WriteHeader(http.StatusNoContent) // Return 204 (No Content) status code.
 }
@@ -152,3 +233,33 @@ func extractUserID(r *http.Request, w http.ResponseWriter) (int, bool) {
 
 	return id, true // Return the valid ID.
 }
+
+// exportChange exports a change of a user. The caller must hold usersMu.
+// In incremental mode, the change is appended to the changelog; otherwise the whole user list is exported.
+func exportChange(op string, user models.User) {
+	if !appendChange(op, user) {
+		utils.SendUsersToCSV(users) // Export the updated user list to the export file.
+	}
+}
+
+// appendChange appends a change to the changelog in incremental mode and reports whether it did.
+// The caller must hold usersMu, so the changelog follows the order of the mutations and
+// compaction snapshots are consistent with it.
+func appendChange(op string, user models.User) bool {
+	changes := utils.Changes()
+	if changes == nil {
+		return false
+	}
+
+	err := changes.Append(op, user, func() []models.User {
+		snapshot := make([]models.User, 0, len(users))
+		for _, u := range users {
+			snapshot = append(snapshot, u)
+		}
+		return snapshot
+	})
+	if err != nil {
+		log.Println("Failed to append to changelog:", err)
+	}
+	return true
+}
@@ -3,10 +3,15 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"user_api_with_concurrency/models"
+	"user_api_with_concurrency/utils"
 )
 
 // TestCreateUser tests the CreateUser function.
@@ -121,6 +126,30 @@ func TestUpdateUser(t *testing.T) {
 	}
 }
 
+// TestPatchUser tests the PatchUser function.
+// It sends a PATCH request with only the email and verifies that the other fields are kept.
+func TestPatchUser(t *testing.T) {
+	// Pre-populate the users map with a test user.
+	users[1] = models.User{ID: 1, Name: "Erick Rettozi", Age: 48, Email: "erettozi@tolkien.com"}
+
+	payload := []byte(`{"email":"aragorn@tolkien.com"}`)
+	req := httptest.NewRequest(http.MethodPatch, "/users/1", bytes.NewBuffer(payload))
+	w := httptest.NewRecorder()
+
+	PatchUser(w, req)
+
+	// Check if the status code is 200 (OK).
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+
+	// Verify that only the email has been updated.
+	expected := models.User{ID: 1, Name: "Erick Rettozi", Age: 48, Email: "aragorn@tolkien.com"}
+	if users[1] != expected {
+		t.Errorf("Expected %+v after the patch, got %+v", expected, users[1])
+	}
+}
+
 // TestDeleteUser tests the DeleteUser function.
 // It sends a DELETE request to delete a specific user by ID and verifies the response.
 func TestDeleteUser(t *testing.T) {
@@ -142,3 +171,35 @@ func TestDeleteUser(t *testing.T) {
 		t.Error("User was not deleted")
 	}
 }
+
+// TestIncrementalExport tests that, in incremental mode, the handlers append one change per mutation.
+func TestIncrementalExport(t *testing.T) {
+	setUsers(t)
+	dir := t.TempDir()
+	changes, err := utils.NewChangeLog(dir, 1<<20, 0, utils.ExportOptions{Format: utils.FormatCSV})
+	if err != nil {
+		t.Fatalf("Failed to open changelog: %v", err)
+	}
+	utils.SetChanges(changes)
+	defer utils.SetChanges(nil)
+
+	payload := []byte(`{"name":"Erick Rettozi","age":48,"email":"erettozi@tolkien.com"}`)
+	w := httptest.NewRecorder()
+	CreateUser(w, httptest.NewRequest(http.MethodPost, "/users", bytes.NewBuffer(payload)))
+
+	var user models.User
+	json.NewDecoder(w.Body).Decode(&user)
+	w = httptest.NewRecorder()
+	DeleteUser(w, httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/users/%d", user.ID), nil))
+	changes.Close()
+
+	// Verify the changelog holds the create and the delete, in order.
+	data, err := os.ReadFile(filepath.Join(dir, "changes-000000000001.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to read changelog: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"op":"create"`) || !strings.Contains(lines[1], `"op":"delete"`) {
+		t.Errorf("Unexpected changelog:\n%s", data)
+	}
+}
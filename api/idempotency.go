@@ -0,0 +1,214 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// IdempotencyKeyHeader carries the key that makes a retried request return the response of the first one.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// Settings of idempotent requests.
+const (
+	maxIdempotencyKey  = 255     // Maximum length of an idempotency key.
+	maxIdempotentBody  = 1 << 20 // Maximum size of the body of an idempotent request (1 MiB).
+	idempotencySweepAt = 1000    // Number of stored keys above which expired keys are swept.
+)
+
+var (
+	idempotencyTTL  = 24 * time.Hour   // Time responses are kept for replays.
+	idempotencyWait = 10 * time.Second // Time a request waits for a concurrent one with the same key before 409.
+)
+
+// init reads the retention of idempotency keys from IDEMPOTENCY_TTL (default 24h).
+func init() {
+	if v := os.Getenv("IDEMPOTENCY_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Printf("Ignoring invalid IDEMPOTENCY_TTL %q\n", v)
+		} else {
+			idempotencyTTL = d
+		}
+	}
+}
+
+// idempotentResponse is a stored request: while it runs, done is open; once it completes, done is
+// closed and the fields hold its response.
+type idempotentResponse struct {
+	fingerprint [sha256.Size]byte // Hash of the request body, to reject a key reused for another request.
+	done        chan struct{}
+	status      int
+	header      http.Header
+	body        []byte
+	expires     time.Time
+}
+
+// idempotencyStore holds the responses of idempotent requests by caller and key.
+type idempotencyStore struct {
+	mu        sync.Mutex
+	responses map[string]*idempotentResponse
+}
+
+// idempotency is the store of the responses of POST /users.
+var idempotency = &idempotencyStore{responses: make(map[string]*idempotentResponse)}
+
+// begin returns the stored request of a key, or stores a new running one and reports it is new.
+// Expired requests are dropped first.
+func (s *idempotencyStore) begin(key string, fingerprint [sha256.Size]byte) (*idempotentResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if len(s.responses) >= idempotencySweepAt {
+		for k, stored := range s.responses {
+			if isExpired(stored, now) {
+				delete(s.responses, k)
+			}
+		}
+	}
+	if stored, ok := s.responses[key]; ok && !isExpired(stored, now) {
+		return stored, false
+	}
+
+	stored := &idempotentResponse{fingerprint: fingerprint, done: make(chan struct{})}
+	s.responses[key] = stored
+	return stored, true
+}
+
+// finish stores the response of a running request, or forgets the key if the response
+// must not be replayed, so that the request can be retried.
+func (s *idempotencyStore) finish(key string, stored *idempotentResponse, w *recordingWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if w.status == 0 || w.status >= 500 {
+		delete(s.responses, key) // The handler failed, or panicked before writing.
+	} else {
+		stored.status, stored.header, stored.body = w.status, w.Header().Clone(), w.body.Bytes()
+		stored.expires = time.Now().Add(idempotencyTTL)
+	}
+	close(stored.done)
+}
+
+// isExpired reports whether a completed request is past its TTL. The caller must hold s.mu.
+func isExpired(stored *idempotentResponse, now time.Time) bool {
+	return !stored.expires.IsZero() && now.After(stored.expires)
+}
+
+// idempotent wraps a handler so that a request with an Idempotency-Key header runs at most once per
+// key and caller: retries with the same key get the stored response of the first request, with the
+// "Idempotent-Replayed: true" header, until it expires after IDEMPOTENCY_TTL. A retry sent while the
+// first request runs waits for it, or gets 409 (Conflict) if it takes too long. Reusing a key with
+// another body returns 422. Responses with a 5xx status are not stored, so they can be retried.
+func idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey || !isPrintableASCII(key) {
+			http.Error(w, "Invalid Idempotency-Key (expected up to 255 printable ASCII characters)", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBody))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge) // Return 413 past 1 MiB.
+			} else {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		storeKey := r.Method + " " + r.URL.Path + "\x00" + idempotencyCaller(r) + "\x00" + key
+		stored, first := idempotency.begin(storeKey, sha256.Sum256(body))
+		if first {
+			recorder := &recordingWriter{ResponseWriter: w}
+			defer func() { idempotency.finish(storeKey, stored, recorder) }()
+			next(recorder, r)
+			recorder.WriteHeader(http.StatusOK) // No-op unless the handler wrote nothing.
+			return
+		}
+
+		if stored.fingerprint != sha256.Sum256(body) {
+			http.Error(w, "Idempotency-Key was already used with another request", http.StatusUnprocessableEntity)
+			return
+		}
+
+		timer := time.NewTimer(idempotencyWait)
+		defer timer.Stop()
+		select {
+		case <-stored.done:
+		case <-timer.C:
+			http.Error(w, "A request with this Idempotency-Key is in progress", http.StatusConflict) // Return 409; retry later.
+			return
+		case <-r.Context().Done():
+			return // The client gave up.
+		}
+		if stored.status == 0 {
+			// The first request failed and was not stored: run this one instead.
+			idempotent(next)(w, r)
+			return
+		}
+
+		for name, values := range stored.header {
+			w.Header()[name] = values
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(stored.status)
+		w.Write(stored.body)
+	}
+}
+
+// idempotencyCaller identifies the caller of a request for its idempotency keys: its credentials,
+// or its IP address without any, and its role, since responses are redacted by role.
+func idempotencyCaller(r *http.Request) string {
+	caller := r.Header.Get("Authorization")
+	if caller == "" {
+		caller, _, _ = net.SplitHostPort(r.RemoteAddr)
+	}
+	sum := sha256.Sum256([]byte(caller + "\x00" + r.Header.Get(roleHeader)))
+	return string(sum[:])
+}
+
+// isPrintableASCII reports whether s only contains printable ASCII characters.
+func isPrintableASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < ' ' || s[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// recordingWriter passes a response through while recording its status and body.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
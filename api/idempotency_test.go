@@ -0,0 +1,136 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"user_api_with_concurrency/models"
+)
+
+// setIdempotency replaces the store of idempotent responses with an empty one for the duration of a test.
+func setIdempotency(t *testing.T) {
+	saved, savedTTL, savedWait := idempotency, idempotencyTTL, idempotencyWait
+	idempotency = &idempotencyStore{responses: make(map[string]*idempotentResponse)}
+	t.Cleanup(func() { idempotency, idempotencyTTL, idempotencyWait = saved, savedTTL, savedWait })
+}
+
+// postUser sends a POST /users request through the idempotency middleware.
+func postUser(body, key string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+	if key != "" {
+		r.Header.Set(IdempotencyKeyHeader, key)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	idempotent(CreateUser)(w, r)
+	return w
+}
+
+// TestIdempotentCreateUser tests that a retried POST /users with the same key replays the first
+// response instead of creating another user, per caller and until the key expires.
+func TestIdempotentCreateUser(t *testing.T) {
+	setUsers(t)
+	setIdempotency(t)
+	const sam = `{"name":"Sam Gamgee","age":38,"email":"sam@tolkien.com"}`
+
+	first := postUser(sam, "create-sam")
+	replay := postUser(sam, "create-sam")
+	if first.Code != http.StatusCreated || replay.Code != http.StatusCreated || replay.Body.String() != first.Body.String() {
+		t.Fatalf("Expected the first response to be replayed, got %d %s and %d %s", first.Code, first.Body, replay.Code, replay.Body)
+	}
+	if first.Header().Get("Idempotent-Replayed") != "" || replay.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("Expected only the replay to be marked, got %v and %v", first.Header(), replay.Header())
+	}
+
+	// Another caller, another key, or no key create new users.
+	postUser(sam, "create-sam", "Authorization", "Bearer other")
+	postUser(sam, "create-sam-2")
+	postUser(sam, "")
+	usersMu.Lock()
+	n := len(users)
+	usersMu.Unlock()
+	if n != 4 {
+		t.Errorf("Expected 4 users, got %d", n)
+	}
+
+	if w := postUser(`{"name":"Frodo Baggins"}`, "create-sam"); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422 when the key is reused with another body, got %d", w.Code)
+	}
+	if w := postUser(sam, strings.Repeat("k", 256)); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a too long key, got %d", w.Code)
+	}
+
+	// Once expired, the key creates a new user.
+	idempotencyTTL = time.Millisecond
+	var a, b models.User
+	json.NewDecoder(postUser(sam, "expiring").Body).Decode(&a)
+	time.Sleep(5 * time.Millisecond)
+	json.NewDecoder(postUser(sam, "expiring").Body).Decode(&b)
+	if a.ID == b.ID {
+		t.Errorf("Expected the expired key to create a new user, got ID %d twice", a.ID)
+	}
+}
+
+// TestIdempotentConcurrent tests that a request with the key of a running request waits for
+// its response, or gets 409 if it runs for too long, and that failures are not replayed.
+func TestIdempotentConcurrent(t *testing.T) {
+	setIdempotency(t)
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	calls := 0
+	handler := idempotent(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		call := calls
+		mu.Unlock()
+		<-release
+		if call == 1 {
+			http.Error(w, "Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	send := func() int {
+		r := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader("{}"))
+		r.Header.Set(IdempotencyKeyHeader, "k")
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	codes := make(chan int, 3)
+	go func() { codes <- send() }()
+	for {
+		mu.Lock()
+		started := calls == 1
+		mu.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	idempotencyWait = 10 * time.Millisecond
+	if code := send(); code != http.StatusConflict {
+		t.Errorf("Expected status 409 while the first request runs, got %d", code)
+	}
+
+	// The waiting request runs the handler itself, since the first one failed.
+	idempotencyWait = 5 * time.Second
+	go func() { codes <- send() }()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	if first, second := <-codes, <-codes; first != http.StatusServiceUnavailable || second != http.StatusCreated {
+		t.Errorf("Expected statuses 503 and 201, got %d and %d", first, second)
+	}
+	if code := send(); code != http.StatusCreated || calls != 2 {
+		t.Errorf("Expected the 201 to be replayed after 2 calls, got %d after %d calls", code, calls)
+	}
+}
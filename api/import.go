@@ -0,0 +1,245 @@
+package api
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"user_api_with_concurrency/models"
+	"user_api_with_concurrency/utils"
+)
+
+const (
+	MaxConcurrentImports = 5        // Number of rows validated and stored concurrently.
+	maxImportSize        = 32 << 20 // Maximum size of an import request body (32 MiB).
+)
+
+// importContentTypes maps request Content-Types to import formats.
+var importContentTypes = map[string]utils.Format{
+	"text/csv":                  utils.FormatCSV,
+	"text/tab-separated-values": utils.FormatTSV,
+	"application/x-ndjson":      utils.FormatJSONL,
+	"application/jsonl":         utils.FormatJSONL,
+}
+
+// ImportUsers imports users from a CSV, TSV or JSON Lines file.
+// The file is sent either as the "file" field of a multipart form or as the raw request body.
+// The format is taken from the "format" query parameter, the uploaded filename or the Content-Type.
+// Every row is validated and stored concurrently; rows with an ID replace the user with that ID.
+// With dry_run=true, rows are only validated. The response is a row-level report.
+func ImportUsers(w http.ResponseWriter, r *http.Request) {
+	audit := newAuditContext(w, r) // Identify the caller for the audit log.
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+
+	dryRun, err := parseDryRun(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest) // Return 400 rather than import for real.
+		return
+	}
+
+	// Locate the file and its format.
+	body, format, err := importSource(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest) // Return 400 if the upload is invalid.
+		return
+	}
+	if !slices.Contains(utils.ImportFormats, format) {
+		http.Error(w, fmt.Sprintf("cannot import format %q", format), http.StatusBadRequest)
+		return
+	}
+	rows, err := utils.ReadUsers(body, format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest) // Return 400 if the header is invalid.
+		return
+	}
+
+	report := importRows(rows, dryRun, audit)
+
+	// In full export mode, export the updated user list once for the whole import.
+	// In incremental mode, every imported row was already appended to the changelog.
+	if report.Imported > 0 && !dryRun && utils.Changes() == nil {
+		usersMu.Lock()
+		utils.SendUsersToCSV(users)
+		usersMu.Unlock()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)      // Return 200 (OK) status code, even if some rows failed.
+	json.NewEncoder(w).Encode(report) // Return the import report as JSON.
+}
+
+// parseDryRun reads the "dry_run" query parameter. A value that is not a boolean is an error,
+// since treating it as false would apply changes the caller only meant to preview.
+func parseDryRun(r *http.Request) (bool, error) {
+	v := r.URL.Query().Get("dry_run")
+	if v == "" {
+		return false, nil
+	}
+	dryRun, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid dry_run %q (expected true or false)", v)
+	}
+	return dryRun, nil
+}
+
+// importSource returns the reader of the uploaded file and its format.
+func importSource(r *http.Request) (io.Reader, utils.Format, error) {
+	var format utils.Format
+	if name := r.URL.Query().Get("format"); name != "" {
+		f, err := utils.ParseFormat(name)
+		if err != nil {
+			return nil, "", err
+		}
+		format = f
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		// Raw body: fall back to the Content-Type to determine the format.
+		if format == "" {
+			if format = importContentTypes[mediaType]; format == "" {
+				return nil, "", fmt.Errorf("cannot determine the import format from Content-Type %q; use ?format=", mediaType)
+			}
+		}
+		return r.Body, format, nil
+	}
+
+	// Multipart form: stream the "file" part without buffering it to disk.
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, "", fmt.Errorf(`missing "file" field`)
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+		f, compress, err := utils.FormatFromFilename(part.FileName())
+		if format == "" {
+			if err != nil {
+				return nil, "", fmt.Errorf("%w; use ?format=", err)
+			}
+			format = f
+		}
+		if compress {
+			// Decompress files exported with a ".gz" extension, e.g. "users.csv.gz".
+			gz, err := gzip.NewReader(part)
+			if err != nil {
+				return nil, "", fmt.Errorf("invalid gzip file %q: %w", part.FileName(), err)
+			}
+			return gz, format, nil
+		}
+		return part, format, nil
+	}
+}
+
+// importRows validates and stores the rows using a pool of workers.
+// Rows are read sequentially so duplicate IDs within the file can be reported deterministically.
+func importRows(rows <-chan utils.ImportRow, dryRun bool, audit auditContext) models.ImportReport {
+	report := models.ImportReport{DryRun: dryRun, Errors: []models.ImportError{}}
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex                                  // Protects the report.
+		semaphore = make(chan struct{}, MaxConcurrentImports) // Semaphore to limit concurrency.
+		seenIDs   = make(map[int]int)                         // Row on which each explicit ID was first seen.
+	)
+
+	fail := func(row int, message string) {
+		mu.Lock()
+		report.Failed++
+		report.Errors = append(report.Errors, models.ImportError{Row: row, Error: message})
+		mu.Unlock()
+	}
+
+	for row := range rows {
+		report.Total++ // Only this goroutine updates the total.
+		if row.Err != nil {
+			fail(row.Row, row.Err.Error())
+			continue
+		}
+		if row.User.ID < 0 {
+			fail(row.Row, fmt.Sprintf("id must not be negative, got %d", row.User.ID))
+			continue
+		}
+		if row.User.ID != 0 {
+			if first, dup := seenIDs[row.User.ID]; dup {
+				fail(row.Row, fmt.Sprintf("duplicate id %d (first seen on row %d)", row.User.ID, first))
+				continue
+			}
+			seenIDs[row.User.ID] = row.Row
+		}
+
+		wg.Add(1)               // Increment the WaitGroup counter.
+		semaphore <- struct{}{} // Acquire a semaphore slot.
+		go func(row utils.ImportRow) {
+			defer wg.Done()                // Notify the WaitGroup that this goroutine is done.
+			defer func() { <-semaphore }() // Release the semaphore slot when done.
+
+			if err := row.User.Validate(); err != nil {
+				fail(row.Row, err.Error())
+				return
+			}
+			if !dryRun {
+				storeImportedUser(row.User, audit)
+			}
+
+			mu.Lock()
+			report.Imported++
+			mu.Unlock()
+		}(row)
+	}
+
+	wg.Wait() // Wait for all goroutines to finish.
+
+	slices.SortFunc(report.Errors, func(a, b models.ImportError) int { return a.Row - b.Row })
+	return report
+}
+
+// storeImportedUser stores an imported user, recording the change in the audit log under the given context.
+// Users without an ID get the next available one; users with an ID replace any existing user with that ID.
+func storeImportedUser(user models.User, audit auditContext) models.User {
+	usersMu.Lock()         // Lock the mutex to ensure thread-safe access.
+	defer usersMu.Unlock() // Ensure the mutex is unlocked when the function exits.
+
+	if user.ID == 0 {
+		user.ID = nextID // Assign the next available ID to the user.
+	}
+	if user.ID >= nextID {
+		nextID = user.ID + 1 // Keep the ID counter ahead of explicit IDs.
+	}
+	op, before := utils.OpCreate, (*models.User)(nil)
+	if existing, exists := users[user.ID]; exists {
+		op, before = utils.OpUpdate, &existing
+	}
+	users[user.ID] = user
+	appendChange(op, user)          // Record the change in incremental mode.
+	events.publish(op, user)        // Notify the change stream.
+	audit.record(op, before, &user) // Record who changed which fields.
+	return user
+}
+
+// SeedUsers stores users as if they were imported, e.g. generated test data at startup.
+// Users without an ID get a new one. In full export mode, the store is exported once afterwards.
+func SeedUsers(list []models.User) {
+	for _, user := range list {
+		storeImportedUser(user, systemAudit("seed"))
+	}
+
+	if len(list) > 0 && utils.Changes() == nil {
+		usersMu.Lock()
+		utils.SendUsersToCSV(users)
+		usersMu.Unlock()
+	}
+}
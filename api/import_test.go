@@ -0,0 +1,212 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"user_api_with_concurrency/models"
+	"user_api_with_concurrency/utils"
+)
+
+// TestImportUsers tests the ImportUsers function with a raw CSV body.
+// It verifies that valid rows are stored and invalid rows are reported with their row number.
+func TestImportUsers(t *testing.T) {
+	setUsers(t)
+
+	body := "Full Name,Age,E-mail,Notes\n" +
+		"Frodo Baggins,50,frodo@tolkien.com,ring bearer\n" +
+		"Samwise Gamgee,abc,sam@tolkien.com,\n" +
+		"Gollum,589,gollum,\n" +
+		"Meriadoc Brandybuck,36,merry@tolkien.com,\n"
+	req := httptest.NewRequest(http.MethodPost, "/users/import", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv")
+	w := httptest.NewRecorder()
+
+	ImportUsers(w, req)
+
+	// Check if the status code is 200 (OK).
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	// Decode the response body into an import report.
+	var report models.ImportReport
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	// Verify the report counts and the row-level errors.
+	if report.Total != 4 || report.Imported != 2 || report.Failed != 2 {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+	if len(report.Errors) != 2 || report.Errors[0].Row != 3 || report.Errors[1].Row != 4 {
+		t.Errorf("Unexpected row errors: %+v", report.Errors)
+	}
+
+	// Verify that the valid rows were stored.
+	usersMu.Lock()
+	defer usersMu.Unlock()
+	if len(users) != 2 {
+		t.Errorf("Expected 2 stored users, got %d", len(users))
+	}
+}
+
+// TestImportUsers_MultipartDryRun tests a multipart JSON Lines upload in dry-run mode.
+// It verifies that explicit IDs are checked for duplicates and nothing is stored.
+func TestImportUsers_MultipartDryRun(t *testing.T) {
+	setUsers(t)
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	part, _ := mw.CreateFormFile("file", "users.jsonl")
+	part.Write([]byte(`{"id":7,"name":"Aragorn Elessar","age":87,"email":"aragorn@tolkien.com"}` + "\n" +
+		"\n" +
+		`{"id":7,"name":"Arwen Undómiel","age":2778,"email":"arwen@tolkien.com"}` + "\n"))
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/users/import?dry_run=true", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+
+	ImportUsers(w, req)
+
+	// Decode the response body into an import report.
+	var report models.ImportReport
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if !report.DryRun || report.Imported != 1 || report.Failed != 1 {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+	if len(report.Errors) != 1 || report.Errors[0].Row != 3 || !strings.Contains(report.Errors[0].Error, "duplicate id 7") {
+		t.Errorf("Unexpected row errors: %+v", report.Errors)
+	}
+
+	// Verify that nothing was stored during the dry run.
+	usersMu.Lock()
+	defer usersMu.Unlock()
+	if len(users) != 0 {
+		t.Errorf("Expected no stored users after a dry run, got %d", len(users))
+	}
+}
+
+// TestImportUsers_GzipRoundTrip tests that a gzip-compressed export is imported back when
+// uploaded with its ".gz" filename.
+func TestImportUsers_GzipRoundTrip(t *testing.T) {
+	setUsers(t)
+	exported := []models.User{
+		{ID: 3, Name: "Frodo Baggins", Age: 50, Email: "frodo@tolkien.com"},
+		{ID: 5, Name: "Sam Gamgee", Age: 38, Email: "sam@tolkien.com"},
+	}
+	path := filepath.Join(t.TempDir(), "users.csv.gz")
+	if _, err := utils.ExportUsersToFile(utils.StreamUsers(exported), path, utils.ExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	part, _ := mw.CreateFormFile("file", "users.csv.gz")
+	part.Write(data)
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/users/import", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+
+	ImportUsers(w, req)
+
+	var report models.ImportReport
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if report.Imported != 2 || report.Failed != 0 {
+		t.Fatalf("Unexpected report: %+v", report)
+	}
+
+	usersMu.Lock()
+	defer usersMu.Unlock()
+	for _, user := range exported {
+		if users[user.ID] != user {
+			t.Errorf("Expected %+v to be imported, got %+v", user, users[user.ID])
+		}
+	}
+}
+
+// TestImportRows_NegativeID tests that rows with a negative ID are rejected, whatever reader produced them,
+// since no /users/{id} request could reach them.
+func TestImportRows_NegativeID(t *testing.T) {
+	setUsers(t)
+	rows := make(chan utils.ImportRow, 1)
+	rows <- utils.ImportRow{Row: 2, User: models.User{ID: -5, Name: "Gollum", Age: 589, Email: "gollum@tolkien.com"}}
+	close(rows)
+
+	report := importRows(rows, false, systemAudit("test"))
+	if report.Imported != 0 || len(report.Errors) != 1 || !strings.Contains(report.Errors[0].Error, "id must not be negative") {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+	usersMu.Lock()
+	defer usersMu.Unlock()
+	if len(users) != 0 {
+		t.Errorf("Expected nothing to be stored, got %v", users)
+	}
+}
+
+// TestImportUsers_InvalidUpload tests that unusable uploads return 400 (Bad Request).
+func TestImportUsers_InvalidUpload(t *testing.T) {
+	setUsers(t)
+	tests := []struct {
+		name, query, contentType, body string
+	}{
+		{"unknown content type", "", "application/octet-stream", "name\nFrodo\n"},
+		{"unmapped header", "", "text/csv", "foo,bar\n1,2\n"},
+		{"empty file", "", "text/csv", ""},
+		{"invalid dry_run", "?dry_run=yes", "text/csv", "name,age,email\nFrodo Baggins,50,frodo@tolkien.com\n"},
+		{"trailing dry_run", "?dry_run=1x", "text/csv", "name,age,email\nFrodo Baggins,50,frodo@tolkien.com\n"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/users/import"+tt.query, strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", tt.contentType)
+		w := httptest.NewRecorder()
+
+		ImportUsers(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status code %d, got %d", tt.name, http.StatusBadRequest, w.Code)
+		}
+	}
+
+	usersMu.Lock()
+	defer usersMu.Unlock()
+	if len(users) != 0 {
+		t.Errorf("Expected nothing to be imported, got %v", users)
+	}
+}
+
+// TestSeedUsers tests that generated users are stored with new IDs.
+func TestSeedUsers(t *testing.T) {
+	setUsers(t)
+	SeedUsers(utils.GenerateUsers(20, 7))
+
+	usersMu.Lock()
+	defer usersMu.Unlock()
+	if len(users) != 20 {
+		t.Fatalf("Expected 20 seeded users, got %d", len(users))
+	}
+	for id, u := range users {
+		if id == 0 || u.ID != id || u.Validate() != nil {
+			t.Errorf("Unexpected seeded user %+v", u)
+		}
+	}
+}
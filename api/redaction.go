@@ -0,0 +1,95 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"user_api_with_concurrency/models"
+	"user_api_with_concurrency/utils"
+)
+
+// RoleHeader is the default header carrying the role of the caller, which selects the redaction
+// profile of responses. Clients can send any role, so the header is only trusted when a proxy in
+// front of the server sets it, replacing any value sent by the client.
+const RoleHeader = "X-User-Role"
+
+// roleHeader is the header trusted to carry the caller's role.
+var roleHeader = RoleHeader
+
+// init reads REDACTION_ROLE_HEADER, the name of the header set by the proxy with the caller's role
+// (default X-User-Role).
+func init() {
+	if v := os.Getenv("REDACTION_ROLE_HEADER"); v != "" {
+		if strings.ContainsAny(v, " :") {
+			log.Printf("Ignoring invalid REDACTION_ROLE_HEADER %q\n", v)
+		} else {
+			roleHeader = http.CanonicalHeaderKey(v)
+		}
+	}
+}
+
+// callerRedaction returns the redaction profile for a request: the profile mapped to the caller's role,
+// followed by the profile named by the "redact" query parameter. Since the profiles are chained,
+// the parameter can only add redaction, never remove what the role requires.
+// Callers without a role get the strictest profile, so omitting the header never reveals more.
+func callerRedaction(r *http.Request) (utils.RedactionProfile, error) {
+	role := utils.StrictestRedactionProfile()
+	if name := r.Header.Get(roleHeader); name != "" {
+		role = utils.RedactionProfileForRole(name)
+	}
+	profiles := []utils.RedactionProfile{role}
+
+	if name := r.URL.Query().Get("redact"); name != "" {
+		profile, err := utils.LookupRedactionProfile(name)
+		if err != nil {
+			return utils.RedactionProfile{}, err
+		}
+		profiles = append(profiles, profile)
+	}
+	return utils.CombineRedactionProfiles(profiles...), nil
+}
+
+// redactUser returns the representation of a user to send in a response.
+// Without redaction the user is returned as is; otherwise it becomes a record with the same
+// field names, from which dropped fields are removed.
+func redactUser(user models.User, profile utils.RedactionProfile) any {
+	if profile.IsNoop() {
+		return user
+	}
+
+	columns := utils.ExportColumns
+	if user.Locale != "" {
+		columns = utils.AvailableColumns // Match the omitempty locale of models.User.
+	}
+	return profile.Apply(utils.NewRecord(user, profile.Columns(columns)))
+}
+
+// dropRedactedEchoes removes from a patch the fields whose value is the one the caller is shown,
+// e.g. a masked email sent back with the rest of the user. A client that only sees redacted users
+// can then send them back without overwriting the real values; fields shown as stored are unchanged anyway.
+func dropRedactedEchoes(patch *models.UserPatch, user models.User, profile utils.RedactionProfile) {
+	if profile.IsNoop() {
+		return
+	}
+	shown := profile.Apply(utils.NewRecord(user, utils.AvailableColumns))
+	echoes := func(field string, value any) bool {
+		i := slices.IndexFunc(shown, func(f utils.Field) bool { return f.Name == field })
+		return i >= 0 && fmt.Sprint(shown[i].Value) == fmt.Sprint(value)
+	}
+
+	if patch.Name != nil && echoes("name", *patch.Name) {
+		patch.Name = nil
+	}
+	if patch.Age != nil && echoes("age", *patch.Age) {
+		patch.Age = nil
+	}
+	if patch.Email != nil && echoes("email", *patch.Email) {
+		patch.Email = nil
+	}
+	if patch.Locale != nil && echoes("locale", *patch.Locale) {
+		patch.Locale = nil
+	}
+}
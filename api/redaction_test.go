@@ -0,0 +1,111 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"user_api_with_concurrency/models"
+	"user_api_with_concurrency/utils"
+)
+
+// setRedactionRoles maps caller roles to redaction profiles for the duration of a test.
+func setRedactionRoles(t *testing.T, roles map[string]string) {
+	if err := utils.SetRedactionRoles(roles, "none"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { utils.SetRedactionRoles(nil, "none") })
+}
+
+// TestGetUserByID_Redaction tests that responses are redacted by the caller's role,
+// and that the redact parameter can only add redaction.
+func TestGetUserByID_Redaction(t *testing.T) {
+	setUsers(t, models.User{ID: 1, Name: "Erick Rettozi", Age: 48, Email: "erettozi@tolkien.com"})
+	setRedactionRoles(t, map[string]string{"support": "masked"})
+
+	tests := []struct {
+		name, role, query, expected string
+	}{
+		{"no role", "", "", `{"id":1,"name":"Erick Rettozi","age":48,"email":"e*******@tolkien.com"}`},
+		{"unmapped role", "admin", "", `{"id":1,"name":"Erick Rettozi","age":48,"email":"erettozi@tolkien.com"}`},
+		{"mapped role", "support", "", `{"id":1,"name":"Erick Rettozi","age":48,"email":"e*******@tolkien.com"}`},
+		{"redact param", "", "?redact=public", `{"id":1,"name":"E**** R******","age":"45-54"}`},
+		{"param cannot weaken role", "support", "?redact=none", `{"id":1,"name":"Erick Rettozi","age":48,"email":"e*******@tolkien.com"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/users/1"+tt.query, nil)
+			req.Header.Set(RoleHeader, tt.role)
+			w := httptest.NewRecorder()
+
+			GetUserByID(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestCallerRedaction_RoleHeader tests that only the configured role header is trusted, and that
+// callers without it get the strictest profile.
+func TestCallerRedaction_RoleHeader(t *testing.T) {
+	setRedactionRoles(t, map[string]string{"support": "masked", "partner": "public"})
+	saved := roleHeader
+	roleHeader = "X-Proxy-Role"
+	t.Cleanup(func() { roleHeader = saved })
+
+	tests := []struct {
+		name     string
+		header   http.Header
+		expected string
+	}{
+		{"trusted header", http.Header{"X-Proxy-Role": {"support"}}, "masked"},
+		{"client header ignored", http.Header{RoleHeader: {"admin"}}, "masked+public"},
+		{"no header", http.Header{}, "masked+public"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		req.Header = tt.header
+		profile, err := callerRedaction(req)
+		if err != nil || profile.Name != tt.expected {
+			t.Errorf("%s: expected profile %s, got %s (%v)", tt.name, tt.expected, profile.Name, err)
+		}
+	}
+}
+
+// TestRedaction_UnknownProfile tests that an unknown redact profile returns 400 (Bad Request).
+func TestRedaction_UnknownProfile(t *testing.T) {
+	setUsers(t)
+
+	for _, handler := range []http.HandlerFunc{GetUsers, ExportUsers} {
+		req := httptest.NewRequest(http.MethodGet, "/users?redact=unknown", nil)
+		w := httptest.NewRecorder()
+
+		handler(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+		}
+	}
+}
+
+// TestExportUsers_Redaction tests that streamed exports are redacted by the caller's role.
+func TestExportUsers_Redaction(t *testing.T) {
+	setUsers(t, models.User{ID: 1, Name: "erick rettozi", Age: 48, Email: "erettozi@tolkien.com"})
+	setRedactionRoles(t, map[string]string{"partner": "public"})
+
+	req := httptest.NewRequest(http.MethodGet, "/users/export", nil)
+	req.Header.Set(RoleHeader, "partner")
+	w := httptest.NewRecorder()
+
+	ExportUsers(w, req)
+
+	expected := "ID,Name,Age\n1,E**** R******,45-54\n"
+	if w.Body.String() != expected {
+		t.Errorf("Unexpected export:\n%s", w.Body.String())
+	}
+}
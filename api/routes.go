@@ -7,12 +7,74 @@ import "net/http"
 func SetupRoutes() {
 	// Register the route for creating a new user.
 	// When a POST request is made to "/users", the CreateUser function will handle it.
-	http.HandleFunc("POST /users", CreateUser)
+	// Retries with the same Idempotency-Key header replay the first response instead of creating another user.
+	http.HandleFunc("POST /users", idempotent(CreateUser))
 
 	// Register the route for retrieving all users.
 	// When a GET request is made to "/users", the GetUsers function will handle it.
 	http.HandleFunc("GET /users", GetUsers)
 
+	// Register the route for exporting all users.
+	// When a GET request is made to "/users/export", the ExportUsers function will stream the users
+	// in the requested format. This route takes precedence over "/users/{id}" because it is more specific.
+	http.HandleFunc("GET /users/export", ExportUsers)
+
+	// Register the route for importing users from a file.
+	// When a POST request is made to "/users/import", the ImportUsers function will handle it.
+	http.HandleFunc("POST /users/import", ImportUsers)
+
+	// Register the route for streaming the changes of the user set.
+	// When a GET request is made to "/users/changes", the StreamChanges function will send
+	// create, update and delete events as JSON Lines until the client disconnects.
+	http.HandleFunc("GET /users/changes", StreamChanges)
+
+	// Register the route for the Server-Sent Events feed of the user changes.
+	// When a GET request is made to "/users/events", the StreamEvents function will send the same
+	// events as "/users/changes" in the text/event-stream format, resuming from Last-Event-ID.
+	http.HandleFunc("GET /users/events", StreamEvents)
+
+	// Register the route for the WebSocket API.
+	// When a WebSocket handshake is made to "/ws", the ServeWebSocket function will upgrade the
+	// connection, then stream the subscribed user changes and run CRUD commands sent by the client.
+	http.HandleFunc("GET /ws", ServeWebSocket)
+
+	// Register the route for listing the export snapshots.
+	// When a GET request is made to "/exports", the ListExports function will handle it.
+	http.HandleFunc("GET /exports", ListExports)
+
+	// Register the route for downloading an export snapshot by name.
+	// When a GET request is made to "/exports/{name}", the DownloadExport function will handle it.
+	// The special name "latest" selects the newest snapshot.
+	http.HandleFunc("GET /exports/{name}", DownloadExport)
+
+	// Register the admin route for taking a backup of the user store.
+	// When a GET request is made to "/admin/snapshot", the Snapshot function will return all users,
+	// copied under the store lock. Requires the ADMIN_TOKEN bearer token when it is set.
+	http.HandleFunc("GET /admin/snapshot", requireAdmin(Snapshot))
+
+	// Register the admin route for restoring a backup.
+	// When a POST request is made to "/admin/restore", the Restore function will load the backup
+	// in merge or replace mode. Requires the ADMIN_TOKEN bearer token when it is set.
+	http.HandleFunc("POST /admin/restore", requireAdmin(Restore))
+
+	// Register the admin routes of the webhooks.
+	// POST "/webhooks" subscribes a URL to the user change events, GET "/webhooks" lists the webhooks
+	// and DELETE "/webhooks/{id}" removes one. GET "/webhooks/{id}/deliveries" lists the deliveries
+	// of a webhook, including its dead-letter list, and POST ".../{delivery}/retry" queues one again.
+	// Require the ADMIN_TOKEN bearer token when it is set, since webhooks receive every user.
+	http.HandleFunc("POST /webhooks", requireAdmin(CreateWebhook))
+	http.HandleFunc("GET /webhooks", requireAdmin(ListWebhooks))
+	http.HandleFunc("DELETE /webhooks/{id}", requireAdmin(DeleteWebhook))
+	http.HandleFunc("GET /webhooks/{id}/deliveries", requireAdmin(ListDeliveries))
+	http.HandleFunc("POST /webhooks/{id}/deliveries/{delivery}/retry", requireAdmin(RetryDelivery))
+
+	// Register the admin routes of the audit log.
+	// When a GET request is made to "/audit", the GetAudit function will return the recorded changes,
+	// filtered by the "since" and "actor" query parameters. "/users/{id}/history" returns those of a user.
+	// Require the ADMIN_TOKEN bearer token when it is set, since entries hold the changed values.
+	http.HandleFunc("GET /audit", requireAdmin(GetAudit))
+	http.HandleFunc("GET /users/{id}/history", requireAdmin(GetUserHistory))
+
 	// Register the route for retrieving a specific user by ID.
 	// When a GET request is made to "/users/{id}", the GetUserByID function will handle it.
 	// The {id} part is a path parameter that represents the user's ID.
@@ -23,6 +85,11 @@ func SetupRoutes() {
 	// The {id} part is a path parameter that represents the user's ID.
 	http.HandleFunc("PUT /users/{id}", UpdateUser)
 
+	// Register the route for partially updating a specific user by ID.
+	// When a PATCH request is made to "/users/{id}", the PatchUser function will update only the
+	// fields present in the payload.
+	http.HandleFunc("PATCH /users/{id}", PatchUser)
+
 	// Register the route for deleting a specific user by ID.
 	// When a DELETE request is made to "/users/{id}", the DeleteUser function will handle it.
 	// The {id} part is a path parameter that represents the user's ID.
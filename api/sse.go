@@ -0,0 +1,144 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"user_api_with_concurrency/models"
+)
+
+const (
+	sseRetry        = 3 * time.Second  // Reconnection delay suggested to EventSource clients.
+	sseWriteTimeout = 10 * time.Second // Time a client has to accept a write before it is disconnected.
+)
+
+// StreamEvents streams the changes of the user set as Server-Sent Events, for EventSource clients.
+// Every event has its event ID as "id", its type (create, update or delete) as "event", and the event
+// as JSON in "data". The "type" and "id" query parameters filter the events like in StreamChanges.
+//
+// A client resumes from the Last-Event-ID header, which EventSource sends when it reconnects, or from
+// the "since" query parameter. If the events after it are no longer buffered, a "reset" event is sent
+// first, telling the client to reload the users, and the stream continues with new events.
+// Comments are sent as heartbeats on idle streams. A client that falls behind, or does not accept
+// a write in time, is disconnected without slowing down the writers, and may resume from its last event.
+func StreamEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest) // Return 400 if a filter is invalid.
+		return
+	}
+
+	redaction, err := callerRedaction(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest) // Return 400 if the redaction profile is unknown.
+		return
+	}
+
+	since, err := lastEventID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest) // Return 400 if the resume point is invalid.
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// Start over with new events if the resume point expired.
+	backlog, ch, err := events.subscribe(since)
+	reset := errors.Is(err, ErrEventsExpired)
+	if reset {
+		backlog, ch, err = events.subscribe(-1)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer events.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Disable buffering in nginx proxies.
+	w.WriteHeader(http.StatusOK)
+
+	// write sends a chunk of the stream and flushes it, giving up if the client does not accept it in time.
+	rc := http.NewResponseController(w)
+	write := func(chunk string) error {
+		rc.SetWriteDeadline(time.Now().Add(sseWriteTimeout))
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	send := func(event models.UserEvent) error {
+		if !filter.match(event) {
+			return nil
+		}
+		view := eventView{ID: event.ID, Type: event.Type, Time: event.Time, User: redactUser(event.User, redaction)}
+		data, err := json.Marshal(view)
+		if err != nil {
+			return err
+		}
+		return write(fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data))
+	}
+
+	if err := write(fmt.Sprintf("retry: %d\n\n", sseRetry.Milliseconds())); err != nil {
+		return
+	}
+	if reset {
+		if err := write(fmt.Sprintf("event: reset\ndata: events after %d are no longer available\n\n", since)); err != nil {
+			return
+		}
+	}
+	for _, event := range backlog {
+		if err := send(event); err != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return // The client disconnected.
+		case event, ok := <-ch:
+			if !ok {
+				return // The client fell behind; EventSource reconnects with Last-Event-ID.
+			}
+			if err := send(event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if err := write(": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// lastEventID returns the event ID a client resumes from: the Last-Event-ID header, or the "since"
+// query parameter. It returns -1 if neither is set, so that only new events are sent.
+func lastEventID(r *http.Request) (int64, error) {
+	value := strings.TrimSpace(r.Header.Get("Last-Event-ID"))
+	if value == "" {
+		value = r.URL.Query().Get("since")
+	}
+	if value == "" {
+		return -1, nil
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id < 0 {
+		return 0, fmt.Errorf("invalid event ID %q", value)
+	}
+	return id, nil
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"user_api_with_concurrency/models"
+	"user_api_with_concurrency/utils"
+)
+
+// readSSE reads the next Server-Sent Event of a stream as its fields, skipping comments.
+func readSSE(t *testing.T, scanner *bufio.Scanner) map[string]string {
+	t.Helper()
+	fields := make(map[string]string)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if len(fields) > 0 {
+				return fields
+			}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		name, value, _ := strings.Cut(line, ": ")
+		fields[name] = value
+	}
+	t.Fatalf("Stream ended early: %v", scanner.Err())
+	return nil
+}
+
+// TestStreamEvents tests the SSE feed resuming from Last-Event-ID with a filter.
+func TestStreamEvents(t *testing.T) {
+	last := events.publish(utils.OpCreate, models.User{ID: 1, Name: "Frodo Baggins"}).ID
+	events.publish(utils.OpCreate, models.User{ID: 2, Name: "Sam Gamgee"})
+	update := events.publish(utils.OpUpdate, models.User{ID: 2, Name: "Samwise Gamgee"}).ID
+
+	ts := httptest.NewServer(http.HandlerFunc(StreamEvents))
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/users/events?type=update", nil)
+	req.Header.Set("Last-Event-ID", strconv.FormatInt(last, 10))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Unexpected Content-Type %q", ct)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	if fields := readSSE(t, scanner); fields["retry"] != "3000" {
+		t.Errorf("Expected a retry delay first, got %v", fields)
+	}
+	fields := readSSE(t, scanner)
+	if fields["id"] != strconv.FormatInt(update, 10) || fields["event"] != utils.OpUpdate || !strings.Contains(fields["data"], "Samwise Gamgee") {
+		t.Errorf("Expected the buffered update event, got %v", fields)
+	}
+
+	// New events are sent as they are published.
+	live := events.publish(utils.OpUpdate, models.User{ID: 1, Name: "Frodo"}).ID
+	if fields := readSSE(t, scanner); fields["id"] != strconv.FormatInt(live, 10) {
+		t.Errorf("Expected the live event %d, got %v", live, fields)
+	}
+}
+
+// TestStreamEvents_Reset tests that an expired resume point starts over with a reset event,
+// and that an invalid one is rejected.
+func TestStreamEvents_Reset(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(StreamEvents))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/users/events?since=999999999")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	readSSE(t, scanner) // retry
+	if fields := readSSE(t, scanner); fields["event"] != "reset" {
+		t.Errorf("Expected a reset event, got %v", fields)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/users/events", nil)
+	req.Header.Set("Last-Event-ID", "abc")
+	StreamEvents(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid Last-Event-ID, got %d", w.Code)
+	}
+}
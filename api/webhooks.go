@@ -0,0 +1,246 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"user_api_with_concurrency/models"
+	"user_api_with_concurrency/utils"
+)
+
+// webhooks delivers the user change events to the registered webhooks; nil if its directory cannot be loaded.
+var webhooks *utils.WebhookDispatcher
+
+// init opens the webhook dispatcher, which the change stream notifies through notifyWebhooks.
+// WEBHOOKS_DIR sets the directory of the webhooks and their delivery queue (default "webhooks",
+// relative to the project root), WEBHOOK_MAX_ATTEMPTS the number of attempts before a delivery
+// is dead-lettered (default 8), and WEBHOOK_RETRY_DELAY and WEBHOOK_MAX_RETRY_DELAY the delay
+// before the first retry, doubled after every attempt, and its maximum (default 10s and 1h).
+func init() {
+	dir := os.Getenv("WEBHOOKS_DIR")
+	if dir == "" {
+		dir = "webhooks"
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(utils.GetProjectRoot(), dir)
+	}
+
+	opts := utils.WebhookOptions{MaxAttempts: 8, BaseDelay: 10 * time.Second, MaxDelay: time.Hour}
+	if v := os.Getenv("WEBHOOK_MAX_ATTEMPTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			log.Printf("Ignoring invalid WEBHOOK_MAX_ATTEMPTS %q\n", v)
+		} else {
+			opts.MaxAttempts = n
+		}
+	}
+	for name, delay := range map[string]*time.Duration{"WEBHOOK_RETRY_DELAY": &opts.BaseDelay, "WEBHOOK_MAX_RETRY_DELAY": &opts.MaxDelay} {
+		if v := os.Getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				log.Printf("Ignoring invalid %s %q\n", name, v)
+			} else {
+				*delay = d
+			}
+		}
+	}
+
+	var err error
+	if webhooks, err = utils.OpenWebhooks(dir, opts); err != nil {
+		log.Println("Webhooks are disabled:", err)
+	}
+}
+
+// notifyWebhooks queues the delivery of an event to the webhooks. It is called by events.publish,
+// under usersMu, so deliveries are queued in the order of the mutations.
+func notifyWebhooks(event models.UserEvent) {
+	if webhooks == nil {
+		return
+	}
+	webhooks.Enqueue(event.ID, event.Type, func(hook models.Webhook) ([]byte, error) {
+		var profile utils.RedactionProfile // Users are sent as they are, unless the webhook names a profile.
+		if hook.Redact != "" {
+			var err error
+			if profile, err = utils.LookupRedactionProfile(hook.Redact); err != nil {
+				return nil, err // The profile was removed from REDACTION_PROFILES since the webhook was created.
+			}
+		}
+		return json.Marshal(eventView{ID: event.ID, Type: event.Type, Time: event.Time, User: redactUser(event.User, profile)})
+	})
+}
+
+// webhookRequest is the body of POST /webhooks.
+type webhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Redact string   `json:"redact"`
+	Secret string   `json:"secret"`
+}
+
+// CreateWebhook registers a webhook: the change events of the listed types (all types if none)
+// are posted to its URL, signed with its secret. Without a secret, a random one is generated.
+// The response is the webhook with its secret, which is not returned by the other endpoints.
+func CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	if webhooks == nil {
+		http.Error(w, "Webhooks are disabled", http.StatusServiceUnavailable) // Return 503 if the dispatcher failed to load.
+		return
+	}
+
+	var req webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest) // Return 400 if the payload is invalid.
+		return
+	}
+
+	target, err := url.Parse(req.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		http.Error(w, fmt.Sprintf("invalid webhook URL %q (expected an http or https URL)", req.URL), http.StatusBadRequest)
+		return
+	}
+	hook := models.Webhook{URL: target.String(), Redact: req.Redact, Secret: req.Secret}
+	for _, typ := range req.Events {
+		typ = strings.ToLower(strings.TrimSpace(typ))
+		if !slices.Contains(eventTypes, typ) {
+			http.Error(w, fmt.Sprintf("unknown event type %q (expected one of %s)", typ, strings.Join(eventTypes, ", ")), http.StatusBadRequest)
+			return
+		}
+		if !slices.Contains(hook.Events, typ) {
+			hook.Events = append(hook.Events, typ)
+		}
+	}
+	if hook.Redact != "" {
+		if _, err := utils.LookupRedactionProfile(hook.Redact); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest) // Return 400 if the redaction profile is unknown.
+			return
+		}
+	}
+	if hook.Secret == "" {
+		key := make([]byte, 32)
+		rand.Read(key)
+		hook.Secret = hex.EncodeToString(key)
+	}
+
+	hook, err = webhooks.Add(hook)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError) // Return 500 if the webhook cannot be saved.
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated) // Return 201 (Created) status code.
+	json.NewEncoder(w).Encode(hook)   // Return the webhook, with its secret, as JSON.
+}
+
+// ListWebhooks returns the registered webhooks, without their secrets.
+func ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	list := []models.Webhook{}
+	if webhooks != nil {
+		list = webhooks.Webhooks()
+	}
+	for i := range list {
+		list[i].Secret = ""
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)    // Return 200 (OK) status code.
+	json.NewEncoder(w).Encode(list) // Return the webhooks as JSON.
+}
+
+// DeleteWebhook removes a webhook and drops its pending deliveries.
+func DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	if err := webhooks.Remove(id); err != nil {
+		webhookError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent) // Return 204 (No Content) status code.
+}
+
+// ListDeliveries returns the deliveries of a webhook, newest first: the pending ones, the last
+// delivered ones, and the dead-lettered ones. The "status" query parameter selects one of these,
+// e.g. "status=dead" for the dead-letter list.
+func ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && status != models.DeliveryPending && status != models.DeliveryDelivered && status != models.DeliveryDead {
+		http.Error(w, fmt.Sprintf("unknown delivery status %q (expected pending, delivered or dead)", status), http.StatusBadRequest)
+		return
+	}
+
+	list, err := webhooks.Deliveries(id, status)
+	if err != nil {
+		webhookError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)    // Return 200 (OK) status code.
+	json.NewEncoder(w).Encode(list) // Return the deliveries as JSON.
+}
+
+// RetryDelivery queues a dead-lettered or delivered delivery again, e.g. once the receiver is fixed.
+func RetryDelivery(w http.ResponseWriter, r *http.Request) {
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+	deliveryID, err := strconv.ParseInt(r.PathValue("delivery"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid delivery ID", http.StatusBadRequest) // Return 400 if the ID is not a number.
+		return
+	}
+
+	delivery, err := webhooks.Redeliver(id, deliveryID)
+	if err != nil {
+		webhookError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)  // Return 202 (Accepted): the delivery is queued.
+	json.NewEncoder(w).Encode(delivery) // Return the queued delivery as JSON.
+}
+
+// webhookID returns the webhook ID of the URL, writing an error response if it is invalid
+// or if webhooks are disabled.
+func webhookID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	if webhooks == nil {
+		http.Error(w, "Webhooks are disabled", http.StatusServiceUnavailable) // Return 503 if the dispatcher failed to load.
+		return 0, false
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest) // Return 400 if the ID is not a number.
+		return 0, false
+	}
+	return id, true
+}
+
+// webhookError writes the response of a dispatcher error.
+func webhookError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, utils.ErrWebhookNotFound), errors.Is(err, utils.ErrDeliveryNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound) // Return 404 if the webhook or delivery doesn't exist.
+	case errors.Is(err, utils.ErrDeliveryPending):
+		http.Error(w, err.Error(), http.StatusConflict) // Return 409 if the delivery is already queued.
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
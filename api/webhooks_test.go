@@ -0,0 +1,135 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"user_api_with_concurrency/models"
+	"user_api_with_concurrency/utils"
+)
+
+// setWebhooks replaces the webhook dispatcher with an empty one for the duration of a test.
+func setWebhooks(t *testing.T, opts utils.WebhookOptions) {
+	t.Helper()
+	d, err := utils.OpenWebhooks(t.TempDir(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := webhooks
+	webhooks = d
+	t.Cleanup(func() { webhooks = saved })
+}
+
+// serveWebhook sends a request to a webhook handler, with the path values of its route.
+func serveWebhook(handler http.HandlerFunc, method, target, body string, pathValues ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(pathValues); i += 2 {
+		r.SetPathValue(pathValues[i], pathValues[i+1])
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// TestCreateWebhook_Invalid tests that invalid webhooks are rejected.
+func TestCreateWebhook_Invalid(t *testing.T) {
+	setWebhooks(t, utils.WebhookOptions{MaxAttempts: 1, BaseDelay: time.Second, MaxDelay: time.Second})
+
+	for _, body := range []string{
+		`{"url":"ftp://example.com/hook"}`,
+		`{"url":"/hook"}`,
+		`{"url":"http://example.com/hook","events":["rename"]}`,
+		`{"url":"http://example.com/hook","redact":"unknown"}`,
+		`{"url":`,
+	} {
+		if w := serveWebhook(CreateWebhook, http.MethodPost, "/webhooks", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", body, w.Code)
+		}
+	}
+	if w := serveWebhook(ListWebhooks, http.MethodGet, "/webhooks", ""); strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Errorf("Expected no webhooks, got %s", w.Body)
+	}
+}
+
+// TestWebhooks tests that user changes are delivered to a webhook with its redaction, and that
+// the deliveries can be inspected until the webhook is deleted.
+func TestWebhooks(t *testing.T) {
+	setUsers(t)
+	setWebhooks(t, utils.WebhookOptions{MaxAttempts: 1, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+
+	bodies := make(chan []byte, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("X-Webhook-Signature") != utils.SignWebhook("s3cret", r.Header.Get("X-Webhook-Timestamp"), body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		bodies <- body
+	}))
+	defer receiver.Close()
+
+	w := serveWebhook(CreateWebhook, http.MethodPost, "/webhooks", `{"url":"`+receiver.URL+`","events":["create"],"redact":"public","secret":"s3cret"}`)
+	var hook models.Webhook
+	if err := json.NewDecoder(w.Body).Decode(&hook); err != nil || w.Code != http.StatusCreated || hook.Secret != "s3cret" {
+		t.Fatalf("Expected the webhook to be created with its secret, got %d %+v (%v)", w.Code, hook, err)
+	}
+	w = serveWebhook(ListWebhooks, http.MethodGet, "/webhooks", "")
+	if strings.Contains(w.Body.String(), "s3cret") || !strings.Contains(w.Body.String(), receiver.URL) {
+		t.Errorf("Expected the webhooks without their secret, got %s", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	CreateUser(w, httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(`{"name":"Sam Gamgee","age":38,"email":"sam@tolkien.com"}`)))
+	var sam models.User
+	json.NewDecoder(w.Body).Decode(&sam)
+	r := httptest.NewRequest(http.MethodDelete, "/users/"+strconv.Itoa(sam.ID), nil)
+	r.SetPathValue("id", strconv.Itoa(sam.ID))
+	DeleteUser(httptest.NewRecorder(), r) // Not subscribed.
+
+	var event struct {
+		Type string         `json:"type"`
+		User map[string]any `json:"user"`
+	}
+	select {
+	case body := <-bodies:
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The event was not delivered")
+	}
+	if _, ok := event.User["email"]; event.Type != utils.OpCreate || ok || event.User["name"] == "Sam Gamgee" {
+		t.Errorf("Expected the created user with the public profile, got %+v", event)
+	}
+
+	id := "1"
+	var deliveries []models.WebhookDelivery
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		w = serveWebhook(ListDeliveries, http.MethodGet, "/webhooks/1/deliveries?status=delivered", "", "id", id)
+		json.NewDecoder(w.Body).Decode(&deliveries)
+		if len(deliveries) == 1 {
+			break
+		}
+	}
+	if len(deliveries) != 1 || deliveries[0].EventType != utils.OpCreate {
+		t.Fatalf("Expected one delivered delivery, got %+v", deliveries)
+	}
+
+	if w := serveWebhook(ListDeliveries, http.MethodGet, "/webhooks/1/deliveries?status=lost", "", "id", id); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown status, got %d", w.Code)
+	}
+	if w := serveWebhook(RetryDelivery, http.MethodPost, "/webhooks/1/deliveries/9/retry", "", "id", id, "delivery", "9"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown delivery, got %d", w.Code)
+	}
+	if w := serveWebhook(DeleteWebhook, http.MethodDelete, "/webhooks/1", "", "id", id); w.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", w.Code)
+	}
+	if w := serveWebhook(ListDeliveries, http.MethodGet, "/webhooks/1/deliveries", "", "id", id); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 after the delete, got %d", w.Code)
+	}
+}
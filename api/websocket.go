@@ -0,0 +1,329 @@
+package api
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// websocketGUID is appended to the client's key to compute the handshake accept value (RFC 6455, section 1.3).
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket frame opcodes (RFC 6455, section 5.2).
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// WebSocket close status codes (RFC 6455, section 7.4.1).
+const (
+	wsCloseNormal          = 1000
+	wsCloseGoingAway       = 1001
+	wsCloseProtocolError   = 1002
+	wsCloseUnsupportedData = 1003
+	wsCloseNoStatus        = 1005
+	wsCloseInvalidPayload  = 1007
+	wsCloseTooBig          = 1009
+	wsCloseInternalError   = 1011
+)
+
+// wsCloseError ends a WebSocket connection with a close status code, sent by either side.
+type wsCloseError struct {
+	code   int
+	reason string
+}
+
+func (e *wsCloseError) Error() string {
+	return fmt.Sprintf("websocket closed with status %d: %s", e.code, e.reason)
+}
+
+// wsConn is a WebSocket connection. Messages are read by a single goroutine, while frames may be
+// written concurrently. A server connection requires masked frames from the client and writes
+// unmasked ones; a client connection does the opposite.
+type wsConn struct {
+	conn        net.Conn
+	br          *bufio.Reader
+	client      bool          // Client side of the connection.
+	maxMessage  int           // Maximum size of a message, over all its fragments.
+	readTimeout time.Duration // Time allowed between two frames from the peer (0 for none).
+
+	writeMu      sync.Mutex
+	writeTimeout time.Duration // Time allowed to write a frame (0 for none).
+	closeSent    bool          // A close frame was written; no frame may follow it.
+}
+
+// upgradeWebSocket validates a WebSocket handshake request, takes over its connection and
+// switches it to the WebSocket protocol. On error, the response was already written.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "Expected a WebSocket handshake", http.StatusBadRequest) // Return 400 for plain HTTP requests.
+		return nil, errors.New("not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusUpgradeRequired) // Return 426 with the supported version.
+		return nil, errors.New("unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if nonce, err := base64.StdEncoding.DecodeString(key); err != nil || len(nonce) != 16 {
+		http.Error(w, "Invalid Sec-WebSocket-Key", http.StatusBadRequest) // Return 400 if the key is not a 16-byte nonce.
+		return nil, errors.New("invalid websocket key")
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "WebSocket is not supported", http.StatusInternalServerError)
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{}) // Clear the deadlines of the HTTP server.
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + websocketAccept(key) + "\r\n\r\n"
+	if _, err := io.WriteString(conn, response); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, br: rw.Reader}, nil
+}
+
+// websocketAccept returns the Sec-WebSocket-Accept value of a handshake key.
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerHasToken reports whether a comma-separated header contains a token, ignoring case.
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeFrame writes a single, final frame. Client frames are masked with a random key.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writeFrameLocked(opcode, payload)
+}
+
+func (c *wsConn) writeFrameLocked(opcode byte, payload []byte) error {
+	if c.closeSent {
+		return net.ErrClosed
+	}
+	if opcode == wsClose {
+		c.closeSent = true
+	}
+
+	header := make([]byte, 2, 14)
+	header[0] = 0x80 | opcode // FIN bit and opcode.
+	switch n := len(payload); {
+	case n <= 125:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	if c.client {
+		header[1] |= 0x80 // Mask bit.
+		var key [4]byte
+		rand.Read(key[:])
+		header = append(header, key[:]...)
+		masked := make([]byte, len(payload))
+		for i, b := range payload {
+			masked[i] = b ^ key[i%4]
+		}
+		payload = masked
+	}
+
+	if c.writeTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// writeClose writes a close frame with a status code and reason, unless one was already written.
+func (c *wsConn) writeClose(code int, reason string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return nil
+	}
+	var payload []byte
+	if code != wsCloseNoStatus {
+		payload = binary.BigEndian.AppendUint16(nil, uint16(code))
+		payload = append(payload, reason...)
+		if len(payload) > 125 {
+			payload = payload[:125] // Control frames are limited to 125 bytes.
+		}
+	}
+	return c.writeFrameLocked(wsClose, payload)
+}
+
+// close ends the connection, first telling the peer why: err is a *wsCloseError for protocol
+// violations and closes by the peer, and any other error closes with an internal error status.
+func (c *wsConn) close(err error) {
+	var closeErr *wsCloseError
+	switch {
+	case err == nil:
+		c.writeClose(wsCloseNormal, "")
+	case errors.As(err, &closeErr):
+		c.writeClose(closeErr.code, closeErr.reason)
+	case !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed):
+		c.writeClose(wsCloseInternalError, "")
+	}
+	c.conn.Close()
+}
+
+// readFrame reads the header and payload of a frame, validating it against the protocol.
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	if c.readTimeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+	}
+
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, opcode = header[0]&0x80 != 0, header[0]&0x0F
+	masked, length := header[1]&0x80 != 0, uint64(header[1]&0x7F)
+
+	switch {
+	case header[0]&0x70 != 0:
+		return false, 0, nil, &wsCloseError{wsCloseProtocolError, "reserved bits set"} // No extension was negotiated.
+	case opcode > wsBinary && opcode < wsClose || opcode > wsPong:
+		return false, 0, nil, &wsCloseError{wsCloseProtocolError, "unknown opcode"}
+	case opcode >= wsClose && (!fin || length > 125):
+		return false, 0, nil, &wsCloseError{wsCloseProtocolError, "invalid control frame"}
+	case masked == c.client:
+		return false, 0, nil, &wsCloseError{wsCloseProtocolError, "invalid masking"} // Only clients mask their frames.
+	}
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if c.maxMessage > 0 && length > uint64(c.maxMessage) {
+		return false, 0, nil, &wsCloseError{wsCloseTooBig, "message too big"}
+	}
+
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, key[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= key[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+// readMessage reads the next text or binary message, reassembling its fragments.
+// Pings are answered with pongs and pongs are ignored. When the peer closes the connection,
+// the close is echoed and returned as a *wsCloseError. Protocol violations are returned as
+// a *wsCloseError with the status to close the connection with.
+func (c *wsConn) readMessage() (opcode byte, message []byte, err error) {
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch op {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case wsPong:
+			continue // Any frame keeps the connection alive.
+		case wsClose:
+			closeErr := parseClose(payload)
+			if closeErr.code == wsCloseProtocolError {
+				return 0, nil, closeErr
+			}
+			c.writeClose(closeErr.code, "") // Echo the close.
+			return 0, nil, closeErr
+		case wsContinuation:
+			if opcode == 0 {
+				return 0, nil, &wsCloseError{wsCloseProtocolError, "unexpected continuation frame"}
+			}
+		default:
+			if opcode != 0 {
+				return 0, nil, &wsCloseError{wsCloseProtocolError, "expected a continuation frame"}
+			}
+			opcode = op
+		}
+
+		message = append(message, payload...)
+		if c.maxMessage > 0 && len(message) > c.maxMessage {
+			return 0, nil, &wsCloseError{wsCloseTooBig, "message too big"}
+		}
+		if fin {
+			if opcode == wsText && !utf8.Valid(message) {
+				return 0, nil, &wsCloseError{wsCloseInvalidPayload, "invalid UTF-8 in text message"}
+			}
+			return opcode, message, nil
+		}
+	}
+}
+
+// parseClose parses the payload of a close frame.
+func parseClose(payload []byte) *wsCloseError {
+	switch {
+	case len(payload) == 0:
+		return &wsCloseError{wsCloseNoStatus, ""}
+	case len(payload) == 1 || !utf8.Valid(payload[2:]):
+		return &wsCloseError{wsCloseProtocolError, "invalid close frame"}
+	}
+	code := int(binary.BigEndian.Uint16(payload))
+	if code < 1000 || code >= 5000 || code == 1004 || code == wsCloseNoStatus || code == 1006 || code == 1015 || code > 1011 && code < 3000 {
+		return &wsCloseError{wsCloseProtocolError, "invalid close status"}
+	}
+	return &wsCloseError{code, string(payload[2:])}
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// dialWebSocket opens a client WebSocket connection to a test server.
+func dialWebSocket(t *testing.T, serverURL, path string) *wsConn {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(serverURL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	req, _ := http.NewRequest(http.MethodGet, serverURL+path, nil)
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != websocketAccept(key) {
+		t.Fatalf("Unexpected handshake response %d %v", resp.StatusCode, resp.Header)
+	}
+	return &wsConn{conn: conn, br: br, client: true}
+}
+
+// writeRawFrame writes a frame with explicit FIN and mask bits, for the cases writeFrame never produces.
+func writeRawFrame(conn net.Conn, fin bool, opcode byte, payload []byte, mask bool) {
+	b0, b1 := opcode, byte(len(payload))
+	if fin {
+		b0 |= 0x80
+	}
+	frame := []byte{b0, b1}
+	if mask {
+		frame[1] |= 0x80
+		key := []byte{1, 2, 3, 4}
+		frame = append(frame, key...)
+		for i, b := range payload {
+			frame = append(frame, b^key[i%4])
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+	conn.Write(frame)
+}
+
+// TestWebsocketAccept tests the handshake accept value with the example of RFC 6455.
+func TestWebsocketAccept(t *testing.T) {
+	if got := websocketAccept("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Unexpected accept value %q", got)
+	}
+}
+
+// TestWSConn_ReadMessage tests reassembling fragments, answering pings in between, and closing.
+func TestWSConn_ReadMessage(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	server := &wsConn{conn: a, br: bufio.NewReader(a)}
+	client := &wsConn{conn: b, br: bufio.NewReader(b), client: true}
+
+	go func() {
+		writeRawFrame(b, false, wsText, []byte("Hel"), true)
+		writeRawFrame(b, true, wsPing, []byte("ping"), true)
+		writeRawFrame(b, true, wsContinuation, []byte("lo"), true)
+	}()
+	pong := make(chan string)
+	go func() {
+		_, op, payload, _ := client.readFrame()
+		if op == wsPong {
+			pong <- string(payload)
+		}
+		close(pong)
+	}()
+
+	opcode, message, err := server.readMessage()
+	if err != nil || opcode != wsText || string(message) != "Hello" {
+		t.Fatalf("Expected the text message Hello, got %d %q %v", opcode, message, err)
+	}
+	if got := <-pong; got != "ping" {
+		t.Errorf("Expected a pong with the ping's payload, got %q", got)
+	}
+
+	// The close of the client is echoed.
+	go client.writeClose(wsCloseNormal, "bye")
+	echo := make(chan *wsCloseError, 1)
+	go func() {
+		_, _, err := client.readMessage()
+		var closeErr *wsCloseError
+		errors.As(err, &closeErr)
+		echo <- closeErr
+	}()
+	var closeErr *wsCloseError
+	if _, _, err := server.readMessage(); !errors.As(err, &closeErr) || closeErr.code != wsCloseNormal || closeErr.reason != "bye" {
+		t.Errorf("Expected a normal close, got %v", err)
+	}
+	if got := <-echo; got == nil || got.code != wsCloseNormal {
+		t.Errorf("Expected the close to be echoed, got %v", got)
+	}
+}
+
+// TestWSConn_ProtocolErrors tests that invalid frames close the connection with the right status.
+func TestWSConn_ProtocolErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		write    func(conn net.Conn)
+		expected int
+	}{
+		{"unmasked", func(c net.Conn) { writeRawFrame(c, true, wsText, []byte("hi"), false) }, wsCloseProtocolError},
+		{"continuation first", func(c net.Conn) { writeRawFrame(c, true, wsContinuation, []byte("hi"), true) }, wsCloseProtocolError},
+		{"fragmented ping", func(c net.Conn) { writeRawFrame(c, false, wsPing, nil, true) }, wsCloseProtocolError},
+		{"unknown opcode", func(c net.Conn) { writeRawFrame(c, true, 0x3, nil, true) }, wsCloseProtocolError},
+		{"invalid UTF-8", func(c net.Conn) { writeRawFrame(c, true, wsText, []byte{0xff, 0xfe}, true) }, wsCloseInvalidPayload},
+		{"too big", func(c net.Conn) { writeRawFrame(c, true, wsBinary, make([]byte, 100), true) }, wsCloseTooBig},
+	}
+	for _, tt := range tests {
+		a, b := net.Pipe()
+		server := &wsConn{conn: a, br: bufio.NewReader(a), maxMessage: 64}
+		go tt.write(b)
+
+		var closeErr *wsCloseError
+		if _, _, err := server.readMessage(); !errors.As(err, &closeErr) || closeErr.code != tt.expected {
+			t.Errorf("%s: expected close status %d, got %v", tt.name, tt.expected, err)
+		}
+		a.Close()
+		b.Close()
+	}
+}
+
+// TestUpgradeWebSocket_Errors tests that invalid handshakes are rejected.
+func TestUpgradeWebSocket_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		expected int
+	}{
+		{"plain request", map[string]string{}, http.StatusBadRequest},
+		{"old version", map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "8"}, http.StatusUpgradeRequired},
+		{"invalid key", map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "13", "Sec-WebSocket-Key": "short"}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		for name, value := range tt.headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		if _, err := upgradeWebSocket(w, req); err == nil || w.Code != tt.expected {
+			t.Errorf("%s: expected status %d, got %d (%v)", tt.name, tt.expected, w.Code, err)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"user_api_with_concurrency/models"
+)
+
+// Settings of the WebSocket endpoint.
+const (
+	wsMaxMessage   = 1 << 20          // Maximum size of a client message (1 MiB).
+	wsWriteTimeout = 10 * time.Second // Time a client has to accept a message before it is disconnected.
+)
+
+// wsPingInterval is the interval of the pings sent to clients. A client that sends no frame,
+// not even a pong, for two intervals is disconnected.
+var wsPingInterval = 30 * time.Second
+
+// wsCommands maps the CRUD commands of the WebSocket API to the handlers of the REST endpoints,
+// so that a command behaves exactly like the equivalent request.
+var wsCommands = map[string]struct {
+	method  string
+	handler http.HandlerFunc
+	byID    bool // The command targets the user given by "user_id".
+}{
+	"list":   {http.MethodGet, GetUsers, false},
+	"get":    {http.MethodGet, GetUserByID, true},
+	"create": {http.MethodPost, CreateUser, false},
+	"update": {http.MethodPatch, PatchUser, true},
+	"delete": {http.MethodDelete, DeleteUser, true},
+}
+
+// wsRequest is a message sent by a WebSocket client.
+type wsRequest struct {
+	ID           json.RawMessage `json:"id"`                     // Correlation ID, echoed in the response.
+	Op           string          `json:"op"`                     // subscribe, unsubscribe, list, get, create, update or delete.
+	UserID       int             `json:"user_id,omitempty"`      // User of get, update and delete.
+	User         json.RawMessage `json:"user,omitempty"`         // User of create and update.
+	IDs          []int           `json:"ids,omitempty"`          // User IDs of subscribe; empty for all users.
+	Types        []string        `json:"types,omitempty"`        // Event types of subscribe; empty for all types.
+	Subscription string          `json:"subscription,omitempty"` // Subscription of unsubscribe.
+}
+
+// wsMessage is a message sent to a WebSocket client: a response to a request, an event
+// of its subscriptions, or a reset telling it that events were dropped.
+type wsMessage struct {
+	Type          string          `json:"type"`
+	ID            json.RawMessage `json:"id,omitempty"`
+	Status        int             `json:"status,omitempty"`
+	Result        json.RawMessage `json:"result,omitempty"`
+	Error         string          `json:"error,omitempty"`
+	Subscriptions []string        `json:"subscriptions,omitempty"`
+	Event         *eventView      `json:"event,omitempty"`
+}
+
+// wsSession is the state of a WebSocket connection.
+type wsSession struct {
+	conn      *wsConn
+	handshake *http.Request // The handshake request; commands run with its headers.
+
+	mu            sync.Mutex
+	subscriptions map[string]eventFilter // Subscriptions of the client by ID.
+	lastID        int                    // Number of the last subscription.
+}
+
+// ServeWebSocket serves the WebSocket API. Clients exchange JSON text messages with the server:
+// they subscribe to the changes of some users or event types, and run CRUD commands whose responses
+// carry the command's "id". Commands behave like the REST endpoints, with the headers of the handshake
+// (e.g. the caller's role) and its "redact" query parameter. See the README for the message formats.
+func ServeWebSocket(w http.ResponseWriter, r *http.Request) {
+	redaction, err := callerRedaction(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest) // Return 400 if the redaction profile is unknown.
+		return
+	}
+
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return // The error response was written.
+	}
+	conn.maxMessage = wsMaxMessage
+	conn.readTimeout = 2 * wsPingInterval
+	conn.writeTimeout = wsWriteTimeout
+
+	s := &wsSession{conn: conn, handshake: r, subscriptions: make(map[string]eventFilter)}
+	done := make(chan struct{})
+	defer close(done)
+
+	// Subscribe to the event log before serving requests, so no event after a subscribe response is missed.
+	_, ch, _ := events.subscribe(-1)
+	go s.forwardEvents(ch, done, func(user models.User) any { return redactUser(user, redaction) })
+	go s.ping(done)
+
+	conn.close(s.serve())
+}
+
+// serve reads and runs the client's requests in order, until the connection ends.
+func (s *wsSession) serve() error {
+	for {
+		opcode, message, err := s.conn.readMessage()
+		if err != nil {
+			return err
+		}
+		if opcode != wsText {
+			return &wsCloseError{wsCloseUnsupportedData, "expected JSON text messages"}
+		}
+
+		var req wsRequest
+		if err := json.Unmarshal(message, &req); err != nil {
+			err = s.send(wsMessage{Type: "response", Status: http.StatusBadRequest, Error: "invalid request: " + err.Error()})
+		} else {
+			err = s.send(s.run(req))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// run runs a request and returns its response.
+func (s *wsSession) run(req wsRequest) wsMessage {
+	response := wsMessage{Type: "response", ID: req.ID, Status: http.StatusOK}
+	fail := func(status int, format string, args ...any) wsMessage {
+		response.Status, response.Error = status, fmt.Sprintf(format, args...)
+		return response
+	}
+
+	switch req.Op {
+	case "subscribe":
+		filter := eventFilter{ids: req.IDs}
+		for _, id := range req.IDs {
+			if id <= 0 {
+				return fail(http.StatusBadRequest, "invalid user ID %d", id)
+			}
+		}
+		for _, typ := range req.Types {
+			if !slices.Contains(eventTypes, typ) {
+				return fail(http.StatusBadRequest, "unknown event type %q (expected one of %s)", typ, strings.Join(eventTypes, ", "))
+			}
+			filter.types = append(filter.types, typ)
+		}
+		s.mu.Lock()
+		s.lastID++
+		id := "s" + strconv.Itoa(s.lastID)
+		s.subscriptions[id] = filter
+		s.mu.Unlock()
+		response.Result, _ = json.Marshal(map[string]string{"subscription": id})
+		return response
+
+	case "unsubscribe":
+		s.mu.Lock()
+		_, ok := s.subscriptions[req.Subscription]
+		delete(s.subscriptions, req.Subscription)
+		s.mu.Unlock()
+		if !ok {
+			return fail(http.StatusNotFound, "unknown subscription %q", req.Subscription)
+		}
+		return response
+	}
+
+	cmd, ok := wsCommands[req.Op]
+	if !ok {
+		return fail(http.StatusBadRequest, "unknown op %q", req.Op)
+	}
+
+	// Run the command as a request to the REST handler.
+	path := "/users"
+	if cmd.byID {
+		if req.UserID <= 0 {
+			return fail(http.StatusBadRequest, "user_id is required by %s", req.Op)
+		}
+		path += "/" + strconv.Itoa(req.UserID)
+	}
+	if redact := s.handshake.URL.Query().Get("redact"); redact != "" {
+		path += "?redact=" + url.QueryEscape(redact)
+	}
+	r, err := http.NewRequestWithContext(s.handshake.Context(), cmd.method, path, bytes.NewReader(req.User))
+	if err != nil {
+		return fail(http.StatusInternalServerError, "%v", err)
+	}
+	r.Header = s.handshake.Header.Clone()
+	r.RemoteAddr = s.handshake.RemoteAddr // For the audit log.
+	r.Header.Set("Content-Type", "application/json")
+	r.SetPathValue("id", strconv.Itoa(req.UserID))
+
+	w := &wsResponseWriter{header: make(http.Header)}
+	cmd.handler(w, r)
+
+	w.WriteHeader(http.StatusOK) // No-op unless the handler wrote nothing.
+	response.Status = w.status
+	body := bytes.TrimSpace(w.body.Bytes())
+	switch {
+	case w.status >= 400:
+		response.Error = string(body)
+	case json.Valid(body) && len(body) > 0:
+		response.Result = body
+	}
+	return response
+}
+
+// forwardEvents sends the events matching the client's subscriptions until done is closed.
+// If the client falls behind and the event log drops it, a reset message tells it that
+// events were lost, and forwarding resumes with new events.
+func (s *wsSession) forwardEvents(ch chan models.UserEvent, done <-chan struct{}, redact func(models.User) any) {
+	for {
+		dropped := s.forward(ch, done, redact)
+		events.unsubscribe(ch)
+		if !dropped {
+			return
+		}
+		if s.send(wsMessage{Type: "reset", Error: "events were dropped because the connection fell behind"}) != nil {
+			s.conn.conn.Close()
+			return
+		}
+		_, ch, _ = events.subscribe(-1)
+	}
+}
+
+// forward sends the events of a subscriber channel, and reports whether the channel was closed
+// because the client fell behind.
+func (s *wsSession) forward(ch chan models.UserEvent, done <-chan struct{}, redact func(models.User) any) bool {
+	for {
+		select {
+		case <-done:
+			return false
+		case event, ok := <-ch:
+			if !ok {
+				return true
+			}
+
+			var matched []string
+			s.mu.Lock()
+			for id, filter := range s.subscriptions {
+				if filter.match(event) {
+					matched = append(matched, id)
+				}
+			}
+			s.mu.Unlock()
+			if len(matched) == 0 {
+				continue
+			}
+			slices.Sort(matched)
+
+			view := eventView{ID: event.ID, Type: event.Type, Time: event.Time, User: redact(event.User)}
+			if s.send(wsMessage{Type: "event", Subscriptions: matched, Event: &view}) != nil {
+				s.conn.conn.Close() // Unblock the reader, which ends the session.
+				return false
+			}
+		}
+	}
+}
+
+// ping sends a ping every wsPingInterval until done is closed.
+func (s *wsSession) ping(done <-chan struct{}) {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if s.conn.writeFrame(wsPing, nil) != nil {
+				return
+			}
+		}
+	}
+}
+
+// send writes a message to the client as a JSON text message.
+func (s *wsSession) send(message wsMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return s.conn.writeFrame(wsText, data)
+}
+
+// wsResponseWriter captures the response of a REST handler run by a WebSocket command.
+type wsResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *wsResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *wsResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *wsResponseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"user_api_with_concurrency/models"
+)
+
+// wsCall sends a request over a WebSocket connection.
+func wsCall(t *testing.T, conn *wsConn, request string) {
+	t.Helper()
+	if err := conn.writeFrame(wsText, []byte(request)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// wsRead reads the next message of a WebSocket connection.
+func wsRead(t *testing.T, conn *wsConn) wsMessage {
+	t.Helper()
+	_, data, err := conn.readMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var message wsMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		t.Fatal(err)
+	}
+	return message
+}
+
+// TestServeWebSocket tests subscribing to events and running commands with correlated responses.
+func TestServeWebSocket(t *testing.T) {
+	setUsers(t)
+	ts := httptest.NewServer(http.HandlerFunc(ServeWebSocket))
+	defer ts.Close()
+	conn := dialWebSocket(t, ts.URL, "/ws")
+
+	wsCall(t, conn, `{"id":"sub","op":"subscribe","types":["update"]}`)
+	if m := wsRead(t, conn); m.Type != "response" || string(m.ID) != `"sub"` || string(m.Result) != `{"subscription":"s1"}` {
+		t.Fatalf("Unexpected subscribe response %+v", m)
+	}
+
+	// The create is not an update, so only its response is sent.
+	wsCall(t, conn, `{"id":1,"op":"create","user":{"name":"Sam Gamgee","age":38,"email":"sam@tolkien.com"}}`)
+	m := wsRead(t, conn)
+	var created models.User
+	json.Unmarshal(m.Result, &created)
+	if string(m.ID) != "1" || m.Status != http.StatusCreated || created.Name != "Sam Gamgee" {
+		t.Fatalf("Unexpected create response %+v", m)
+	}
+
+	// The update is answered and sent as an event, in either order.
+	wsCall(t, conn, `{"id":2,"op":"update","user_id":`+strconv.Itoa(created.ID)+`,"user":{"name":"Samwise Gamgee","age":38,"email":"sam@tolkien.com"}}`)
+	var response, event wsMessage
+	for i := 0; i < 2; i++ {
+		if m := wsRead(t, conn); m.Type == "event" {
+			event = m
+		} else {
+			response = m
+		}
+	}
+	if string(response.ID) != "2" || response.Status != http.StatusOK {
+		t.Errorf("Unexpected update response %+v", response)
+	}
+	if event.Event == nil || event.Event.Type != "update" || len(event.Subscriptions) != 1 || event.Subscriptions[0] != "s1" {
+		t.Errorf("Unexpected update event %+v", event)
+	}
+
+	tests := []struct {
+		request  string
+		expected int
+	}{
+		{`{"id":3,"op":"get","user_id":999}`, http.StatusNotFound},
+		{`{"id":4,"op":"delete"}`, http.StatusBadRequest},
+		{`{"id":5,"op":"rename"}`, http.StatusBadRequest},
+		{`{"id":6,"op":"subscribe","types":["rename"]}`, http.StatusBadRequest},
+		{`{"id":7,"op":"unsubscribe","subscription":"s9"}`, http.StatusNotFound},
+		{`{"id":8,"op":"unsubscribe","subscription":"s1"}`, http.StatusOK},
+		{`not json`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		wsCall(t, conn, tt.request)
+		if m := wsRead(t, conn); m.Status != tt.expected {
+			t.Errorf("%s: expected status %d, got %+v", tt.request, tt.expected, m)
+		}
+	}
+
+	// Closing is echoed by the server.
+	conn.writeClose(wsCloseNormal, "")
+	if _, _, err := conn.readMessage(); err == nil {
+		t.Errorf("Expected the connection to be closed")
+	}
+}
+
+// TestServeWebSocket_RedactedUpdate tests that sending back a redacted user with a changed field
+// only updates that field, leaving the real values behind the redaction unchanged.
+func TestServeWebSocket_RedactedUpdate(t *testing.T) {
+	setUsers(t, models.User{ID: 7, Name: "Sam Gamgee", Age: 38, Email: "sam@tolkien.com"})
+	ts := httptest.NewServer(http.HandlerFunc(ServeWebSocket))
+	defer ts.Close()
+	conn := dialWebSocket(t, ts.URL, "/ws?redact=masked")
+
+	wsCall(t, conn, `{"id":1,"op":"get","user_id":7}`)
+	m := wsRead(t, conn)
+	var shown map[string]any
+	json.Unmarshal(m.Result, &shown)
+	if shown["email"] != "s**@tolkien.com" {
+		t.Fatalf("Expected a masked email, got %+v", m)
+	}
+
+	shown["name"] = "Samwise Gamgee"
+	user, _ := json.Marshal(shown)
+	wsCall(t, conn, `{"id":2,"op":"update","user_id":7,"user":`+string(user)+`}`)
+	if m := wsRead(t, conn); m.Status != http.StatusOK {
+		t.Fatalf("Unexpected update response %+v", m)
+	}
+
+	usersMu.Lock()
+	defer usersMu.Unlock()
+	expected := models.User{ID: 7, Name: "Samwise Gamgee", Age: 38, Email: "sam@tolkien.com"}
+	if users[7] != expected {
+		t.Errorf("Expected %+v to be stored, got %+v", expected, users[7])
+	}
+}
@@ -0,0 +1,264 @@
+// Package client is a Go client for the user API.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"user_api_with_concurrency/models"
+)
+
+// Errors matched by APIError, to be tested with errors.Is.
+var (
+	ErrNotFound   = errors.New("not found")   // The server returned 404 (Not Found).
+	ErrBadRequest = errors.New("bad request") // The server returned 400 (Bad Request).
+	ErrGone       = errors.New("gone")        // The server returned 410 (Gone), e.g. for expired change events.
+)
+
+// APIError is returned when the server answers with an unexpected status code.
+type APIError struct {
+	StatusCode int    // HTTP status code of the response.
+	Message    string // Error message sent by the server.
+}
+
+// Error implements the error interface.
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("server returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("server returned %d: %s", e.StatusCode, e.Message)
+}
+
+// Is matches ErrNotFound and ErrBadRequest by status code.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrGone:
+		return e.StatusCode == http.StatusGone
+	}
+	return false
+}
+
+// Client calls the /users endpoints of a server.
+type Client struct {
+	BaseURL    string       // Base URL of the server, e.g. "http://localhost:3000".
+	HTTPClient *http.Client // Client used for the requests.
+	Header     http.Header  // Extra headers sent with every request, e.g. the caller's role.
+}
+
+// New creates a client for the server at baseURL.
+// An optional HTTP client may be given; http.DefaultClient is used otherwise.
+func New(baseURL string, httpClient ...*http.Client) *Client {
+	c := &Client{BaseURL: strings.TrimRight(baseURL, "/"), HTTPClient: http.DefaultClient, Header: http.Header{}}
+	if len(httpClient) > 0 && httpClient[0] != nil {
+		c.HTTPClient = httpClient[0]
+	}
+	return c
+}
+
+// ListUsers returns all users.
+func (c *Client) ListUsers(ctx context.Context) ([]models.User, error) {
+	var users []models.User
+	err := c.do(ctx, http.MethodGet, "/users", nil, http.StatusOK, &users)
+	return users, err
+}
+
+// GetUser returns the user with the given ID, or an error matching ErrNotFound.
+func (c *Client) GetUser(ctx context.Context, id int) (models.User, error) {
+	var user models.User
+	err := c.do(ctx, http.MethodGet, "/users/"+strconv.Itoa(id), nil, http.StatusOK, &user)
+	return user, err
+}
+
+// CreateUser creates a user and returns it with its assigned ID.
+func (c *Client) CreateUser(ctx context.Context, user models.User) (models.User, error) {
+	var created models.User
+	err := c.do(ctx, http.MethodPost, "/users", user, http.StatusCreated, &created)
+	return created, err
+}
+
+// UpdateUser replaces the fields of the user with the given ID and returns the updated user.
+func (c *Client) UpdateUser(ctx context.Context, id int, user models.User) (models.User, error) {
+	var updated models.User
+	err := c.do(ctx, http.MethodPut, "/users/"+strconv.Itoa(id), user, http.StatusOK, &updated)
+	return updated, err
+}
+
+// PatchUser updates only the fields set in the patch of the user with the given ID
+// and returns the updated user.
+func (c *Client) PatchUser(ctx context.Context, id int, patch models.UserPatch) (models.User, error) {
+	var updated models.User
+	err := c.do(ctx, http.MethodPatch, "/users/"+strconv.Itoa(id), patch, http.StatusOK, &updated)
+	return updated, err
+}
+
+// DeleteUser deletes the user with the given ID.
+func (c *Client) DeleteUser(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodDelete, "/users/"+strconv.Itoa(id), nil, http.StatusNoContent, nil)
+}
+
+// ExportUsers streams the users in the format and with the pipeline parameters of the query
+// (see GET /users/export). The caller must close the returned body.
+func (c *Client) ExportUsers(ctx context.Context, query url.Values) (io.ReadCloser, error) {
+	resp, err := c.send(ctx, http.MethodGet, "/users/export?"+query.Encode(), nil, "")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, newAPIError(resp)
+	}
+	return resp.Body, nil
+}
+
+// Snapshot returns a consistent point-in-time backup of the user store (see GET /admin/snapshot).
+func (c *Client) Snapshot(ctx context.Context) (models.Backup, error) {
+	var backup models.Backup
+	err := c.do(ctx, http.MethodGet, "/admin/snapshot", nil, http.StatusOK, &backup)
+	return backup, err
+}
+
+// Restore loads a backup into the user store in merge or replace mode and returns the report of the changes.
+// With dryRun, the changes are only counted.
+func (c *Client) Restore(ctx context.Context, backup models.Backup, mode string, dryRun bool) (models.RestoreReport, error) {
+	var report models.RestoreReport
+
+	params := url.Values{}
+	params.Set("mode", mode)
+	params.Set("dry_run", strconv.FormatBool(dryRun))
+
+	err := c.do(ctx, http.MethodPost, "/admin/restore?"+params.Encode(), backup, http.StatusOK, &report)
+	return report, err
+}
+
+// WatchChanges subscribes to the changes of the user set with the filters and the "since" event ID
+// of the query (see GET /users/changes). Resuming from an event that is no longer buffered fails
+// with an error matching ErrGone. The caller must close the returned stream.
+func (c *Client) WatchChanges(ctx context.Context, query url.Values) (*ChangeStream, error) {
+	resp, err := c.send(ctx, http.MethodGet, "/users/changes?"+query.Encode(), nil, "")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, newAPIError(resp)
+	}
+	return &ChangeStream{body: resp.Body, scanner: bufio.NewScanner(resp.Body)}, nil
+}
+
+// ChangeStream reads the events of a change subscription.
+type ChangeStream struct {
+	body    io.ReadCloser
+	scanner *bufio.Scanner
+}
+
+// Next blocks until the next event arrives. It returns io.EOF when the server ends the stream.
+func (s *ChangeStream) Next() (models.UserEvent, error) {
+	var event models.UserEvent
+	for s.scanner.Scan() {
+		line := bytes.TrimSpace(s.scanner.Bytes())
+		if len(line) == 0 {
+			continue // Skip the heartbeats.
+		}
+		if err := json.Unmarshal(line, &event); err != nil {
+			return event, fmt.Errorf("decoding change event: %w", err)
+		}
+		return event, nil
+	}
+	if err := s.scanner.Err(); err != nil {
+		return event, err
+	}
+	return event, io.EOF
+}
+
+// Close ends the subscription.
+func (s *ChangeStream) Close() error {
+	return s.body.Close()
+}
+
+// ImportUsers uploads a CSV, TSV or JSON Lines file and returns the row-level report.
+// With dryRun, rows are only validated.
+func (c *Client) ImportUsers(ctx context.Context, r io.Reader, format string, dryRun bool) (models.ImportReport, error) {
+	var report models.ImportReport
+
+	params := url.Values{}
+	params.Set("format", format)
+	params.Set("dry_run", strconv.FormatBool(dryRun))
+
+	resp, err := c.send(ctx, http.MethodPost, "/users/import?"+params.Encode(), r, "application/octet-stream")
+	if err != nil {
+		return report, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return report, newAPIError(resp)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return report, fmt.Errorf("decoding import report: %w", err)
+	}
+	return report, nil
+}
+
+// do sends a request with an optional JSON body and decodes the JSON response into out.
+// Any status other than want is returned as an *APIError.
+func (c *Client) do(ctx context.Context, method, path string, in any, want int, out any) error {
+	var body io.Reader
+	contentType := ""
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body, contentType = bytes.NewReader(data), "application/json"
+	}
+
+	resp, err := c.send(ctx, method, path, body, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != want {
+		return newAPIError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding response of %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// send builds and sends a request to the server.
+func (c *Client) send(ctx context.Context, method, path string, body io.Reader, contentType string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range c.Header {
+		req.Header[key] = values
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return c.HTTPClient.Do(req)
+}
+
+// newAPIError reads the error message of a response.
+func newAPIError(resp *http.Response) *APIError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+}
@@ -0,0 +1,200 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"user_api_with_concurrency/api"
+	"user_api_with_concurrency/models"
+)
+
+// newTestServer starts a server with the API's user routes.
+func newTestServer(t *testing.T) *Client {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /users", api.CreateUser)
+	mux.HandleFunc("GET /users", api.GetUsers)
+	mux.HandleFunc("GET /users/export", api.ExportUsers)
+	mux.HandleFunc("POST /users/import", api.ImportUsers)
+	mux.HandleFunc("GET /users/changes", api.StreamChanges)
+	mux.HandleFunc("GET /admin/snapshot", api.Snapshot)
+	mux.HandleFunc("POST /admin/restore", api.Restore)
+	mux.HandleFunc("GET /users/{id}", api.GetUserByID)
+	mux.HandleFunc("PUT /users/{id}", api.UpdateUser)
+	mux.HandleFunc("DELETE /users/{id}", api.DeleteUser)
+
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return New(ts.URL)
+}
+
+// TestClientCRUD tests a full create, read, update and delete cycle against the API.
+func TestClientCRUD(t *testing.T) {
+	c := newTestServer(t)
+	ctx := context.Background()
+
+	created, err := c.CreateUser(ctx, models.User{Name: "Frodo Baggins", Age: 50, Email: "frodo@tolkien.com"})
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if created.ID == 0 || created.Name != "Frodo Baggins" {
+		t.Fatalf("Unexpected created user: %+v", created)
+	}
+
+	got, err := c.GetUser(ctx, created.ID)
+	if err != nil || got != created {
+		t.Errorf("GetUser returned %+v, %v", got, err)
+	}
+
+	created.Age = 51
+	updated, err := c.UpdateUser(ctx, created.ID, created)
+	if err != nil || updated.Age != 51 {
+		t.Errorf("UpdateUser returned %+v, %v", updated, err)
+	}
+
+	list, err := c.ListUsers(ctx)
+	if err != nil || len(list) == 0 {
+		t.Errorf("ListUsers returned %+v, %v", list, err)
+	}
+
+	if err := c.DeleteUser(ctx, created.ID); err != nil {
+		t.Errorf("DeleteUser failed: %v", err)
+	}
+
+	// The user is gone, so the typed error must match ErrNotFound.
+	_, err = c.GetUser(ctx, created.ID)
+	var apiErr *APIError
+	if !errors.Is(err, ErrNotFound) || !errors.As(err, &apiErr) || apiErr.Message != "User not found" {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if err := c.DeleteUser(ctx, created.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+// TestClientExportImport tests the export and import endpoints.
+func TestClientExportImport(t *testing.T) {
+	c := newTestServer(t)
+	ctx := context.Background()
+
+	report, err := c.ImportUsers(ctx, strings.NewReader("name,age,email\nSam,38,not-an-email\n"), "csv", true)
+	if err != nil || report.Failed != 1 || !report.DryRun {
+		t.Errorf("ImportUsers returned %+v, %v", report, err)
+	}
+
+	body, err := c.ExportUsers(ctx, url.Values{"format": {"jsonl"}})
+	if err != nil {
+		t.Fatalf("ExportUsers failed: %v", err)
+	}
+	io.Copy(io.Discard, body)
+	body.Close()
+
+	_, err = c.ExportUsers(ctx, url.Values{"format": {"yaml"}})
+	if !errors.Is(err, ErrBadRequest) {
+		t.Errorf("Expected ErrBadRequest, got %v", err)
+	}
+}
+
+// TestClientContext tests that requests are cancelled with their context.
+func TestClientContext(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done() // Never answer.
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := New(ts.URL).ListUsers(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+// TestClientWatchChanges tests that changes are streamed, filtered and resumable.
+func TestClientWatchChanges(t *testing.T) {
+	c := newTestServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := c.WatchChanges(ctx, url.Values{"type": {"create,delete"}})
+	if err != nil {
+		t.Fatalf("WatchChanges failed: %v", err)
+	}
+	defer stream.Close()
+
+	created, err := c.CreateUser(ctx, models.User{Name: "Sam Gamgee", Age: 38, Email: "sam@tolkien.com"})
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if _, err := c.UpdateUser(ctx, created.ID, created); err != nil {
+		t.Fatalf("UpdateUser failed: %v", err)
+	}
+	if err := c.DeleteUser(ctx, created.ID); err != nil {
+		t.Fatalf("DeleteUser failed: %v", err)
+	}
+
+	// The update is filtered out.
+	first, err := stream.Next()
+	if err != nil || first.Type != "create" || first.User != created {
+		t.Fatalf("Unexpected first event %+v, %v", first, err)
+	}
+	second, err := stream.Next()
+	if err != nil || second.Type != "delete" || second.ID != first.ID+2 {
+		t.Fatalf("Unexpected second event %+v, %v", second, err)
+	}
+
+	// Resuming after the first event replays the buffered update and delete.
+	resumed, err := c.WatchChanges(ctx, url.Values{"since": {strconv.FormatInt(first.ID, 10)}, "id": {strconv.Itoa(created.ID)}})
+	if err != nil {
+		t.Fatalf("WatchChanges failed: %v", err)
+	}
+	defer resumed.Close()
+	for _, want := range []string{"update", "delete"} {
+		if event, err := resumed.Next(); err != nil || event.Type != want {
+			t.Errorf("Expected a replayed %s event, got %+v, %v", want, event, err)
+		}
+	}
+
+	// An event ID ahead of the log cannot be resumed from.
+	if _, err := c.WatchChanges(ctx, url.Values{"since": {strconv.FormatInt(second.ID+100, 10)}}); !errors.Is(err, ErrGone) {
+		t.Errorf("Expected ErrGone, got %v", err)
+	}
+}
+
+// TestClientBackupRestore tests that a snapshot can be restored after the users were deleted.
+func TestClientBackupRestore(t *testing.T) {
+	c := newTestServer(t)
+	ctx := context.Background()
+
+	created, err := c.CreateUser(ctx, models.User{Name: "Merry Brandybuck", Age: 36, Email: "merry@tolkien.com"})
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	backup, err := c.Snapshot(ctx)
+	if err != nil || backup.Count != len(backup.Users) || !slices.Contains(backup.Users, created) {
+		t.Fatalf("Unexpected snapshot %+v, %v", backup, err)
+	}
+	if err := c.DeleteUser(ctx, created.ID); err != nil {
+		t.Fatalf("DeleteUser failed: %v", err)
+	}
+
+	report, err := c.Restore(ctx, backup, "merge", false)
+	if err != nil || report.Created != 1 {
+		t.Fatalf("Unexpected restore report %+v, %v", report, err)
+	}
+	if got, err := c.GetUser(ctx, created.ID); err != nil || got != created {
+		t.Errorf("Expected the user to be restored, got %+v, %v", got, err)
+	}
+
+	if _, err := c.Restore(ctx, backup, "overwrite", true); !errors.Is(err, ErrBadRequest) {
+		t.Errorf("Expected ErrBadRequest for an unknown mode, got %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"user_api_with_concurrency/models"
+)
+
+// setupBackup defines the flags of the "backup" command: it takes a point-in-time snapshot of the server's users
+// and saves it as a versioned archive.
+func setupBackup(fs *flag.FlagSet, defaults outputOptions) func() error {
+	server := fs.String("server", current.Server, "Base URL of the API server")
+	timeout := fs.Duration("timeout", current.Timeout, "Timeout of the request")
+	file := fs.String("out", "", "Archive to write; gzip-compressed if it ends in .gz (default users-backup-<time>.json.gz)")
+	out := addOutputFlags(fs, defaults)
+
+	return func() error {
+		if err := out.validate(); err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+		backup, err := newClient(*server).Snapshot(ctx)
+		if err != nil {
+			return err
+		}
+
+		if *file == "" {
+			*file = "users-backup-" + backup.TakenAt.UTC().Format("20060102T150405Z") + ".json.gz"
+		}
+		if err := writeBackup(*file, backup); err != nil {
+			return err
+		}
+
+		summary := struct {
+			File    string    `json:"file"`
+			Version int       `json:"version"`
+			TakenAt time.Time `json:"taken_at"`
+			Users   int       `json:"users"`
+		}{*file, backup.Version, backup.TakenAt, backup.Count}
+		return out.printValue(os.Stdout, summary, func(w io.Writer) {
+			fmt.Fprintf(w, "Backed up %d users to %s (taken at %s)\n", backup.Count, *file, backup.TakenAt.Format(time.RFC3339))
+		})
+	}
+}
+
+// setupRestore defines the flags of the "restore" command: it loads a backup archive into the server.
+func setupRestore(fs *flag.FlagSet, defaults outputOptions) func() error {
+	server := fs.String("server", current.Server, "Base URL of the API server")
+	timeout := fs.Duration("timeout", current.Timeout, "Timeout of the request")
+	file := fs.String("file", "", "Backup archive to restore (use - for stdin)")
+	mode := fs.String("mode", models.RestoreMerge, "merge adds or replaces the users of the backup; replace also deletes the users missing from it")
+	dryRun := fs.Bool("dry-run", false, "Only report the changes the restore would make")
+	out := addOutputFlags(fs, defaults)
+
+	return func() error {
+		if *file == "" {
+			return fmt.Errorf("file must be specified using -file")
+		}
+		if *mode != models.RestoreMerge && *mode != models.RestoreReplace {
+			return fmt.Errorf("unknown restore mode %q (expected merge or replace)", *mode)
+		}
+		if err := out.validate(); err != nil {
+			return err
+		}
+
+		backup, err := readBackup(*file)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+		report, err := newClient(*server).Restore(ctx, backup, *mode, *dryRun)
+		if err != nil {
+			return err
+		}
+
+		return out.printValue(os.Stdout, report, func(w io.Writer) {
+			prefix := "Restored"
+			if report.DryRun {
+				prefix = "Dry run: would restore"
+			}
+			fmt.Fprintf(w, "%s %d users (%s): %d created, %d updated, %d unchanged, %d deleted\n",
+				prefix, backup.Count, report.Mode, report.Created, report.Updated, report.Unchanged, report.Deleted)
+		})
+	}
+}
+
+// writeBackup saves a backup as JSON, gzip-compressed if the filename ends in ".gz".
+// The archive is written to a temporary file first, so an interrupted backup never leaves a truncated archive.
+func writeBackup(filename string, backup models.Backup) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed.
+
+	var w io.Writer = tmp
+	var gz *gzip.Writer
+	if strings.HasSuffix(strings.ToLower(filename), ".gz") {
+		gz = gzip.NewWriter(tmp)
+		w = gz
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(backup)
+	if gz != nil && err == nil {
+		err = gz.Close()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// readBackup reads a backup archive, or stdin for "-". Compression is detected from the content.
+// Archives of a newer format version, or whose user count does not match, are rejected.
+func readBackup(filename string) (models.Backup, error) {
+	var backup models.Backup
+
+	var in io.Reader = os.Stdin
+	if filename != "-" {
+		file, err := os.Open(filename)
+		if err != nil {
+			return backup, err
+		}
+		defer file.Close()
+		in = file
+	}
+
+	// Detect gzip by its magic number.
+	br := bufio.NewReader(in)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return backup, err
+		}
+		defer gz.Close()
+		in = gz
+	} else {
+		in = br
+	}
+
+	if err := json.NewDecoder(in).Decode(&backup); err != nil {
+		return backup, fmt.Errorf("reading backup %s: %w", filename, err)
+	}
+	if backup.Version < 1 || backup.Version > models.BackupVersion {
+		return backup, fmt.Errorf("backup %s has version %d, this CLI supports 1 to %d", filename, backup.Version, models.BackupVersion)
+	}
+	if backup.Count != len(backup.Users) {
+		return backup, fmt.Errorf("backup %s is incomplete: expected %d users, found %d", filename, backup.Count, len(backup.Users))
+	}
+	return backup, nil
+}
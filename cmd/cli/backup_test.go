@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"user_api_with_concurrency/models"
+)
+
+// TestBackupArchive tests that archives round-trip with and without compression,
+// and that archives of a newer version or with missing users are rejected.
+func TestBackupArchive(t *testing.T) {
+	backup := models.Backup{
+		Version: models.BackupVersion,
+		TakenAt: time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC),
+		NextID:  4,
+		Count:   2,
+		Users: []models.User{
+			{ID: 1, Name: "Frodo Baggins", Age: 50, Email: "frodo@tolkien.com"},
+			{ID: 3, Name: "Çağla Öztürk", Age: 17, Email: "cagla@example.com", Locale: "tr"},
+		},
+	}
+
+	dir := t.TempDir()
+	for _, name := range []string{"backup.json", "backup.json.gz"} {
+		filename := filepath.Join(dir, name)
+		if err := writeBackup(filename, backup); err != nil {
+			t.Fatalf("writeBackup(%s) failed: %v", name, err)
+		}
+		got, err := readBackup(filename)
+		if err != nil || !reflect.DeepEqual(got, backup) {
+			t.Errorf("readBackup(%s) = %+v, %v", name, got, err)
+		}
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("Expected no temporary files to be left, got %d entries", len(entries))
+	}
+
+	for _, tt := range []struct {
+		content, expected string
+	}{
+		{`{"version":2,"count":0,"users":[]}`, "has version 2"},
+		{`{"version":1,"count":3,"users":[]}`, "expected 3 users, found 0"},
+		{`{"version":1,`, "reading backup"},
+	} {
+		filename := filepath.Join(dir, "invalid.json")
+		os.WriteFile(filename, []byte(tt.content), 0644)
+		if _, err := readBackup(filename); err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("Expected %q, got %v", tt.expected, err)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// command is a CLI command: either a group of subcommands, or a leaf with flags and an action.
+// The command tree is built by rootCommand for every invocation, so flag defaults follow the current settings.
+type command struct {
+	name    string
+	summary string // One-line description shown in command lists.
+	usage   string // Arguments shown after the command path in the usage line, e.g. "-file <file> [-dry-run]".
+	details string // Extra help text printed after the options or the command list.
+	hidden  bool   // Hidden commands run but are left out of help and completion.
+
+	subcommands []*command
+
+	// shared defines flags inherited by the command and all its subcommands.
+	shared func(fs *flag.FlagSet, global outputOptions)
+	// setup defines the flags of a leaf command and returns its action, run after the flags are parsed.
+	setup func(fs *flag.FlagSet, global outputOptions) func() error
+	// args returns the completions of the next positional argument, given the previous ones; nil completes none.
+	args func(previous []string) []string
+}
+
+// lookup returns the visible or hidden subcommand with the given name, or nil.
+func (c *command) lookup(name string) *command {
+	for _, sub := range c.subcommands {
+		if sub.name == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+// names returns the names of the visible subcommands.
+func (c *command) names() []string {
+	var names []string
+	for _, sub := range c.subcommands {
+		if !sub.hidden {
+			names = append(names, sub.name)
+		}
+	}
+	return names
+}
+
+// execute runs the command named by args. It descends into the subcommands named by the leading
+// arguments, then parses the remaining ones with the flags of the leaf and its ancestors and runs the action.
+// Groups print their help when no subcommand is given, and fail on unknown ones.
+func (c *command) execute(args []string, global outputOptions) error {
+	path := []*command{c}
+	for cmd := c; len(cmd.subcommands) > 0; {
+		if len(args) == 0 || isHelpFlag(args[0]) {
+			printHelp(os.Stdout, path, nil)
+			return nil
+		}
+		sub := cmd.lookup(args[0])
+		if sub == nil {
+			printHelp(os.Stdout, path, nil)
+			return fmt.Errorf("unknown command %q", strings.TrimPrefix(commandPath(path)+" "+args[0], "cli "))
+		}
+		path = append(path, sub)
+		cmd, args = sub, args[1:]
+	}
+
+	fs, action := newFlagSet(path, global)
+	fs.Usage = func() { printHelp(os.Stdout, path, fs) }
+	if done, err := parseFlags(fs, args); done {
+		return err
+	}
+	return action()
+}
+
+// newFlagSet returns the flag set of a leaf command, with the shared flags of its ancestors, and its action.
+func newFlagSet(path []*command, global outputOptions) (*flag.FlagSet, func() error) {
+	fs := flag.NewFlagSet(strings.TrimPrefix(commandPath(path), "cli "), flag.ContinueOnError)
+	for _, cmd := range path {
+		if cmd.shared != nil {
+			cmd.shared(fs, global)
+		}
+	}
+	leaf := path[len(path)-1]
+	if leaf.setup == nil {
+		return fs, func() error { return nil }
+	}
+	return fs, leaf.setup(fs, global)
+}
+
+// commandPath returns the names of the commands of a path separated by spaces, e.g. "cli users get".
+func commandPath(path []*command) string {
+	names := make([]string, len(path))
+	for i, cmd := range path {
+		names[i] = cmd.name
+	}
+	return strings.Join(names, " ")
+}
+
+// isHelpFlag reports whether an argument asks for help.
+func isHelpFlag(arg string) bool {
+	return arg == "-h" || arg == "-help" || arg == "--help"
+}
+
+// printHelp prints the help of the last command of a path: its usage line and summary,
+// followed by its subcommands for groups, or by the options of fs for leaves.
+func printHelp(w io.Writer, path []*command, fs *flag.FlagSet) {
+	cmd := path[len(path)-1]
+	name := commandPath(path)
+
+	usage := cmd.usage
+	if usage == "" && len(cmd.subcommands) > 0 {
+		usage = "<command> [options]"
+	}
+	fmt.Fprintln(w, "Usage:", strings.TrimSpace(name+" "+usage))
+	if cmd.summary != "" {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, cmd.summary)
+	}
+
+	if len(cmd.subcommands) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Commands:")
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, sub := range cmd.subcommands {
+			if !sub.hidden {
+				fmt.Fprintf(tw, "  %s\t%s\n", sub.name, sub.summary)
+			}
+		}
+		tw.Flush()
+	} else if fs != nil {
+		hasFlags := false
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintln(w)
+			fmt.Fprintln(w, "Options:")
+			fs.SetOutput(w)
+			fs.PrintDefaults()
+		}
+	}
+
+	if cmd.details != "" {
+		fmt.Fprintln(w)
+		fmt.Fprint(w, cmd.details)
+	}
+	if len(cmd.subcommands) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "Use '%s <command> -help' for more information on a command.\n", name)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"strings"
+	"testing"
+)
+
+// TestCommandExecute tests running nested commands with shared flags, and their errors.
+func TestCommandExecute(t *testing.T) {
+	var server, got string
+	root := &command{
+		name: "cli",
+		subcommands: []*command{
+			{
+				name: "users",
+				shared: func(fs *flag.FlagSet, global outputOptions) {
+					fs.StringVar(&server, "server", "http://default", "Server")
+				},
+				subcommands: []*command{
+					{name: "get", setup: func(fs *flag.FlagSet, global outputOptions) func() error {
+						id := fs.String("id", "", "User ID")
+						return func() error {
+							got = server + " " + *id + " " + strings.Join(fs.Args(), ",")
+							return nil
+						}
+					}},
+				},
+			},
+		},
+	}
+
+	if err := root.execute([]string{"users", "get", "-server", "http://example", "-id", "7", "extra"}, outputOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got != "http://example 7 extra" {
+		t.Errorf("Unexpected action arguments %q", got)
+	}
+
+	for _, args := range [][]string{{"users", "frobnicate"}, {"bogus"}, {"users", "get", "-unknown"}} {
+		if err := root.execute(args, outputOptions{}); err == nil {
+			t.Errorf("Expected %q to fail", args)
+		}
+	}
+	for _, args := range [][]string{{}, {"users"}, {"users", "-help"}, {"users", "get", "-h"}} {
+		if err := root.execute(args, outputOptions{}); err != nil {
+			t.Errorf("Expected %q to print the help, got %v", args, err)
+		}
+	}
+}
+
+// TestPrintHelp tests the generated help of groups and leaves.
+func TestPrintHelp(t *testing.T) {
+	root := rootCommand()
+	var buf bytes.Buffer
+	printHelp(&buf, []*command{root}, nil)
+	help := buf.String()
+	for _, expected := range []string{"Usage: cli [global options] <command> [options]", "  users ", "  completion ", "Global options:"} {
+		if !strings.Contains(help, expected) {
+			t.Errorf("Expected the help to contain %q:\n%s", expected, help)
+		}
+	}
+	if strings.Contains(help, "__complete") {
+		t.Errorf("Expected hidden commands to be left out of the help:\n%s", help)
+	}
+
+	users := root.lookup("users")
+	path := []*command{root, users, users.lookup("get")}
+	fs, _ := newFlagSet(path, outputOptions{})
+	buf.Reset()
+	printHelp(&buf, path, fs)
+	for _, expected := range []string{"Usage: cli users get [-server <url>] -id <user_id>", "Get a user by ID", "-id int", "-server string", "-timeout duration"} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Expected the help to contain %q:\n%s", expected, buf.String())
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"user_api_with_concurrency/models"
+	"user_api_with_concurrency/utils"
+)
+
+// completionScripts are the scripts printed by "cli completion <shell>". They pass the words of the
+// command line to the hidden "__complete" command, the last one being the word to complete,
+// and fall back to file completion when it prints nothing. {{prog}} is the name of the program
+// and {{func}} a shell function name derived from it.
+var completionScripts = map[string]string{
+	"bash": `# bash completion for {{prog}}; load it with: source <({{prog}} completion bash)
+{{func}}() {
+    local line="${COMP_LINE:0:COMP_POINT}" cur=""
+    local -a words
+    read -ra words <<< "$line"
+    if [[ "$line" != *[[:space:]] ]]; then
+        cur="${words[${#words[@]}-1]}"
+        unset "words[${#words[@]}-1]"
+    fi
+    local IFS=$'\n'
+    COMPREPLY=($("${words[0]}" __complete -- "${words[@]:1}" "$cur" 2>/dev/null))
+}
+complete -o default -F {{func}} {{prog}}
+`,
+	"zsh": `#compdef {{prog}}
+# zsh completion for {{prog}}; load it with: source <({{prog}} completion zsh)
+{{func}}() {
+    local -a candidates
+    candidates=("${(@f)$(${words[1]} __complete -- "${(@)words[2,CURRENT-1]}" "${words[CURRENT]}" 2>/dev/null)}")
+    candidates=(${candidates:#})
+    if (( ! ${#candidates} )); then
+        _files
+        return
+    fi
+    [[ "${words[CURRENT]}" == -*=* ]] && compset -P '*='
+    compadd -Q -a candidates
+}
+compdef {{func}} {{prog}}
+`,
+	"fish": `# fish completion for {{prog}}; load it with: {{prog}} completion fish | source
+function {{func}}
+    set -l tokens (commandline -opc)
+    set -l current (commandline -ct)
+    set -l candidates ($tokens[1] __complete -- $tokens[2..-1] $current 2>/dev/null)
+    if test (count $candidates) -eq 0
+        __fish_complete_path $current
+        return
+    end
+    # Values of -flag=value words are printed without the flag, which fish expects in the candidate.
+    set -l prefix (string match -r -- '^-[^=]*=' $current)
+    for candidate in $candidates
+        echo "$prefix$candidate"
+    end
+end
+complete -c {{prog}} -f -a '({{func}})'
+`,
+}
+
+// completionShells are the shells "cli completion" supports, in the order they are listed.
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// setupCompletion defines the flags of the "completion" command: it prints the completion script of a shell.
+func setupCompletion(fs *flag.FlagSet, defaults outputOptions) func() error {
+	prog := fs.String("name", "cli", "Name of the program the script completes, as it is typed in the shell")
+	return func() error {
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: cli completion [-name <program>] <%s>", strings.Join(completionShells, "|"))
+		}
+		script, ok := completionScripts[fs.Arg(0)]
+		if !ok {
+			return fmt.Errorf("unsupported shell %q (expected %s)", fs.Arg(0), strings.Join(completionShells, ", "))
+		}
+		// Shell function names allow letters, digits and underscores only.
+		fn := "_" + strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+				return r
+			}
+			return '_'
+		}, *prog) + "_complete"
+		fmt.Print(strings.NewReplacer("{{prog}}", *prog, "{{func}}", fn).Replace(script))
+		return nil
+	}
+}
+
+// setupComplete defines the hidden "__complete" command called by the completion scripts.
+// Its arguments are the words of the command line after the program name, the last one being
+// the word to complete, and it prints one completion per line.
+func setupComplete(fs *flag.FlagSet, defaults outputOptions) func() error {
+	return func() error {
+		words := fs.Args()
+		if len(words) == 0 {
+			return nil
+		}
+		candidates, _ := completeCommandLine(words[:len(words)-1], words[len(words)-1])
+		for _, c := range candidates {
+			fmt.Println(c)
+		}
+		return nil
+	}
+}
+
+// completeCommandLine completes the word of a command line following the given words.
+// The global flags among the words select the profile and server user IDs are fetched from.
+// It also returns the length of the word's prefix kept by the completions, as completeArgs.
+func completeCommandLine(words []string, word string) ([]string, int) {
+	globals, opts := newGlobalFlags(flag.ContinueOnError)
+	globals.SetOutput(io.Discard)
+	globals.Usage = func() {}
+
+	ids := func() []string { return fetchUserIDs(current.Server) }
+	values := func(name string) []string { return flagValues(name, ids) }
+
+	// Complete the global flags, and their values, before the command.
+	err := globals.Parse(words)
+	if err != nil || globals.NArg() == 0 {
+		var prev string
+		if len(words) > 0 {
+			prev = words[len(words)-1]
+		}
+		if candidates, n, ok := completeFlags(globals, prev, word, values); ok || err != nil {
+			return candidates, n
+		}
+	}
+
+	// Use the profile and server selected by the global flags; errors leave the defaults.
+	globals.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "profile":
+			loadSettings(*opts.profile, false)
+		case "server":
+			current.set("server", *opts.server)
+		}
+	})
+	return completeArgs(rootCommand(), globals.Args(), word, values)
+}
+
+// completeArgs completes the word following the arguments of a command: subcommand names in groups,
+// and flag names, flag values and positional arguments in leaves. values returns the completions of a flag's value.
+// It also returns the length of the word's prefix kept by the completions, e.g. "-id=" when completing "-id=1".
+func completeArgs(root *command, args []string, word string, values func(flag string) []string) ([]string, int) {
+	// Descend into the subcommands named by the arguments.
+	cmd, path := root, []*command{root}
+	for len(cmd.subcommands) > 0 {
+		if len(args) == 0 {
+			return filterPrefix(cmd.names(), word), 0
+		}
+		if cmd = cmd.lookup(args[0]); cmd == nil || cmd.hidden {
+			return nil, 0
+		}
+		path = append(path, cmd)
+		args = args[1:]
+	}
+
+	fs, _ := newFlagSet(path, outputOptions{})
+	fs.SetOutput(io.Discard)
+	var prev string
+	if len(args) > 0 {
+		prev = args[len(args)-1]
+	}
+	if candidates, n, ok := completeFlags(fs, prev, word, values); ok {
+		return candidates, n
+	}
+
+	// Complete the positional argument given the previous ones.
+	if cmd.args == nil || fs.Parse(args) != nil {
+		return nil, 0
+	}
+	return filterPrefix(cmd.args(fs.Args()), word), 0
+}
+
+// completeFlags completes the word as a flag name of fs, or as the value of the flag before it or
+// in the word itself ("-id=1"). It reports false if the word is neither, e.g. a positional argument.
+func completeFlags(fs *flag.FlagSet, prev, word string, values func(flag string) []string) ([]string, int, bool) {
+	// Value of the previous flag, unless it is boolean or already has its value.
+	if name, ok := flagName(prev); ok && !strings.Contains(name, "=") {
+		if f := fs.Lookup(name); f != nil && !isBoolFlag(f) {
+			return filterPrefix(values(name), word), 0, true
+		}
+	}
+
+	name, ok := flagName(word)
+	if !ok {
+		return nil, 0, false
+	}
+	if name, value, ok := strings.Cut(name, "="); ok {
+		return filterPrefix(values(name), value), len(word) - len(value), true
+	}
+
+	// Flag names, with as many dashes as the word.
+	dashes := word[:len(word)-len(name)]
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) { names = append(names, dashes+f.Name) })
+	return filterPrefix(names, word), 0, true
+}
+
+// flagName returns the name of a flag argument without its dashes, e.g. "id" for "--id".
+// A lone "-" is a flag with an empty name, so that it completes all flags.
+func flagName(arg string) (string, bool) {
+	if !strings.HasPrefix(arg, "-") || arg == "--" {
+		return "", false
+	}
+	return strings.TrimPrefix(arg[1:], "-"), true
+}
+
+// isBoolFlag reports whether a flag takes no value, like the flag package does.
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// flagValues returns the completions of the value of a flag, by flag name.
+// ids is called only for the flags taking user IDs, since it queries the server.
+func flagValues(name string, ids func() []string) []string {
+	switch name {
+	case "id", "ids":
+		return ids()
+	case "output", "o":
+		return outputFormats
+	case "type":
+		return []string{utils.OpCreate, utils.OpUpdate, utils.OpDelete}
+	case "mode":
+		return []string{models.RestoreMerge, models.RestoreReplace}
+	case "profile":
+		if cfg, err := loadConfig(defaultConfigPath()); err == nil {
+			return cfg.order
+		}
+	}
+	return nil
+}
+
+// fetchUserIDs returns the IDs of the users on the server, sorted.
+// Errors are ignored, since completion must never get in the way.
+func fetchUserIDs(server string) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	users, err := newClient(server).ListUsers(ctx)
+	if err != nil {
+		return nil
+	}
+
+	slices.SortFunc(users, func(a, b models.User) int { return a.ID - b.ID })
+	ids := make([]string, len(users))
+	for i, u := range users {
+		ids[i] = strconv.Itoa(u.ID)
+	}
+	return ids
+}
+
+// filterPrefix returns the options starting with prefix.
+func filterPrefix(options []string, prefix string) []string {
+	var matches []string
+	for _, o := range options {
+		if strings.HasPrefix(o, prefix) {
+			matches = append(matches, o)
+		}
+	}
+	return matches
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"user_api_with_concurrency/models"
+)
+
+// TestCompleteCommandLine tests completing command lines like the completion scripts do,
+// including global flags and user IDs from the server selected with -server.
+func TestCompleteCommandLine(t *testing.T) {
+	setUsersServer(t, models.User{ID: 3}, models.User{ID: 31}, models.User{ID: 4})
+	server := current.Server
+	current.Server = "http://localhost:1" // The IDs must come from the -server flag.
+
+	tests := []struct {
+		words          []string
+		word           string
+		expected       []string
+		expectedPrefix int
+	}{
+		{nil, "us", []string{"users"}, 0},
+		{nil, "-pro", []string{"-profile"}, 0},
+		{[]string{"-o"}, "y", []string{"yaml"}, 0},
+		{[]string{"-o", "json"}, "wat", []string{"watch"}, 0},
+		{[]string{"users"}, "", []string{"list", "get", "create", "update", "delete"}, 0},
+		{[]string{"users", "get"}, "--ti", []string{"--timeout"}, 0},
+		{[]string{"-server", server, "users", "get", "-id"}, "3", []string{"3", "31"}, 0},
+		{[]string{"-server", server, "users", "delete"}, "-id=", []string{"3", "4", "31"}, 4},
+		{[]string{"users", "get", "-no-headers"}, "x", nil, 0},
+		{[]string{"config", "get"}, "t", []string{"token", "timeout"}, 0},
+		{[]string{"config", "set", "server"}, "", nil, 0},
+		{[]string{"restore", "-mode"}, "", []string{"merge", "replace"}, 0},
+		{[]string{"completion"}, "", []string{"bash", "zsh", "fish"}, 0},
+		{nil, "__", nil, 0},
+		{[]string{"bogus"}, "", nil, 0},
+	}
+	for _, tt := range tests {
+		got, prefix := completeCommandLine(tt.words, tt.word)
+		if !slices.Equal(got, tt.expected) || prefix != tt.expectedPrefix {
+			t.Errorf("complete(%q, %q) = %q, %d; want %q, %d", tt.words, tt.word, got, prefix, tt.expected, tt.expectedPrefix)
+		}
+	}
+}
+
+// TestCompletionScripts tests that every shell has a script calling back into the program.
+func TestCompletionScripts(t *testing.T) {
+	for _, shell := range completionShells {
+		script := completionScripts[shell]
+		if !strings.Contains(script, "{{prog}} ") || !strings.Contains(script, "__complete --") {
+			t.Errorf("The %s script does not complete {{prog}} with __complete", shell)
+		}
+	}
+	if err := dispatch([]string{"completion", "-name", "user-cli", "bash"}, outputOptions{}); err != nil {
+		t.Errorf("completion bash failed: %v", err)
+	}
+	if err := dispatch([]string{"completion", "powershell"}, outputOptions{}); err == nil {
+		t.Errorf("Expected an unsupported shell to fail")
+	}
+}
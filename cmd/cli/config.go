@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"user_api_with_concurrency/services"
+)
+
+// configKeys are the settings a profile may define, in the order they are written.
+var configKeys = []string{"server", "token", "output", "concurrency", "timeout"}
+
+// settings are the CLI settings of an invocation.
+type settings struct {
+	Profile     string        // Name of the selected profile.
+	Server      string        // Base URL of the API server.
+	Token       string        // Bearer token sent in the Authorization header; empty sends none.
+	Output      string        // Default output format.
+	Concurrency int           // Default number of concurrent requests of batch commands.
+	Timeout     time.Duration // Default request timeout.
+}
+
+// current holds the settings of the running invocation, resolved by main from the flags,
+// the environment and the selected profile, in this order of precedence.
+var current = defaultSettings()
+
+// defaultSettings returns the settings used when neither flags, environment nor profile set them.
+func defaultSettings() settings {
+	return settings{
+		Profile:     "default",
+		Server:      "http://localhost:3000",
+		Output:      "table",
+		Concurrency: services.MaxConcurrentFetches,
+		Timeout:     10 * time.Second,
+	}
+}
+
+// set parses and applies the value of a setting.
+func (s *settings) set(key, value string) error {
+	switch key {
+	case "server":
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid server %q: expected an http or https URL", value)
+		}
+		s.Server = strings.TrimRight(value, "/")
+	case "token":
+		s.Token = value
+	case "output":
+		if !slices.Contains(outputFormats, value) {
+			return fmt.Errorf("invalid output %q: expected one of %s", value, strings.Join(outputFormats, ", "))
+		}
+		s.Output = value
+	case "concurrency":
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid concurrency %q: expected a positive integer", value)
+		}
+		s.Concurrency = n
+	case "timeout":
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid timeout %q: expected a duration such as 10s", value)
+		}
+		s.Timeout = d
+	default:
+		return fmt.Errorf("unknown setting %q (expected one of %s)", key, strings.Join(configKeys, ", "))
+	}
+	return nil
+}
+
+// settingsEnv maps settings to the environment variables overriding the profile.
+// EXTERNAL_API_URL is still honored for the server, after USER_API_SERVER.
+var settingsEnv = []struct{ key, env string }{
+	{"server", "USER_API_SERVER"},
+	{"server", "EXTERNAL_API_URL"},
+	{"token", "USER_API_TOKEN"},
+	{"output", "USERCLI_OUTPUT"},
+	{"concurrency", "USERCLI_CONCURRENCY"},
+	{"timeout", "USERCLI_TIMEOUT"},
+}
+
+// resolveSettings returns the settings of a profile overridden by the environment.
+// The "default" profile may be missing from the file; other profiles must exist.
+func resolveSettings(cfg *configFile, profile string) (settings, error) {
+	s := defaultSettings()
+	s.Profile = profile
+
+	values, ok := cfg.profiles[profile]
+	if !ok && profile != "default" {
+		return s, fmt.Errorf("unknown profile %q in %s", profile, cfg.path)
+	}
+	for _, key := range configKeys {
+		if value, ok := values[key]; ok {
+			if err := s.set(key, value); err != nil {
+				return s, fmt.Errorf("profile %q: %w", profile, err)
+			}
+		}
+	}
+
+	serverSet := false
+	for _, e := range settingsEnv {
+		value := os.Getenv(e.env)
+		if value == "" || (e.key == "server" && serverSet) {
+			continue
+		}
+		if err := s.set(e.key, value); err != nil {
+			return s, fmt.Errorf("%s: %w", e.env, err)
+		}
+		serverSet = serverSet || e.key == "server"
+	}
+	return s, nil
+}
+
+// configFile is the CLI configuration file: an INI-like list of profiles.
+//
+//	[default]
+//	server = http://localhost:3000
+//	output = table
+//
+//	[staging]
+//	server = https://staging.example.com
+//	token = secret
+type configFile struct {
+	path     string
+	order    []string                     // Profile names, in file order.
+	profiles map[string]map[string]string // Settings by profile name.
+}
+
+// defaultConfigPath returns the configuration file: USERCLI_CONFIG, or "usercli/config"
+// in the user's configuration directory (e.g. ~/.config/usercli/config).
+func defaultConfigPath() string {
+	if path := os.Getenv("USERCLI_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "usercli", "config")
+}
+
+// loadConfig reads a configuration file; a missing file is an empty configuration.
+// Blank lines and lines starting with "#" or ";" are ignored.
+func loadConfig(path string) (*configFile, error) {
+	cfg := &configFile{path: path, profiles: make(map[string]map[string]string)}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) || path == "" {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var section string
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		switch {
+		case text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, ";"):
+		case strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]"):
+			section = strings.TrimSpace(text[1 : len(text)-1])
+			if section == "" {
+				return nil, fmt.Errorf("%s:%d: empty profile name", path, line)
+			}
+			cfg.profile(section)
+		default:
+			key, value, ok := strings.Cut(text, "=")
+			key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+			if !ok || section == "" {
+				return nil, fmt.Errorf("%s:%d: expected [profile] or key = value", path, line)
+			}
+			if !slices.Contains(configKeys, key) {
+				return nil, fmt.Errorf("%s:%d: unknown setting %q", path, line, key)
+			}
+			cfg.profiles[section][key] = value
+		}
+	}
+	return cfg, scanner.Err()
+}
+
+// profile returns the settings of a profile, creating it if needed.
+func (c *configFile) profile(name string) map[string]string {
+	if _, ok := c.profiles[name]; !ok {
+		c.order = append(c.order, name)
+		c.profiles[name] = make(map[string]string)
+	}
+	return c.profiles[name]
+}
+
+// save writes the configuration file, readable only by the user since it may hold tokens.
+// Comments of the original file are not preserved.
+func (c *configFile) save() error {
+	if c.path == "" {
+		return fmt.Errorf("no configuration file; set USERCLI_CONFIG")
+	}
+
+	var b strings.Builder
+	for i, name := range c.order {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		fmt.Fprintf(&b, "[%s]\n", name)
+		for _, key := range configKeys {
+			if value, ok := c.profiles[name][key]; ok {
+				fmt.Fprintf(&b, "%s = %s\n", key, value)
+			}
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o700); err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// configCommand returns the "config" command, managing the settings of the profile selected with -profile.
+func configCommand() *command {
+	var out *outputOptions
+
+	// keyArg completes the setting name given as first argument.
+	keyArg := func(previous []string) []string {
+		if len(previous) == 0 {
+			return configKeys
+		}
+		return nil
+	}
+
+	// withConfig returns an action validating the output flags and the number of arguments,
+	// then running f on the configuration file, the selected profile and the arguments.
+	withConfig := func(fs *flag.FlagSet, usage string, nargs int, f func(cfg *configFile, name string, params []string) error) func() error {
+		return func() error {
+			if err := out.validate(); err != nil {
+				return err
+			}
+			if fs.NArg() != nargs {
+				return fmt.Errorf("usage: cli config %s", usage)
+			}
+			cfg, err := loadConfig(defaultConfigPath())
+			if err != nil {
+				return err
+			}
+			return f(cfg, current.Profile, fs.Args())
+		}
+	}
+
+	return &command{
+		name:    "config",
+		summary: "List, get or set the settings of configuration profiles",
+		usage:   "<command> [key] [value]",
+		details: "Settings: " + strings.Join(configKeys, ", ") + "\nUse 'cli -profile <name> config ...' to select the profile.\n",
+		shared: func(fs *flag.FlagSet, global outputOptions) {
+			out = addOutputFlags(fs, global)
+		},
+		subcommands: []*command{
+			{name: "list", summary: "List the profiles and their settings",
+				setup: func(fs *flag.FlagSet, global outputOptions) func() error {
+					return withConfig(fs, "list", 0, func(cfg *configFile, name string, params []string) error {
+						profiles := cfg.redacted()
+						return out.printValue(os.Stdout, profiles, func(w io.Writer) {
+							if len(cfg.order) == 0 {
+								fmt.Fprintf(w, "No profiles in %s\n", cfg.path)
+							}
+							for _, p := range cfg.order {
+								marker := " "
+								if p == name {
+									marker = "*" // The selected profile.
+								}
+								fmt.Fprintf(w, "%s %s\n", marker, p)
+								for _, key := range configKeys {
+									if value, ok := profiles[p][key]; ok {
+										fmt.Fprintf(w, "    %-12s %s\n", key, value)
+									}
+								}
+							}
+						})
+					})
+				}},
+			{name: "get", summary: "Print a setting of the profile", usage: "<key>", args: keyArg,
+				setup: func(fs *flag.FlagSet, global outputOptions) func() error {
+					return withConfig(fs, "get <key>", 1, func(cfg *configFile, name string, params []string) error {
+						value, ok := cfg.profiles[name][params[0]]
+						if !ok {
+							return fmt.Errorf("%q is not set in profile %q", params[0], name)
+						}
+						fmt.Println(value)
+						return nil
+					})
+				}},
+			{name: "set", summary: "Store a setting in the profile, creating it if needed", usage: "<key> <value>", args: keyArg,
+				setup: func(fs *flag.FlagSet, global outputOptions) func() error {
+					return withConfig(fs, "set <key> <value>", 2, func(cfg *configFile, name string, params []string) error {
+						var check settings
+						if err := check.set(params[0], params[1]); err != nil {
+							return err
+						}
+						cfg.profile(name)[params[0]] = params[1]
+						return cfg.save()
+					})
+				}},
+			{name: "unset", summary: "Remove a setting from the profile", usage: "<key>", args: keyArg,
+				setup: func(fs *flag.FlagSet, global outputOptions) func() error {
+					return withConfig(fs, "unset <key>", 1, func(cfg *configFile, name string, params []string) error {
+						if _, ok := cfg.profiles[name][params[0]]; !ok {
+							return fmt.Errorf("%q is not set in profile %q", params[0], name)
+						}
+						delete(cfg.profiles[name], params[0])
+						return cfg.save()
+					})
+				}},
+		},
+	}
+}
+
+// redacted returns the profiles with their tokens masked, for listing.
+func (c *configFile) redacted() map[string]map[string]string {
+	profiles := make(map[string]map[string]string, len(c.profiles))
+	for name, values := range c.profiles {
+		profiles[name] = make(map[string]string, len(values))
+		for key, value := range values {
+			if key == "token" && value != "" {
+				value = "****"
+			}
+			profiles[name][key] = value
+		}
+	}
+	return profiles
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useConfig points the CLI at a temporary configuration file with the given content.
+func useConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "usercli", "config")
+	if content != "" {
+		os.MkdirAll(filepath.Dir(path), 0o700)
+		os.WriteFile(path, []byte(content), 0o600)
+	}
+	t.Setenv("USERCLI_CONFIG", path)
+	for _, e := range settingsEnv {
+		t.Setenv(e.env, "")
+	}
+
+	old := current
+	t.Cleanup(func() { current = old })
+	return path
+}
+
+// TestResolveSettings tests that the environment overrides the profile, which overrides the defaults.
+func TestResolveSettings(t *testing.T) {
+	path := useConfig(t, `
+# Local development.
+[default]
+output = json
+
+[staging]
+server = https://staging.example.com/
+token = secret
+concurrency = 20
+timeout = 30s
+`)
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := resolveSettings(cfg, "default")
+	if err != nil || s.Output != "json" || s.Server != "http://localhost:3000" || s.Concurrency != 5 {
+		t.Errorf("Unexpected default settings %+v: %v", s, err)
+	}
+
+	t.Setenv("USERCLI_TIMEOUT", "1m")
+	t.Setenv("EXTERNAL_API_URL", "http://legacy:3000")
+	s, err = resolveSettings(cfg, "staging")
+	want := settings{Profile: "staging", Server: "http://legacy:3000", Token: "secret", Output: "table", Concurrency: 20, Timeout: time.Minute}
+	if err != nil || s != want {
+		t.Errorf("Expected %+v, got %+v (%v)", want, s, err)
+	}
+
+	t.Setenv("USER_API_SERVER", "http://env:3000")
+	if s, _ = resolveSettings(cfg, "staging"); s.Server != "http://env:3000" {
+		t.Errorf("Expected USER_API_SERVER to take precedence, got %s", s.Server)
+	}
+
+	if _, err := resolveSettings(cfg, "production"); err == nil {
+		t.Errorf("Expected an unknown profile to fail")
+	}
+}
+
+// TestLoadConfig_Invalid tests that malformed configuration files are rejected with their line.
+func TestLoadConfig_Invalid(t *testing.T) {
+	for _, content := range []string{
+		"server = http://outside-a-profile\n",
+		"[default]\ncolor = blue\n",
+		"[default]\nserver\n",
+		"[]\n",
+	} {
+		path := useConfig(t, content)
+		if _, err := loadConfig(path); err == nil || !strings.Contains(err.Error(), path+":") {
+			t.Errorf("Expected %q to be rejected with its line, got %v", content, err)
+		}
+	}
+}
+
+// TestConfigCommand tests setting, getting, listing and unsetting profile settings.
+func TestConfigCommand(t *testing.T) {
+	path := useConfig(t, "")
+	current.Profile = "staging"
+
+	for _, args := range [][]string{
+		{"set", "server", "https://staging.example.com"},
+		{"set", "token", "secret"},
+		{"set", "concurrency", "8"},
+		{"unset", "concurrency"},
+		{"list"},
+		{"get", "server"},
+	} {
+		if err := dispatch(append([]string{"config"}, args...), outputOptions{Format: "table"}); err != nil {
+			t.Errorf("config %v failed: %v", args, err)
+		}
+	}
+
+	data, _ := os.ReadFile(path)
+	if string(data) != "[staging]\nserver = https://staging.example.com\ntoken = secret\n" {
+		t.Errorf("Unexpected configuration file:\n%s", data)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected the file to be private, got %v", info.Mode())
+	}
+
+	for _, args := range [][]string{
+		{"set", "output", "html"},
+		{"set", "color", "blue"},
+		{"get", "timeout"},
+		{"unset", "timeout"},
+		{"set", "server"},
+	} {
+		if err := dispatch(append([]string{"config"}, args...), outputOptions{Format: "table"}); err == nil {
+			t.Errorf("Expected config %v to fail", args)
+		}
+	}
+}
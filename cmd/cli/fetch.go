@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"user_api_with_concurrency/models"
+	"user_api_with_concurrency/services"
+)
+
+// maxBatchIDs bounds the number of IDs of a batch, so a typo like "1-1000000000" fails fast.
+const maxBatchIDs = 1_000_000
+
+// setupFetch defines the flags of the "fetch-additional-info" command: it fetches users from the
+// external API in a batch and prints them, optionally exporting them to a file.
+func setupFetch(fs *flag.FlagSet, global outputOptions) func() error {
+	// Define flags for the user IDs to fetch.
+	userID := fs.Int("id", 0, "User ID to fetch information for")
+	ids := fs.String("ids", "", "Comma-separated IDs and ranges to fetch, e.g. 1,2,5-20")
+	fromFile := fs.String("from-file", "", "File with IDs and ranges to fetch, one or more per line (use - for stdin)")
+	// Define flags to tune the batch.
+	concurrency := fs.Int("concurrency", current.Concurrency, "Maximum number of concurrent requests")
+	timeout := fs.Duration("timeout", current.Timeout, "Timeout of each request (0 for none)")
+	outFile := fs.String("out", "", "Write the fetched users to a file instead of stdout, in the -output format")
+	// Define flags to export the fetched users to a file.
+	exportFile := fs.String("export", "", "Export the fetched users to a file (csv, tsv, jsonl, json, xml, optionally .gz)")
+	exportFormat := fs.String("format", "", "Export format, overriding the file extension")
+	out := addOutputFlags(fs, global)
+
+	return func() error {
+		if err := out.validate(); err != nil {
+			return err
+		}
+
+		// Collect the user IDs from all sources, without duplicates.
+		var userIDs []int
+		seen := make(map[int]bool)
+		var err error
+		if *userID != 0 {
+			userIDs, err = parseIDs(userIDs, strconv.Itoa(*userID), seen)
+		}
+		if err == nil && *ids != "" {
+			userIDs, err = parseIDs(userIDs, *ids, seen)
+		}
+		if err == nil && *fromFile != "" {
+			userIDs, err = readIDs(userIDs, *fromFile, seen)
+		}
+		if err != nil {
+			return err
+		}
+
+		// Validate that at least one user ID is provided.
+		if len(userIDs) == 0 {
+			return fmt.Errorf("user IDs must be specified using -id, -ids or -from-file")
+		}
+
+		// Fetch additional information for the users, with a progress bar on terminals.
+		opts := services.FetchOptions{Concurrency: *concurrency, Timeout: *timeout}
+		if isTerminal(os.Stderr) {
+			opts.Progress = (&progressBar{w: os.Stderr}).update
+		}
+		results := services.FetchUsersInfo(context.Background(), userIDs, opts)
+
+		var users []models.User
+		for _, r := range results {
+			if r.Err == nil {
+				users = append(users, r.User)
+			}
+		}
+
+		// Print the fetched user information, to a file if requested.
+		w := io.Writer(os.Stdout)
+		if *outFile != "" {
+			file, err := os.Create(*outFile)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			w = file
+		}
+		if err := out.printUsers(w, users); err != nil {
+			return err
+		}
+
+		// Export the fetched users if requested.
+		if *exportFile != "" {
+			if err := exportUsers(users, *exportFile, *exportFormat); err != nil {
+				return fmt.Errorf("failed to export users: %w", err)
+			}
+		}
+
+		// Summarize the batch on stderr, so it never mixes with the output.
+		if failed := printFetchSummary(os.Stderr, results); failed > 0 {
+			return errFailed // Signal failed fetches to scripts.
+		}
+		return nil
+	}
+}
+
+// parseIDs parses a comma-separated list of IDs and inclusive ranges, e.g. "1,2,5-20".
+// Duplicates are removed, keeping the first occurrence, and appended to ids.
+func parseIDs(ids []int, spec string, seen map[int]bool) ([]int, error) {
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		// Parse either a single ID or a range.
+		first, last, isRange := strings.Cut(part, "-")
+		from, err := strconv.Atoi(strings.TrimSpace(first))
+		to := from
+		if err == nil && isRange {
+			to, err = strconv.Atoi(strings.TrimSpace(last))
+		}
+		if err != nil || from <= 0 || to < from {
+			return ids, fmt.Errorf("invalid ID or range %q", part)
+		}
+		if to-from >= maxBatchIDs || len(ids)+to-from >= maxBatchIDs {
+			return ids, fmt.Errorf("too many IDs (at most %d)", maxBatchIDs)
+		}
+
+		for id := from; id <= to; id++ {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// readIDs reads IDs and ranges from a file ("-" for stdin), one or more per line
+// separated by commas or whitespace. Blank lines and lines starting with "#" are ignored.
+func readIDs(ids []int, filename string, seen map[int]bool) ([]int, error) {
+	var in io.Reader = os.Stdin
+	if filename != "-" {
+		file, err := os.Open(filename)
+		if err != nil {
+			return ids, err
+		}
+		defer file.Close()
+		in = file
+	}
+
+	scanner := bufio.NewScanner(in)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		var err error
+		if ids, err = parseIDs(ids, strings.Join(strings.Fields(text), ","), seen); err != nil {
+			return ids, fmt.Errorf("%s:%d: %w", filename, line, err)
+		}
+	}
+	return ids, scanner.Err()
+}
+
+// isTerminal reports whether the file is a terminal, as opposed to a pipe or a regular file.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// progressBar renders the progress of a batch on a single terminal line.
+type progressBar struct {
+	w      io.Writer
+	failed int
+}
+
+// update redraws the bar after a fetch.
+func (p *progressBar) update(done, total int, result services.FetchResult) {
+	if result.Err != nil {
+		p.failed++
+	}
+
+	const width = 30
+	filled := width * done / total
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", width-filled)
+	fmt.Fprintf(p.w, "\r\033[K[%s] %d/%d (%d failed)", bar, done, total, p.failed)
+	if done == total {
+		fmt.Fprintln(p.w) // Keep the final state and move to the next line.
+	}
+}
+
+// printFetchSummary prints the number of successes and failures, then every failure.
+func printFetchSummary(w io.Writer, results []services.FetchResult) (failed int) {
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+		}
+	}
+	fmt.Fprintf(w, "Fetched %d of %d users (%d failed)\n", len(results)-failed, len(results), failed)
+	for _, r := range results {
+		if r.Err != nil {
+			fmt.Fprintf(w, "  id %d: %v\n", r.ID, r.Err)
+		}
+	}
+	return failed
+}
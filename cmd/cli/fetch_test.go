@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"user_api_with_concurrency/services"
+)
+
+// TestParseIDs tests parsing ID lists and ranges, including invalid ones.
+func TestParseIDs(t *testing.T) {
+	ids, err := parseIDs(nil, "3, 1,5-8,7,2-2", make(map[int]bool))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{3, 1, 5, 6, 7, 8, 2}; !slices.Equal(ids, want) {
+		t.Errorf("Expected %v, got %v", want, ids)
+	}
+
+	for _, spec := range []string{"a", "0", "5-3", "1-", "-3", "1-2000000"} {
+		if _, err := parseIDs(nil, spec, make(map[int]bool)); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+}
+
+// TestReadIDs tests reading IDs from a file with comments and mixed separators.
+func TestReadIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ids.txt")
+	os.WriteFile(path, []byte("# users to fetch\n1 2\n\n4-5,2\n"), 0o644)
+
+	seen := map[int]bool{1: true} // Already given with -id.
+	ids, err := readIDs([]int{1}, path, seen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 2, 4, 5}; !slices.Equal(ids, want) {
+		t.Errorf("Expected %v, got %v", want, ids)
+	}
+
+	os.WriteFile(path, []byte("1\nx\n"), 0o644)
+	if _, err := readIDs(nil, path, make(map[int]bool)); err == nil || !strings.Contains(err.Error(), ":2:") {
+		t.Errorf("Expected an error on line 2, got %v", err)
+	}
+}
+
+// TestFetchSummary tests the summary and the progress bar.
+func TestFetchSummary(t *testing.T) {
+	results := []services.FetchResult{{ID: 1}, {ID: 2, Err: errors.New("boom")}}
+
+	var buf bytes.Buffer
+	if failed := printFetchSummary(&buf, results); failed != 1 {
+		t.Errorf("Expected 1 failure, got %d", failed)
+	}
+	if buf.String() != "Fetched 1 of 2 users (1 failed)\n  id 2: boom\n" {
+		t.Errorf("Unexpected summary %q", buf.String())
+	}
+
+	buf.Reset()
+	bar := &progressBar{w: &buf}
+	bar.update(1, 2, results[0])
+	bar.update(2, 2, results[1])
+	if !strings.HasSuffix(buf.String(), "2/2 (1 failed)\n") {
+		t.Errorf("Unexpected progress %q", buf.String())
+	}
+}
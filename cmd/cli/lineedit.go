@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// Control keys handled by the line editor.
+const (
+	keyCtrlA     = 1
+	keyCtrlB     = 2
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyCtrlF     = 6
+	keyBackspace = 8
+	keyTab       = 9
+	keyCtrlK     = 11
+	keyCtrlL     = 12
+	keyCtrlN     = 14
+	keyCtrlP     = 16
+	keyCtrlU     = 21
+	keyCtrlW     = 23
+	keyEscape    = 27
+	keyDelete    = 127
+)
+
+// completer returns the candidates for the word ending at pos, and the position where that word starts.
+type completer func(line []rune, pos int) (candidates []string, start int)
+
+// lineEditor reads lines from a terminal in raw mode, with cursor movement, history navigation
+// and tab completion. Keys follow the usual readline bindings (arrows, Home/End, Ctrl-A/E/K/U/W).
+type lineEditor struct {
+	in       *bufio.Reader
+	out      io.Writer
+	history  []string  // Previous lines, oldest first.
+	complete completer // Completion of the current word; nil disables completion.
+
+	prompt string
+	line   []rune // Line being edited.
+	pos    int    // Cursor position in line.
+}
+
+// newLineEditor creates a line editor reading keys from in and drawing on out.
+func newLineEditor(in io.Reader, out io.Writer, complete completer) *lineEditor {
+	return &lineEditor{in: bufio.NewReader(in), out: out, complete: complete}
+}
+
+// readLine reads one line, showing the prompt. It returns io.EOF when Ctrl-D is pressed on an
+// empty line. Ctrl-C discards the line and returns an empty one.
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	e.prompt, e.line, e.pos = prompt, nil, 0
+	historyIndex := len(e.history) // Position in the history; len(e.history) is the line being edited.
+	draft := ""                    // Line being edited, saved while browsing the history.
+	e.refresh()
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\n")
+			return string(e.line), nil
+		case keyCtrlC:
+			fmt.Fprint(e.out, "^C\n")
+			return "", nil
+		case keyCtrlD:
+			if len(e.line) == 0 {
+				fmt.Fprint(e.out, "\n")
+				return "", io.EOF
+			}
+			e.deleteAt(e.pos)
+		case keyCtrlA:
+			e.pos = 0
+		case keyCtrlE:
+			e.pos = len(e.line)
+		case keyCtrlB:
+			e.pos = max(e.pos-1, 0)
+		case keyCtrlF:
+			e.pos = min(e.pos+1, len(e.line))
+		case keyBackspace, keyDelete:
+			if e.pos > 0 {
+				e.pos--
+				e.deleteAt(e.pos)
+			}
+		case keyCtrlK:
+			e.line = e.line[:e.pos]
+		case keyCtrlU:
+			e.line, e.pos = e.line[e.pos:], 0
+		case keyCtrlW:
+			start := e.pos
+			for start > 0 && e.line[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && e.line[start-1] != ' ' {
+				start--
+			}
+			e.line, e.pos = append(e.line[:start], e.line[e.pos:]...), start
+		case keyCtrlL:
+			fmt.Fprint(e.out, "\033[H\033[2J") // Clear the screen.
+		case keyTab:
+			e.completeWord()
+		case keyCtrlP, keyCtrlN:
+			historyIndex, draft = e.browseHistory(r == keyCtrlP, historyIndex, draft)
+		case keyEscape:
+			switch e.readEscape() {
+			case 'A':
+				historyIndex, draft = e.browseHistory(true, historyIndex, draft)
+			case 'B':
+				historyIndex, draft = e.browseHistory(false, historyIndex, draft)
+			case 'C':
+				e.pos = min(e.pos+1, len(e.line))
+			case 'D':
+				e.pos = max(e.pos-1, 0)
+			case 'H':
+				e.pos = 0
+			case 'F':
+				e.pos = len(e.line)
+			case '~':
+				e.deleteAt(e.pos)
+			}
+		default:
+			if unicode.IsPrint(r) {
+				e.line = append(e.line[:e.pos], append([]rune{r}, e.line[e.pos:]...)...)
+				e.pos++
+			}
+		}
+		e.refresh()
+	}
+}
+
+// readEscape reads the rest of an escape sequence and returns a key code:
+// 'A' to 'D' for the arrows, 'H' and 'F' for Home and End, '~' for Delete, or 0 if unknown.
+func (e *lineEditor) readEscape() rune {
+	r, _, err := e.in.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return 0
+	}
+
+	// Read the parameters of the sequence, e.g. "3~" for Delete.
+	var params strings.Builder
+	for {
+		r, _, err = e.in.ReadRune()
+		if err != nil {
+			return 0
+		}
+		if r < '0' || r > '9' {
+			break
+		}
+		params.WriteRune(r)
+	}
+
+	if r == '~' {
+		switch params.String() {
+		case "1", "7":
+			return 'H'
+		case "4", "8":
+			return 'F'
+		case "3":
+			return '~'
+		}
+		return 0
+	}
+	return r
+}
+
+// deleteAt removes the rune at position i, if any.
+func (e *lineEditor) deleteAt(i int) {
+	if i < len(e.line) {
+		e.line = append(e.line[:i], e.line[i+1:]...)
+	}
+}
+
+// browseHistory replaces the line with the previous or next history entry.
+// The line being edited is kept as the draft, restored after the newest entry.
+func (e *lineEditor) browseHistory(back bool, index int, draft string) (int, string) {
+	if index == len(e.history) {
+		draft = string(e.line)
+	}
+	if back && index > 0 {
+		index--
+	} else if !back && index < len(e.history) {
+		index++
+	} else {
+		return index, draft
+	}
+
+	if index == len(e.history) {
+		e.line = []rune(draft)
+	} else {
+		e.line = []rune(e.history[index])
+	}
+	e.pos = len(e.line)
+	return index, draft
+}
+
+// completeWord completes the word before the cursor. A single candidate is inserted with a trailing
+// space; several candidates are completed to their common prefix, or listed if there is none.
+func (e *lineEditor) completeWord() {
+	if e.complete == nil {
+		return
+	}
+	candidates, start := e.complete(e.line, e.pos)
+	word := string(e.line[start:e.pos])
+
+	var replacement string
+	switch len(candidates) {
+	case 0:
+		fmt.Fprint(e.out, "\a") // Ring the bell.
+		return
+	case 1:
+		replacement = candidates[0] + " "
+	default:
+		replacement = commonPrefix(candidates)
+		if len(replacement) <= len(word) {
+			fmt.Fprintf(e.out, "\n%s\n", strings.Join(candidates, "  "))
+			return
+		}
+	}
+
+	rest := e.line[e.pos:]
+	e.line = append(append(e.line[:start:start], []rune(replacement)...), rest...)
+	e.pos = start + len([]rune(replacement))
+}
+
+// refresh redraws the prompt and the line, then moves the cursor to its position.
+func (e *lineEditor) refresh() {
+	fmt.Fprintf(e.out, "\r\033[K%s%s", e.prompt, string(e.line))
+	if back := len(e.line) - e.pos; back > 0 {
+		fmt.Fprintf(e.out, "\033[%dD", back)
+	}
+}
+
+// commonPrefix returns the longest common prefix of the strings.
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, w := range words[1:] {
+		for !strings.HasPrefix(w, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
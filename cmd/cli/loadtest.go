@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+	"user_api_with_concurrency/client"
+	"user_api_with_concurrency/models"
+)
+
+// loadOps are the operations of a load test, in report order.
+var loadOps = []string{"create", "get", "list", "update", "delete"}
+
+// defaultLoadMix is the default operation mix of a load test, as relative weights.
+const defaultLoadMix = "create=20,get=40,list=10,update=20,delete=10"
+
+// opWeight is the relative weight of an operation in the mix.
+type opWeight struct {
+	op     string
+	weight int
+}
+
+// parseLoadMix parses a comma-separated list of operation weights, e.g. "get=80,list=20".
+// Operations that are not listed are not run.
+func parseLoadMix(spec string) ([]opWeight, error) {
+	var mix []opWeight
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		op, value, _ := strings.Cut(part, "=")
+		op = strings.ToLower(strings.TrimSpace(op))
+		if !slices.Contains(loadOps, op) {
+			return nil, fmt.Errorf("unknown operation %q (expected one of %s)", op, strings.Join(loadOps, ", "))
+		}
+		weight, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight of %s: %q", op, value)
+		}
+		if weight > 0 {
+			mix = append(mix, opWeight{op, weight})
+		}
+	}
+	if len(mix) == 0 {
+		return nil, fmt.Errorf("the mix must give a positive weight to at least one operation")
+	}
+	return mix, nil
+}
+
+// loadStats summarizes the requests of an operation. Latencies are in milliseconds.
+type loadStats struct {
+	Operation  string  `json:"operation"`
+	Requests   int     `json:"requests"`
+	Errors     int     `json:"errors"`
+	ErrorRate  float64 `json:"error_rate"` // Fraction of the requests that failed.
+	Throughput float64 `json:"throughput"` // Requests per second.
+	P50        float64 `json:"p50_ms"`
+	P95        float64 `json:"p95_ms"`
+	P99        float64 `json:"p99_ms"`
+	Max        float64 `json:"max_ms"`
+}
+
+// loadReport is the result of a load test.
+type loadReport struct {
+	Server      string         `json:"server"`
+	Duration    float64        `json:"duration_s"` // Time from the first request to the end of the last one.
+	Concurrency int            `json:"concurrency"`
+	TargetRPS   float64        `json:"target_rps,omitempty"`
+	Operations  []loadStats    `json:"operations"`
+	Total       loadStats      `json:"total"`
+	ErrorKinds  map[string]int `json:"error_kinds,omitempty"` // Failed requests by HTTP status or "timeout"/"network".
+}
+
+// loadTest drives requests against a server and records their outcome.
+type loadTest struct {
+	client  *client.Client
+	mix     []opWeight
+	total   int           // Sum of the weights of the mix.
+	timeout time.Duration // Timeout of every request.
+	runID   int64         // Makes the emails of the created users unique across runs.
+	created atomic.Int64  // Number of users created, to number them.
+
+	idsMu sync.Mutex
+	ids   []int // IDs of the users created by the test that were not deleted yet.
+
+	mu        sync.Mutex
+	latencies map[string][]time.Duration // Latencies of the requests by operation.
+	errors    map[string]int             // Number of failed requests by operation.
+	kinds     map[string]int             // Number of failed requests by kind.
+}
+
+// newLoadTest creates a load test of the server with the given operation mix.
+func newLoadTest(c *client.Client, mix []opWeight, timeout time.Duration) *loadTest {
+	lt := &loadTest{
+		client: c, mix: mix, timeout: timeout, runID: time.Now().UnixNano(),
+		latencies: make(map[string][]time.Duration),
+		errors:    make(map[string]int),
+		kinds:     make(map[string]int),
+	}
+	for _, w := range mix {
+		lt.total += w.weight
+	}
+	return lt
+}
+
+// run sends requests from concurrency workers until the duration elapses, ctx is done or
+// maxRequests requests were sent (0 for no limit). With a positive rps, requests are paced
+// to that rate overall; otherwise every worker sends its next request as soon as the previous ends.
+// Requests still in flight when the duration elapses are completed and counted.
+func (lt *loadTest) run(ctx context.Context, concurrency int, rps float64, duration time.Duration, maxRequests int, seed uint64) time.Duration {
+	runCtx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	// Pace the requests with a ticker shared by the workers; ticks are dropped while all are busy.
+	var ticks <-chan time.Time
+	if rps > 0 {
+		// Rates above one request per nanosecond would round the interval to 0, which NewTicker rejects.
+		ticker := time.NewTicker(max(time.Duration(float64(time.Second)/rps), time.Nanosecond))
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	var issued atomic.Int64
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(rng *rand.Rand) {
+			defer wg.Done()
+			for {
+				if ticks != nil {
+					select {
+					case <-runCtx.Done():
+						return
+					case <-ticks:
+					}
+				} else if runCtx.Err() != nil {
+					return
+				}
+				if maxRequests > 0 && issued.Add(1) > int64(maxRequests) {
+					return
+				}
+				lt.step(ctx, rng)
+			}
+		}(rand.New(rand.NewPCG(seed, uint64(i))))
+	}
+	wg.Wait()
+	return time.Since(start)
+}
+
+// step sends one request of an operation picked from the mix and records it.
+// Operations on a user run a create instead while the test has no user of its own.
+func (lt *loadTest) step(ctx context.Context, rng *rand.Rand) {
+	op := lt.pick(rng)
+	id, ok := 0, true
+	switch op {
+	case "get", "update":
+		id, ok = lt.randomID(rng, false)
+	case "delete":
+		id, ok = lt.randomID(rng, true) // Removed first, so no other worker updates a deleted user.
+	}
+	if !ok {
+		op = "create"
+	}
+
+	reqCtx, cancel := context.WithTimeout(ctx, lt.timeout)
+	defer cancel()
+
+	start := time.Now()
+	var err error
+	switch op {
+	case "create":
+		var user models.User
+		if user, err = lt.client.CreateUser(reqCtx, lt.newUser(rng)); err == nil {
+			lt.idsMu.Lock()
+			lt.ids = append(lt.ids, user.ID)
+			lt.idsMu.Unlock()
+		}
+	case "get":
+		_, err = lt.client.GetUser(reqCtx, id)
+	case "list":
+		_, err = lt.client.ListUsers(reqCtx)
+	case "update":
+		user := lt.newUser(rng)
+		user.Name = "Load Test " + strconv.Itoa(id)
+		_, err = lt.client.UpdateUser(reqCtx, id, user)
+	case "delete":
+		err = lt.client.DeleteUser(reqCtx, id)
+	}
+	latency := time.Since(start)
+
+	if errors.Is(err, context.Canceled) && ctx.Err() != nil {
+		return // Interrupted; the request did not complete.
+	}
+	lt.record(op, latency, err)
+}
+
+// pick returns an operation of the mix at random, according to the weights.
+func (lt *loadTest) pick(rng *rand.Rand) string {
+	n := rng.IntN(lt.total)
+	for _, w := range lt.mix {
+		if n < w.weight {
+			return w.op
+		}
+		n -= w.weight
+	}
+	return lt.mix[len(lt.mix)-1].op
+}
+
+// randomID returns the ID of a random user created by the test, removing it if remove is set.
+// It returns false if there is no such user.
+func (lt *loadTest) randomID(rng *rand.Rand, remove bool) (int, bool) {
+	lt.idsMu.Lock()
+	defer lt.idsMu.Unlock()
+
+	if len(lt.ids) == 0 {
+		return 0, false
+	}
+	i := rng.IntN(len(lt.ids))
+	id := lt.ids[i]
+	if remove {
+		lt.ids[i] = lt.ids[len(lt.ids)-1]
+		lt.ids = lt.ids[:len(lt.ids)-1]
+	}
+	return id, true
+}
+
+// newUser returns a valid user with random data.
+func (lt *loadTest) newUser(rng *rand.Rand) models.User {
+	n := lt.created.Add(1)
+	return models.User{
+		Name:  "Load Test " + strconv.FormatInt(n, 10),
+		Age:   18 + rng.IntN(63),
+		Email: fmt.Sprintf("loadtest-%d-%d@example.com", lt.runID, n),
+	}
+}
+
+// record adds the outcome of a request to the statistics.
+func (lt *loadTest) record(op string, latency time.Duration, err error) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	lt.latencies[op] = append(lt.latencies[op], latency)
+	if err == nil {
+		return
+	}
+	lt.errors[op]++
+
+	var apiErr *client.APIError
+	switch {
+	case errors.As(err, &apiErr):
+		lt.kinds[strconv.Itoa(apiErr.StatusCode)]++
+	case errors.Is(err, context.DeadlineExceeded):
+		lt.kinds["timeout"]++
+	default:
+		lt.kinds["network"]++
+	}
+}
+
+// cleanup deletes the users created by the test that still exist.
+func (lt *loadTest) cleanup(ctx context.Context) (int, error) {
+	deleted := 0
+	for _, id := range lt.ids {
+		reqCtx, cancel := context.WithTimeout(ctx, lt.timeout)
+		err := lt.client.DeleteUser(reqCtx, id)
+		cancel()
+		if err != nil && !errors.Is(err, client.ErrNotFound) {
+			return deleted, err
+		}
+		deleted++
+	}
+	lt.ids = nil
+	return deleted, nil
+}
+
+// report summarizes the recorded requests of a run that lasted elapsed.
+func (lt *loadTest) report(elapsed time.Duration) loadReport {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	r := loadReport{Duration: elapsed.Seconds(), ErrorKinds: lt.kinds}
+	var all []time.Duration
+	totalErrors := 0
+	for _, op := range loadOps {
+		latencies := lt.latencies[op]
+		if len(latencies) == 0 {
+			continue
+		}
+		r.Operations = append(r.Operations, newLoadStats(op, latencies, lt.errors[op], elapsed))
+		all = append(all, latencies...)
+		totalErrors += lt.errors[op]
+	}
+	r.Total = newLoadStats("total", all, totalErrors, elapsed)
+	if len(r.ErrorKinds) == 0 {
+		r.ErrorKinds = nil
+	}
+	return r
+}
+
+// newLoadStats computes the statistics of an operation from its latencies.
+func newLoadStats(op string, latencies []time.Duration, failed int, elapsed time.Duration) loadStats {
+	s := loadStats{Operation: op, Requests: len(latencies), Errors: failed}
+	if len(latencies) == 0 {
+		return s
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	s.ErrorRate = float64(failed) / float64(len(latencies))
+	if elapsed > 0 {
+		s.Throughput = float64(len(latencies)) / elapsed.Seconds()
+	}
+	s.P50 = milliseconds(percentile(latencies, 50))
+	s.P95 = milliseconds(percentile(latencies, 95))
+	s.P99 = milliseconds(percentile(latencies, 99))
+	s.Max = milliseconds(latencies[len(latencies)-1])
+	return s
+}
+
+// percentile returns the p-th percentile of sorted latencies, using the nearest-rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+// milliseconds converts a duration to milliseconds, rounded to microseconds.
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// printText prints the report as a table with one row per operation and a total row.
+func (r loadReport) printText(w io.Writer) {
+	target := "unlimited"
+	if r.TargetRPS > 0 {
+		target = strconv.FormatFloat(r.TargetRPS, 'f', -1, 64) + " req/s"
+	}
+	fmt.Fprintf(w, "Load test of %s: %.1fs, concurrency %d, target rate %s\n\n", r.Server, r.Duration, r.Concurrency, target)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "OPERATION\tREQUESTS\tERRORS\tERROR %\tREQ/S\tP50 MS\tP95 MS\tP99 MS\tMAX MS\t")
+	for _, s := range append(r.Operations, r.Total) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.2f\t%.1f\t%.2f\t%.2f\t%.2f\t%.2f\t\n",
+			s.Operation, s.Requests, s.Errors, 100*s.ErrorRate, s.Throughput, s.P50, s.P95, s.P99, s.Max)
+	}
+	tw.Flush()
+
+	if len(r.ErrorKinds) > 0 {
+		kinds := make([]string, 0, len(r.ErrorKinds))
+		for kind, n := range r.ErrorKinds {
+			kinds = append(kinds, fmt.Sprintf("%s: %d", kind, n))
+		}
+		sort.Strings(kinds)
+		fmt.Fprintf(w, "\nErrors by kind: %s\n", strings.Join(kinds, ", "))
+	}
+}
+
+// setupLoadTest defines the flags of the "loadtest" command.
+// Its action prints the report in the output format and optionally saves it as JSON.
+func setupLoadTest(fs *flag.FlagSet, defaults outputOptions) func() error {
+	server := fs.String("server", current.Server, "Base URL of the API server")
+	duration := fs.Duration("duration", 10*time.Second, "Duration of the test")
+	requests := fs.Int("requests", 0, "Stop after this many requests (0 for no limit)")
+	concurrency := fs.Int("concurrency", current.Concurrency, "Number of concurrent workers")
+	rps := fs.Float64("rps", 0, "Target rate in requests per second over all workers (0 for as fast as possible)")
+	mixSpec := fs.String("mix", defaultLoadMix, "Relative weights of the operations: create, get, list, update, delete")
+	timeout := fs.Duration("timeout", current.Timeout, "Timeout of every request")
+	seed := fs.Uint64("seed", 0, "Seed of the random operation mix (0 for a random seed)")
+	cleanup := fs.Bool("cleanup", true, "Delete the users created by the test when it ends")
+	reportFile := fs.String("report", "", "Also write the report as JSON to this file")
+	out := addOutputFlags(fs, defaults)
+
+	return func() error {
+		mix, err := parseLoadMix(*mixSpec)
+		if err != nil {
+			return err
+		}
+		if *concurrency <= 0 {
+			return fmt.Errorf("concurrency must be greater than 0")
+		}
+		if *duration <= 0 || *rps < 0 || *requests < 0 {
+			return fmt.Errorf("duration must be positive, and rps and requests must not be negative")
+		}
+		if err := out.validate(); err != nil {
+			return err
+		}
+		if *seed == 0 {
+			*seed = rand.Uint64()
+		}
+
+		// Stop early on Ctrl-C, still reporting the requests sent so far.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		lt := newLoadTest(newClient(*server), mix, *timeout)
+		limit := duration.String()
+		if *requests > 0 {
+			limit += fmt.Sprintf(" or %d requests", *requests)
+		}
+		fmt.Fprintf(os.Stderr, "Running load test against %s for %s...\n", *server, limit)
+		elapsed := lt.run(ctx, *concurrency, *rps, *duration, *requests, *seed)
+
+		report := lt.report(elapsed)
+		report.Server, report.Concurrency, report.TargetRPS = *server, *concurrency, *rps
+
+		if *cleanup {
+			if n, err := lt.cleanup(context.Background()); err != nil {
+				fmt.Fprintf(os.Stderr, "Cleanup failed after deleting %d users: %v\n", n, err)
+			}
+		}
+
+		if *reportFile != "" {
+			data, err := json.MarshalIndent(report, "", "  ")
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(*reportFile, append(data, '\n'), 0644); err != nil {
+				return err
+			}
+		}
+		return out.printValue(os.Stdout, report, report.printText)
+	}
+}
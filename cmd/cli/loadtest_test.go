@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"user_api_with_concurrency/client"
+)
+
+// TestParseLoadMix tests the parsing of operation weights.
+func TestParseLoadMix(t *testing.T) {
+	mix, err := parseLoadMix("get=3, list=1,delete=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mix) != 2 || mix[0] != (opWeight{"get", 3}) || mix[1] != (opWeight{"list", 1}) {
+		t.Errorf("Unexpected mix %+v", mix)
+	}
+
+	for _, spec := range []string{"", "get=0", "rename=1", "get=-1", "get"} {
+		if _, err := parseLoadMix(spec); err == nil {
+			t.Errorf("Expected mix %q to fail", spec)
+		}
+	}
+}
+
+// TestPercentile tests the nearest-rank percentiles.
+func TestPercentile(t *testing.T) {
+	latencies := make([]time.Duration, 100)
+	for i := range latencies {
+		latencies[i] = time.Duration(i+1) * time.Millisecond
+	}
+	for p, want := range map[float64]time.Duration{50: 50 * time.Millisecond, 99: 99 * time.Millisecond, 100: 100 * time.Millisecond} {
+		if got := percentile(latencies, p); got != want {
+			t.Errorf("p%v: expected %s, got %s", p, want, got)
+		}
+	}
+	if got := percentile(latencies[:1], 50); got != time.Millisecond {
+		t.Errorf("Expected the only latency, got %s", got)
+	}
+}
+
+// TestLoadTest runs a short load test against a stub server and checks the report.
+func TestLoadTest(t *testing.T) {
+	var mu sync.Mutex
+	nextID, deleted := 0, 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == http.MethodPost:
+			nextID++
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"id":%d}`, nextID)
+		case r.Method == http.MethodDelete:
+			deleted++
+			w.WriteHeader(http.StatusNoContent)
+		case r.URL.Path == "/users":
+			fmt.Fprint(w, `[]`)
+		default:
+			fmt.Fprint(w, `{"id":1}`)
+		}
+	}))
+	defer ts.Close()
+
+	mix, _ := parseLoadMix(defaultLoadMix)
+	lt := newLoadTest(client.New(ts.URL), mix, 5*time.Second)
+	elapsed := lt.run(context.Background(), 4, 0, time.Minute, 200, 1)
+
+	report := lt.report(elapsed)
+	if report.Total.Requests != 200 || report.Total.Errors != 0 {
+		t.Errorf("Expected 200 successful requests, got %+v (errors: %v)", report.Total, report.ErrorKinds)
+	}
+	sum := 0
+	for _, s := range report.Operations {
+		sum += s.Requests
+		if s.P50 > s.P95 || s.P95 > s.P99 || s.P99 > s.Max {
+			t.Errorf("Percentiles of %s are not ordered: %+v", s.Operation, s)
+		}
+	}
+	if sum != 200 || report.Total.Throughput <= 0 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+
+	var out strings.Builder
+	report.printText(&out)
+	if !strings.Contains(out.String(), "P99 MS") || !strings.Contains(out.String(), "total") {
+		t.Errorf("Unexpected table:\n%s", out.String())
+	}
+
+	// The users created by the test and not deleted during the run are deleted by the cleanup.
+	if _, err := lt.cleanup(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if deleted != nextID {
+		t.Errorf("Expected all %d created users to be deleted, got %d", nextID, deleted)
+	}
+
+	// A rate too high to pace is sent as fast as possible.
+	lt = newLoadTest(client.New(ts.URL), mix, 5*time.Second)
+	if report := lt.report(lt.run(context.Background(), 2, 1e12, time.Minute, 20, 2)); report.Total.Requests != 20 {
+		t.Errorf("Expected 20 requests at an unbounded rate, got %+v", report.Total)
+	}
+	lt.cleanup(context.Background())
+}
@@ -1,68 +1,331 @@
 package main
 
 import (
+	"compress/gzip"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"user_api_with_concurrency/models"
 	"user_api_with_concurrency/services"
+	"user_api_with_concurrency/utils"
 )
 
+// rootCommand returns the command tree of the CLI. Commands are listed in help in this order.
+// The tree is built for every use, so the flag defaults follow the current settings.
+func rootCommand() *command {
+	return &command{
+		name:    "cli",
+		usage:   "[global options] <command> [options]",
+		details: globalHelp,
+		subcommands: []*command{
+			{name: "fetch-additional-info", summary: "Fetch additional information for users",
+				usage: "(-id <user_id> | -ids <list> | -from-file <file>) [-concurrency <n>] [-timeout <duration>] [-out <file>] [-export <file>] [-format <format>]",
+				setup: setupFetch},
+			{name: "import", summary: "Import users from a CSV, TSV or JSON Lines file",
+				usage: "-file <file> [-format <format>] [-dry-run]", setup: setupImport},
+			{name: "verify-export", summary: "Verify an exported file against its manifest",
+				usage: "-file <file> [-manifest <manifest>]", setup: setupVerifyExport},
+			usersCommand(),
+			{name: "watch", summary: "Print user changes as they happen",
+				usage: "[-server <url>] [-id <list>] [-type <list>] [-since <event_id>]", setup: setupWatch},
+			{name: "seed", summary: "Generate realistic test users into the API or a file",
+				usage: "[-n <count>] [-seed <seed>] [-out <file>] [-format <format>] [-dry-run]", setup: setupSeed},
+			{name: "backup", summary: "Save a point-in-time snapshot of the server's users to an archive",
+				usage: "[-server <url>] [-out <file>]", setup: setupBackup},
+			{name: "restore", summary: "Load a backup archive into the server (merge or replace)",
+				usage: "-file <file> [-server <url>] [-mode merge|replace] [-dry-run]", setup: setupRestore},
+			{name: "loadtest", summary: "Send a mix of requests to the server and report latency percentiles",
+				usage: "[-server <url>] [-duration <d>] [-requests <n>] [-concurrency <n>] [-rps <n>] [-mix <weights>] [-report <file>]",
+				setup: setupLoadTest},
+			{name: "shell", summary: "Start an interactive shell running these commands",
+				usage: "[-server <url>] [-history <file>]", setup: setupShell},
+			configCommand(),
+			{name: "completion", summary: "Print the shell completion script of bash, zsh or fish",
+				usage: "[-name <program>] <bash|zsh|fish>", details: completionHelp, setup: setupCompletion,
+				args: func(previous []string) []string {
+					if len(previous) == 0 {
+						return completionShells
+					}
+					return nil
+				}},
+			{name: "__complete", hidden: true, usage: "-- <words>... <word>", setup: setupComplete},
+		},
+	}
+}
+
+// globalHelp describes the global options in the help of the CLI.
+const globalHelp = `Global options:
+  -profile      Configuration profile (default "default")
+  -server       Base URL of the API server
+  -token        Bearer token sent to the API server
+
+Output options (global, and accepted by every command):
+  -output, -o   Output format: table, json, jsonl, yaml, csv or template
+  -template     Go template for -output template
+  -columns      Comma-separated columns to print
+  -no-headers   Do not print header rows
+`
+
+// completionHelp explains how to install the completion scripts.
+const completionHelp = `Load the completions in the current shell with:
+  bash:  source <(cli completion bash)
+  zsh:   source <(cli completion zsh)
+  fish:  cli completion fish | source
+Add the line to ~/.bashrc, ~/.zshrc or ~/.config/fish/config.fish to load them in every shell.
+`
+
 // printUsage displays the usage instructions for the CLI.
 // It provides information about available commands and how to use them.
 func printUsage() {
-	fmt.Println("Usage: cli <command> [options]")
-	fmt.Println("Commands:")
-	fmt.Println("  fetch-additional-info  Fetch additional information for a user")
-	fmt.Println()
-	fmt.Println("Use './cli <command> --help' for more information on a specific command.")
+	printHelp(os.Stdout, []*command{rootCommand()}, nil)
 }
 
+// errFailed is returned by commands that already reported their failure, e.g. rejected import rows.
+// The CLI exits with status 1 without printing it.
+var errFailed = errors.New("command failed")
+
 // main is the entry point of the CLI application.
 // It parses command-line arguments and executes the appropriate command.
 func main() {
+	// Parse the global flags given before the command; they become the defaults of the command's flags.
+	globalFlags, opts := newGlobalFlags(flag.ExitOnError)
+	globalFlags.Parse(os.Args[1:])
+	args := globalFlags.Args()
+
+	// Resolve the settings: flags take precedence over the environment, which overrides the profile.
+	// The config command may create the profile, so it need not exist yet.
+	if err := loadSettings(*opts.profile, len(args) > 0 && args[0] == "config"); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	globalFlags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "server":
+			if err := current.set("server", *opts.server); err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+		case "token":
+			current.Token = *opts.token
+		}
+	})
+	if opts.output.Format == "" {
+		opts.output.Format = current.Output
+	}
+	services.Configure(current.Server, current.Token) // Used by fetch-additional-info and import.
+
 	// Check if at least one command is provided.
-	if len(os.Args) < 2 {
+	if len(args) < 1 {
 		printUsage() // Display usage instructions if no command is provided.
 		return
 	}
 
-	// Switch statement to handle different commands.
-	switch os.Args[1] {
-	case "fetch-additional-info":
-		// Create a new flag set for the "fetch-additional-info" command.
-		fetchCmd := flag.NewFlagSet("fetch-additional-info", flag.ExitOnError)
-		// Define a flag for the user ID.
-		userID := fetchCmd.Int("id", 0, "User ID to fetch information for")
-		// Customize the usage message for this command.
-		fetchCmd.Usage = func() {
-			fmt.Println("Usage: cli fetch-additional-info -id <user_id>")
-			fmt.Println("Options:")
-			fetchCmd.PrintDefaults()
+	// Run the command and exit with status 1 if it failed.
+	if err := dispatch(args, *opts.output); err != nil {
+		if !errors.Is(err, errFailed) {
+			fmt.Println("Error:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// globalOptions are the values of the global flags.
+type globalOptions struct {
+	profile, server, token *string
+	output                 *outputOptions
+}
+
+// newGlobalFlags returns the flag set of the global flags given before the command.
+func newGlobalFlags(errorHandling flag.ErrorHandling) (*flag.FlagSet, globalOptions) {
+	fs := flag.NewFlagSet("cli", errorHandling)
+	fs.Usage = printUsage
+	opts := globalOptions{
+		profile: fs.String("profile", envOr("USERCLI_PROFILE", "default"), "Configuration profile (env USERCLI_PROFILE)"),
+		server:  fs.String("server", "", "Base URL of the API server"),
+		token:   fs.String("token", "", "Bearer token sent to the API server"),
+		output:  addOutputFlags(fs, outputOptions{}),
+	}
+	return fs, opts
+}
+
+// loadSettings resolves the settings of a profile from the configuration file and the environment.
+// With allowNew, a profile missing from the file is treated as empty.
+func loadSettings(profile string, allowNew bool) error {
+	cfg, err := loadConfig(defaultConfigPath())
+	if err != nil {
+		return err
+	}
+	if allowNew {
+		cfg.profile(profile)
+	}
+	current, err = resolveSettings(cfg, profile)
+	return err
+}
+
+// envOr returns the value of an environment variable, or fallback if it is empty.
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// parseFlags parses the arguments of a command. It reports done if the command must not run,
+// either because its usage was requested with -help or because the arguments are invalid.
+// Flag sets use flag.ContinueOnError, so a typo never ends an interactive shell.
+func parseFlags(fs *flag.FlagSet, args []string) (done bool, err error) {
+	err = fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return true, nil // The usage was printed.
+	}
+	if err != nil {
+		return true, errFailed // The flag package already printed the error and the usage.
+	}
+	return false, nil
+}
+
+// dispatch runs a command with its arguments. It is used both by main and by the interactive shell,
+// so commands report failures as errors instead of exiting.
+func dispatch(args []string, global outputOptions) error {
+	return rootCommand().execute(args, global)
+}
+
+// setupImport defines the flags of the "import" command: it uploads a file to the import endpoint.
+func setupImport(fs *flag.FlagSet, global outputOptions) func() error {
+	// Define flags for the file, its format and the dry-run mode.
+	file := fs.String("file", "", "File to import (use - for stdin)")
+	format := fs.String("format", "", "File format (csv, tsv or jsonl); defaults to the file extension")
+	dryRun := fs.Bool("dry-run", false, "Validate the rows without importing them")
+	out := addOutputFlags(fs, global)
+
+	return func() error {
+		if err := out.validate(); err != nil {
+			return err
+		}
+
+		// Validate that the file is provided.
+		if *file == "" {
+			return fmt.Errorf("file must be specified using -file")
+		}
+
+		// Import the file and print the row-level report.
+		failed, err := importUsers(*file, *format, *dryRun, out)
+		if err != nil {
+			return err
+		}
+		if failed {
+			return errFailed // Signal rejected rows to scripts.
+		}
+		return nil
+	}
+}
+
+// setupVerifyExport defines the flags of the "verify-export" command: it checks an exported file against its manifest.
+func setupVerifyExport(fs *flag.FlagSet, global outputOptions) func() error {
+	// Define flags for the exported file and its manifest.
+	file := fs.String("file", "", "Exported file to verify")
+	manifest := fs.String("manifest", "", "Manifest to verify against (default <file>.manifest.json)")
+	out := addOutputFlags(fs, global)
+
+	return func() error {
+		if err := out.validate(); err != nil {
+			return err
+		}
+
+		// Validate that the file is provided.
+		if *file == "" {
+			return fmt.Errorf("file must be specified using -file")
+		}
+
+		// Verify the file and print the outcome.
+		m, err := utils.VerifyExport(*file, *manifest)
+		if err != nil {
+			fmt.Printf("FAILED: %s\n", *file)
+			fmt.Println(err)
+			return errFailed
 		}
+		return out.printValue(os.Stdout, m, func(w io.Writer) {
+			fmt.Fprintf(w, "OK: %s (%d rows, %d filtered out, sha256 %s)\n", *file, m.Rows, m.FilteredRows, m.SHA256)
+		})
+	}
+}
 
-		// Display help if the "--help" flag is provided.
-		if len(os.Args) > 2 && os.Args[2] == "--help" {
-			fetchCmd.Usage()
-			return
+// exportUsers writes the users to a file in the given format, along with its manifest.
+// If format is empty, it is derived from the file extension.
+func exportUsers(users []models.User, filename, format string) error {
+	opts := utils.ExportOptions{Manifest: true}
+	if format != "" {
+		f, err := utils.ParseFormat(format)
+		if err != nil {
+			return err
 		}
+		opts.Format = f
+	}
 
-		// Parse the command-line arguments for this command.
-		fetchCmd.Parse(os.Args[2:])
+	stats, err := utils.ExportUsersToFile(utils.StreamUsers(users), filename, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Exported %d users to %s\n", stats.Rows, filename)
+	return nil
+}
 
-		// Validate that the user ID is provided.
-		if *userID == 0 {
-			fmt.Println("Error: User ID must be specified using -id.")
-			return
+// importUsers uploads a file to the API's import endpoint and prints the report.
+// It reports whether any row was rejected.
+func importUsers(filename, format string, dryRun bool, out *outputOptions) (bool, error) {
+	var in io.Reader = os.Stdin
+	if filename != "-" {
+		file, err := os.Open(filename)
+		if err != nil {
+			return false, err
 		}
+		defer file.Close()
+		in = file
+	}
 
-		// Fetch additional information for the specified user ID.
-		users := services.FetchAllUsersInfo([]int{*userID})
-		// Print the fetched user information.
-		fmt.Println(users)
+	// Derive the format from the file extension if it is not given.
+	if format == "" && filename == "-" {
+		return false, fmt.Errorf("-format is required when reading from stdin")
+	}
+	if filename != "-" {
+		f, compress, err := utils.FormatFromFilename(filename)
+		if format == "" {
+			if err != nil {
+				return false, err
+			}
+			format = string(f)
+		}
+		if compress {
+			// Decompress files exported with a ".gz" extension, e.g. "users.csv.gz".
+			gz, err := gzip.NewReader(in)
+			if err != nil {
+				return false, fmt.Errorf("invalid gzip file %s: %w", filename, err)
+			}
+			defer gz.Close()
+			in = gz
+		}
+	}
 
-	default:
-		// Handle invalid commands.
-		fmt.Println("Error: Invalid command.")
-		printUsage() // Display usage instructions for invalid commands.
+	report, err := services.ImportUsers(in, format, dryRun)
+	if err != nil {
+		return false, err
 	}
+
+	return report.Failed > 0, printImportReport(out, report)
+}
+
+// printImportReport prints the summary of an import followed by one line per rejected row.
+func printImportReport(out *outputOptions, report models.ImportReport) error {
+	return out.printValue(os.Stdout, report, func(w io.Writer) {
+		verb := "Imported"
+		if report.DryRun {
+			verb = "Dry run: would import"
+		}
+		fmt.Fprintf(w, "%s %d of %d rows (%d failed)\n", verb, report.Imported, report.Total, report.Failed)
+		for _, e := range report.Errors {
+			fmt.Fprintf(w, "  row %d: %s\n", e.Row, e.Error)
+		}
+	})
 }
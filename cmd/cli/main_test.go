@@ -1,16 +1,34 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"testing"
+	"user_api_with_concurrency/api"
+	"user_api_with_concurrency/models"
+	"user_api_with_concurrency/services"
+	"user_api_with_concurrency/utils"
 )
 
 // TestCLI tests the command-line interface (CLI) of the application.
 // It runs the main program with specific arguments and checks if the command executes successfully.
 func TestCLI(t *testing.T) {
+	// Simulate the external API, so the fetched user exists.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var id int
+		fmt.Sscanf(r.URL.Path, "/users/%d", &id)
+		json.NewEncoder(w).Encode(models.User{ID: id, Name: "Frodo Baggins", Age: 50})
+	}))
+	defer ts.Close()
+
 	// Create a command to run the main program with the "fetch-additional-info" subcommand and an ID flag.
-	cmd := exec.Command("go", "run", "main.go", "fetch-additional-info", "-id=1")
+	cmd := exec.Command("go", "run", ".", "fetch-additional-info", "-id=1")
+	cmd.Env = append(os.Environ(), "EXTERNAL_API_URL="+ts.URL)
 
 	// Redirect the command's standard output and error to the test's standard output and error.
 	// This allows the test to display the output of the command in real-time.
@@ -23,3 +41,24 @@ func TestCLI(t *testing.T) {
 		t.Errorf("CLI command failed: %v", err)
 	}
 }
+
+// TestImportUsers_Gzip tests that the import command decompresses a gzip-compressed export.
+func TestImportUsers_Gzip(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /users/import", api.ImportUsers)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	services.Configure(ts.URL, "")
+	defer services.Configure("http://localhost:3000", "")
+
+	exported := []models.User{{ID: 11, Name: "Frodo Baggins", Age: 50, Email: "frodo@tolkien.com"}}
+	path := filepath.Join(t.TempDir(), "users.jsonl.gz")
+	if _, err := utils.ExportUsersToFile(utils.StreamUsers(exported), path, utils.ExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	failed, err := importUsers(path, "", true, &outputOptions{})
+	if err != nil || failed {
+		t.Errorf("Expected the export to be imported, got failed=%v (%v)", failed, err)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"user_api_with_concurrency/models"
+	"user_api_with_concurrency/utils"
+)
+
+// Output formats selected with -output.
+var outputFormats = []string{"table", "json", "jsonl", "yaml", "csv", "template"}
+
+// outputOptions controls how commands print their results.
+type outputOptions struct {
+	Format    string // One of outputFormats.
+	Template  string // Go text/template executed for every user (or once for other results).
+	Columns   string // Comma-separated columns of the user output; defaults to utils.ExportColumns.
+	NoHeaders bool   // Omit the header row of table and CSV output.
+}
+
+// addOutputFlags defines the output flags on a flag set, using defaults for their initial values.
+// The defaults come from the global flags given before the command.
+func addOutputFlags(fs *flag.FlagSet, defaults outputOptions) *outputOptions {
+	o := &outputOptions{}
+	usage := "Output format: " + strings.Join(outputFormats, ", ")
+	fs.StringVar(&o.Format, "output", defaults.Format, usage)
+	fs.StringVar(&o.Format, "o", defaults.Format, "Shorthand for -output")
+	fs.StringVar(&o.Template, "template", defaults.Template, "Go template for -output template, e.g. '{{.ID}} {{.Email}}'")
+	fs.StringVar(&o.Columns, "columns", defaults.Columns, "Comma-separated columns to print (id, name, age, email, locale)")
+	fs.BoolVar(&o.NoHeaders, "no-headers", defaults.NoHeaders, "Do not print the header row of table and csv output")
+	return o
+}
+
+// validate checks the options, selecting the template format when only a template is given.
+func (o *outputOptions) validate() error {
+	if o.Template != "" && (o.Format == "" || o.Format == "table") {
+		o.Format = "template"
+	}
+	if o.Format == "" {
+		o.Format = "table"
+	}
+
+	switch o.Format {
+	case "table", "json", "jsonl", "yaml", "csv":
+	case "template":
+		if o.Template == "" {
+			return fmt.Errorf("-output template requires -template")
+		}
+	default:
+		return fmt.Errorf("unknown output format %q (expected one of %s)", o.Format, strings.Join(outputFormats, ", "))
+	}
+	_, err := o.columns()
+	return err
+}
+
+// columns returns the selected columns.
+func (o *outputOptions) columns() ([]string, error) {
+	var columns []string
+	if o.Columns != "" {
+		columns = strings.Split(o.Columns, ",")
+	}
+	return utils.ParseColumns(columns)
+}
+
+// printUser writes a single user in the selected format; JSON and YAML output an object instead of a list.
+func (o *outputOptions) printUser(w io.Writer, user models.User) error {
+	return o.print(w, []models.User{user}, true)
+}
+
+// printUsers writes users in the selected format.
+func (o *outputOptions) printUsers(w io.Writer, users []models.User) error {
+	return o.print(w, users, false)
+}
+
+// print writes users in the selected format, as a single object if single is set.
+func (o *outputOptions) print(w io.Writer, users []models.User, single bool) error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+	columns, _ := o.columns()
+
+	records := make([]utils.Record, len(users))
+	for i, u := range users {
+		records[i] = utils.NewRecord(u, columns)
+	}
+
+	switch o.Format {
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		if !o.NoHeaders {
+			fmt.Fprintln(tw, strings.ToUpper(strings.Join(columns, "\t")))
+		}
+		for _, r := range records {
+			fmt.Fprintln(tw, strings.Join(r.Strings(), "\t"))
+		}
+		return tw.Flush()
+
+	case "csv":
+		cw := csv.NewWriter(w)
+		if !o.NoHeaders {
+			cw.Write(columns)
+		}
+		for _, r := range records {
+			cw.Write(r.Strings())
+		}
+		cw.Flush()
+		return cw.Error()
+
+	case "template":
+		tmpl, err := o.parseTemplate()
+		if err != nil {
+			return err
+		}
+		for _, u := range users {
+			if err := tmpl.Execute(w, u); err != nil {
+				return err
+			}
+			fmt.Fprintln(w)
+		}
+		return nil
+
+	case "yaml":
+		if len(records) == 0 {
+			_, err := fmt.Fprintln(w, "[]")
+			return err
+		}
+		for _, r := range records {
+			for i, f := range r {
+				prefix := "  "
+				if single {
+					prefix = ""
+				} else if i == 0 {
+					prefix = "- "
+				}
+				fmt.Fprintf(w, "%s%s: %s\n", prefix, f.Name, yamlScalar(f.Value))
+			}
+		}
+		return nil
+	}
+	if single {
+		return o.printJSON(w, records[0])
+	}
+	return o.printJSON(w, records)
+}
+
+// printValue writes a result that is not a list of users, such as an import report.
+// The table format prints it with text; csv is not supported.
+func (o *outputOptions) printValue(w io.Writer, v any, text func(io.Writer)) error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+
+	switch o.Format {
+	case "table":
+		text(w)
+		return nil
+	case "csv", "yaml":
+		return fmt.Errorf("-output %s is only supported for users", o.Format)
+	case "template":
+		tmpl, err := o.parseTemplate()
+		if err != nil {
+			return err
+		}
+		if err := tmpl.Execute(w, v); err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w)
+		return err
+	}
+	return o.printJSON(w, v)
+}
+
+// printJSON writes v as indented JSON, or as one compact line per element in jsonl format.
+func (o *outputOptions) printJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	if o.Format == "json" {
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	if records, ok := v.([]utils.Record); ok {
+		for _, r := range records {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return enc.Encode(v)
+}
+
+// parseTemplate parses the -template flag. Templates may use the json function to encode a value.
+func (o *outputOptions) parseTemplate() (*template.Template, error) {
+	return template.New("output").Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}).Parse(o.Template)
+}
+
+// yamlScalar formats a value as a YAML scalar, quoting strings that would otherwise be ambiguous.
+func yamlScalar(v any) string {
+	s, ok := v.(string)
+	if !ok {
+		return fmt.Sprint(v)
+	}
+	_, numErr := strconv.ParseFloat(s, 64)
+	switch {
+	case s == "" || numErr == nil || strings.TrimSpace(s) != s,
+		strings.ContainsAny(s[:1], "-?:,[]{}#&*!|>'\"%@`"), // Indicator characters.
+		strings.Contains(s, ": ") || strings.Contains(s, " #"),
+		slices.Contains([]string{"true", "false", "yes", "no", "null", "~"}, strings.ToLower(s)):
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"user_api_with_concurrency/models"
+)
+
+// TestPrintUsers tests every output format, column selection and the no-headers mode.
+func TestPrintUsers(t *testing.T) {
+	users := []models.User{
+		{ID: 1, Name: "Frodo Baggins", Age: 50, Email: "frodo@tolkien.com"},
+		{ID: 2, Name: "Sam: Gamgee", Age: 38, Email: "sam@tolkien.com", Locale: "en-GB"},
+	}
+
+	tests := []struct {
+		name     string
+		opts     outputOptions
+		expected string
+	}{
+		{"table", outputOptions{Format: "table", Columns: "id,name"},
+			"ID  NAME\n1   Frodo Baggins\n2   Sam: Gamgee\n"},
+		{"table without headers", outputOptions{Format: "table", Columns: "id", NoHeaders: true},
+			"1\n2\n"},
+		{"csv", outputOptions{Format: "csv", Columns: "id,locale"},
+			"id,locale\n1,\n2,en-GB\n"},
+		{"json", outputOptions{Format: "json", Columns: "id"},
+			"[\n  {\n    \"id\": 1\n  },\n  {\n    \"id\": 2\n  }\n]\n"},
+		{"jsonl", outputOptions{Format: "jsonl", Columns: "id,age"},
+			"{\"id\":1,\"age\":50}\n{\"id\":2,\"age\":38}\n"},
+		{"yaml", outputOptions{Format: "yaml", Columns: "id,name"},
+			"- id: 1\n  name: Frodo Baggins\n- id: 2\n  name: \"Sam: Gamgee\"\n"},
+		{"template", outputOptions{Template: "{{.ID}}={{.Email}}"},
+			"1=frodo@tolkien.com\n2=sam@tolkien.com\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tt.opts.printUsers(&buf, users); err != nil {
+				t.Fatalf("printUsers failed: %v", err)
+			}
+			if buf.String() != tt.expected {
+				t.Errorf("Expected:\n%s\ngot:\n%s", tt.expected, buf.String())
+			}
+		})
+	}
+}
+
+// TestPrintUser tests that a single user is printed as an object.
+func TestPrintUser(t *testing.T) {
+	var buf bytes.Buffer
+	opts := outputOptions{Format: "jsonl", Columns: "id,name"}
+	if err := opts.printUser(&buf, models.User{ID: 1, Name: "Frodo"}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "{\"id\":1,\"name\":\"Frodo\"}\n" {
+		t.Errorf("Unexpected output %q", buf.String())
+	}
+}
+
+// TestOutputOptions_Invalid tests that invalid output options are rejected.
+func TestOutputOptions_Invalid(t *testing.T) {
+	for _, opts := range []outputOptions{
+		{Format: "xml"},
+		{Format: "template"},
+		{Format: "table", Columns: "id,phone"},
+	} {
+		if err := opts.validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", opts)
+		}
+	}
+
+	var buf bytes.Buffer
+	opts := outputOptions{Format: "csv"}
+	if err := opts.printValue(&buf, struct{}{}, nil); err == nil {
+		t.Errorf("Expected csv output of a non-user result to fail")
+	}
+}
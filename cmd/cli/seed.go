@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"user_api_with_concurrency/models"
+	"user_api_with_concurrency/services"
+	"user_api_with_concurrency/utils"
+)
+
+// seedColumns are the columns of seed files; the users have no ID yet.
+var seedColumns = []string{"name", "age", "email", "locale"}
+
+// setupSeed defines the flags of the "seed" command: it generates deterministic test users and imports them
+// into the API, or writes them to a file that can be imported later.
+func setupSeed(fs *flag.FlagSet, defaults outputOptions) func() error {
+	n := fs.Int("n", 100, "Number of users to generate")
+	seed := fs.Uint64("seed", 1, "Seed of the generator; the same seed always gives the same users")
+	file := fs.String("out", "", "Write the users to this file (use - for stdout) instead of importing them into the API")
+	format := fs.String("format", "", "File format (csv, tsv or jsonl); defaults to the file extension, or csv for stdout")
+	dryRun := fs.Bool("dry-run", false, "Validate the users on the server without importing them")
+	out := addOutputFlags(fs, defaults)
+
+	return func() error {
+		if *n <= 0 {
+			return fmt.Errorf("the number of users must be greater than 0")
+		}
+		if err := out.validate(); err != nil {
+			return err
+		}
+
+		users := utils.GenerateUsers(*n, *seed)
+		if *file != "" {
+			return writeSeedFile(users, *file, *format)
+		}
+
+		// Import the users through the import endpoint in a single request.
+		var body bytes.Buffer
+		if err := writeSeedUsers(&body, users, utils.FormatJSONL); err != nil {
+			return err
+		}
+		report, err := services.ImportUsers(&body, string(utils.FormatJSONL), *dryRun)
+		if err != nil {
+			return err
+		}
+		if err := printImportReport(out, report); err != nil {
+			return err
+		}
+		if report.Failed > 0 {
+			return errFailed
+		}
+		return nil
+	}
+}
+
+// writeSeedFile writes generated users to a file, or to stdout for "-".
+// If format is empty, it is derived from the file extension, which may end in ".gz".
+func writeSeedFile(users []models.User, filename, format string) error {
+	var f utils.Format
+	if format != "" {
+		var err error
+		if f, err = utils.ParseFormat(format); err != nil {
+			return err
+		}
+	}
+
+	if filename == "-" {
+		if f == "" {
+			f = utils.FormatCSV
+		}
+		return writeSeedUsers(os.Stdout, users, f)
+	}
+
+	stats, err := utils.ExportUsersToFile(utils.StreamUsers(users), filename, seedExportOptions(f))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %d users to %s\n", stats.Rows, filename)
+	return nil
+}
+
+// writeSeedUsers writes generated users to w in the given format.
+func writeSeedUsers(w io.Writer, users []models.User, format utils.Format) error {
+	_, err := utils.ExportUsers(utils.StreamUsers(users), w, seedExportOptions(format))
+	return err
+}
+
+// seedExportOptions returns the export options of generated users: all of them are written as generated,
+// without the configured pipeline or redaction, so that importing them gives back the same users.
+func seedExportOptions(format utils.Format) utils.ExportOptions {
+	return utils.ExportOptions{
+		Format:    format,
+		Columns:   seedColumns,
+		Pipeline:  utils.Pipeline{},
+		Redaction: &utils.RedactionProfile{Name: "none"},
+	}
+}
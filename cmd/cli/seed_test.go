@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"user_api_with_concurrency/models"
+	"user_api_with_concurrency/utils"
+)
+
+// TestWriteSeedFile tests that seed files are written unfiltered and import back to the generated users.
+func TestWriteSeedFile(t *testing.T) {
+	generated := utils.GenerateUsers(50, 3)
+
+	for _, name := range []string{"seed.csv", "seed.jsonl"} {
+		filename := filepath.Join(t.TempDir(), name)
+		if err := writeSeedFile(generated, filename, ""); err != nil {
+			t.Fatalf("writeSeedFile(%s) failed: %v", name, err)
+		}
+
+		file, err := os.Open(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		format, _, _ := utils.FormatFromFilename(filename)
+		rows, err := utils.ReadUsers(file, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var read []models.User
+		for row := range rows {
+			if row.Err != nil {
+				t.Errorf("%s row %d: %v", name, row.Row, row.Err)
+			}
+			read = append(read, row.User)
+		}
+		if len(read) != len(generated) {
+			t.Fatalf("%s: expected %d users, got %d", name, len(generated), len(read))
+		}
+		for i := range read {
+			if read[i] != generated[i] {
+				t.Errorf("%s: user %d is %+v, expected %+v", name, i, read[i], generated[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"user_api_with_concurrency/services"
+)
+
+// maxHistory is the number of lines kept in the history file.
+const maxHistory = 1000
+
+// shellBuiltins are the commands of the shell itself, completed along with the CLI commands.
+var shellBuiltins = []*command{
+	{name: "help", summary: "Show this help"},
+	{name: "history", summary: "Show the command history"},
+	{name: "exit", summary: "Leave the shell (or press Ctrl-D)"},
+	{name: "quit", summary: "Leave the shell"},
+}
+
+// defaultHistoryFile returns the file the shell history is saved to: USERCLI_HISTORY,
+// or "usercli/history" in the user's configuration directory.
+func defaultHistoryFile() string {
+	if path := os.Getenv("USERCLI_HISTORY"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "usercli", "history")
+}
+
+// lineReader reads the lines of a shell session.
+type lineReader interface {
+	readLine(prompt string) (string, error)
+}
+
+// plainReader reads whole lines, for scripts piped to the shell and terminals without raw mode.
+type plainReader struct {
+	scanner *bufio.Scanner
+	prompt  bool // Print the prompt; false when the input is not a terminal.
+}
+
+func (p *plainReader) readLine(prompt string) (string, error) {
+	if p.prompt {
+		fmt.Print(prompt)
+	}
+	if !p.scanner.Scan() {
+		if err := p.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return p.scanner.Text(), nil
+}
+
+// rawReader reads lines with the line editor, switching the terminal to raw mode only while
+// a line is read, so commands run with the terminal in its normal mode.
+type rawReader struct {
+	editor *lineEditor
+	fd     uintptr
+}
+
+func (r *rawReader) readLine(prompt string) (string, error) {
+	restore, err := makeRaw(r.fd)
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+	return r.editor.readLine(prompt)
+}
+
+// shell is an interactive session running CLI commands.
+type shell struct {
+	global      outputOptions // Output defaults of every command.
+	history     []string      // Lines entered, oldest first; shared with the line editor.
+	historyFile string        // File the history is appended to; empty disables persistence.
+
+	idsMu      sync.Mutex
+	ids        []string  // User IDs fetched from the server for completion.
+	idsFetched time.Time // When ids were fetched; they are refreshed after a few seconds.
+}
+
+// setupShell defines the flags of the "shell" command: it starts an interactive shell. Every line is split
+// into arguments like a POSIX shell command and run as a CLI command, until "exit" or end of input.
+func setupShell(fs *flag.FlagSet, global outputOptions) func() error {
+	server := fs.String("server", current.Server, "Base URL of the API server used by the session")
+	historyFile := fs.String("history", defaultHistoryFile(), "File the command history is saved to (empty to disable)")
+
+	return func() error {
+		if err := current.set("server", *server); err != nil {
+			return err
+		}
+		services.Configure(current.Server, current.Token) // Commands of the session target this server by default.
+
+		sh := &shell{global: global, historyFile: *historyFile}
+		sh.loadHistory()
+
+		// Edit lines in raw mode on terminals, or read plain lines from pipes.
+		var reader lineReader = &plainReader{scanner: bufio.NewScanner(os.Stdin), prompt: isTerminal(os.Stdin)}
+		if isTerminal(os.Stdin) {
+			if restore, err := makeRaw(os.Stdin.Fd()); err == nil {
+				restore()
+				editor := newLineEditor(os.Stdin, os.Stdout, sh.complete)
+				reader = &rawReader{editor: editor, fd: os.Stdin.Fd()}
+			}
+		}
+
+		if isTerminal(os.Stdin) {
+			fmt.Printf("Connected to %s (profile %s). Type 'help' for commands, 'exit' to quit.\n", current.Server, current.Profile)
+		}
+		for {
+			if editor, ok := reader.(*rawReader); ok {
+				editor.editor.history = sh.history
+			}
+			line, err := reader.readLine("users> ")
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if sh.run(line) {
+				return nil
+			}
+		}
+	}
+}
+
+// run runs one line of the session and reports whether the session must end.
+func (sh *shell) run(line string) (exit bool) {
+	args, err := splitArgs(line)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return false
+	}
+	if len(args) == 0 {
+		return false
+	}
+	sh.addHistory(strings.TrimSpace(line))
+
+	switch args[0] {
+	case "exit", "quit":
+		return true
+	case "help":
+		printUsage()
+		fmt.Println()
+		fmt.Println("Shell commands:")
+		fmt.Println("  help     Show this help")
+		fmt.Println("  history  Show the command history")
+		fmt.Println("  exit     Leave the shell (or press Ctrl-D)")
+	case "history":
+		for i, h := range sh.history {
+			fmt.Printf("%5d  %s\n", i+1, h)
+		}
+	case "shell":
+		fmt.Println("Error: already in a shell")
+	default:
+		if err := dispatch(args, sh.global); err != nil && !errors.Is(err, errFailed) {
+			fmt.Println("Error:", err)
+		}
+	}
+	return false
+}
+
+// loadHistory reads the history file, keeping its last maxHistory lines.
+func (sh *shell) loadHistory() {
+	if sh.historyFile == "" {
+		return
+	}
+	data, err := os.ReadFile(sh.historyFile)
+	if err != nil {
+		return // No history yet.
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(lines) > maxHistory {
+		lines = lines[len(lines)-maxHistory:]
+		os.WriteFile(sh.historyFile, []byte(strings.Join(lines, "\n")+"\n"), 0o600) // Compact the file.
+	}
+	sh.history = slices.DeleteFunc(lines, func(l string) bool { return l == "" })
+}
+
+// addHistory records a line, skipping repeats of the previous one, and appends it to the history file.
+func (sh *shell) addHistory(line string) {
+	if len(sh.history) > 0 && sh.history[len(sh.history)-1] == line {
+		return
+	}
+	sh.history = append(sh.history, line)
+	if len(sh.history) > maxHistory {
+		sh.history = sh.history[1:]
+	}
+
+	if sh.historyFile == "" {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(sh.historyFile), 0o700); err != nil {
+		return
+	}
+	file, err := os.OpenFile(sh.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	fmt.Fprintln(file, line)
+}
+
+// complete returns the completions of the word before the cursor, like the completion scripts:
+// commands, subcommands, flags, and user IDs after an ID flag.
+func (sh *shell) complete(line []rune, pos int) ([]string, int) {
+	before := string(line[:pos])
+	start := strings.LastIndexByte(before, ' ') + 1
+	words := strings.Fields(before[:start])
+
+	// Complete the CLI commands, except the shell itself, and the shell builtins.
+	root := rootCommand()
+	root.subcommands = slices.DeleteFunc(root.subcommands, func(c *command) bool { return c.name == "shell" })
+	root.subcommands = append(root.subcommands, shellBuiltins...)
+
+	values := func(name string) []string { return flagValues(name, sh.userIDs) }
+	candidates, n := completeArgs(root, words, before[start:], values)
+	return candidates, len([]rune(before[:start+n]))
+}
+
+// userIDs returns the IDs of the users on the server, cached for a few seconds.
+// Errors are ignored, since completion must never get in the way.
+func (sh *shell) userIDs() []string {
+	sh.idsMu.Lock()
+	defer sh.idsMu.Unlock()
+	if time.Since(sh.idsFetched) < 5*time.Second {
+		return sh.ids
+	}
+
+	ids := fetchUserIDs(current.Server)
+	if ids == nil {
+		return nil
+	}
+	sh.ids = ids
+	sh.idsFetched = time.Now()
+	return sh.ids
+}
+
+// splitArgs splits a line into arguments like a POSIX shell: words are separated by whitespace,
+// quotes group words, and a backslash escapes the next character outside single quotes.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inWord := false
+	var quote rune
+
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != '\'' && r == '\\' && i+1 < len(runes):
+			i++
+			current.WriteRune(runes[i])
+			inWord = true
+		case quote != 0:
+			current.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				args = append(args, current.String())
+				current.Reset()
+				inWord = false
+			}
+		default:
+			current.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inWord {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"user_api_with_concurrency/models"
+)
+
+// TestSplitArgs tests splitting shell lines into arguments.
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected []string
+	}{
+		{"  users   list  ", []string{"users", "list"}},
+		{`users create -name "Sam Gamgee" -email 'sam@tolkien.com'`, []string{"users", "create", "-name", "Sam Gamgee", "-email", "sam@tolkien.com"}},
+		{`-template '{{.ID}}\n' a\ b ""`, []string{"-template", `{{.ID}}\n`, "a b", ""}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		got, err := splitArgs(tt.line)
+		if err != nil || !slices.Equal(got, tt.expected) {
+			t.Errorf("splitArgs(%q) = %q, %v; want %q", tt.line, got, err, tt.expected)
+		}
+	}
+
+	if _, err := splitArgs(`users create -name "Sam`); err == nil {
+		t.Errorf("Expected an unterminated quote to fail")
+	}
+}
+
+// TestLineEditor tests editing keys, history navigation and completion.
+func TestLineEditor(t *testing.T) {
+	complete := func(line []rune, pos int) ([]string, int) {
+		return []string{"users"}, 0
+	}
+
+	tests := []struct {
+		name, keys, expected string
+	}{
+		{"typing", "users list\r", "users list"},
+		{"backspace", "usex\x7frs\r", "users"},
+		{"cursor movement", "users lst\x1b[D\x1b[Di\x05 -o json\r", "users list -o json"},
+		{"home and delete", "xusers\x1b[H\x1b[3~\r", "users"},
+		{"kill line", "garbage\x15users\r", "users"},
+		{"delete word", "users list\x17get\r", "users get"},
+		{"completion", "us\t list\r", "users  list"},
+		{"history", "\x1b[A\x1b[A\x1b[B\r", "users get"},
+		{"interrupt", "abc\x03", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newLineEditor(strings.NewReader(tt.keys), io.Discard, complete)
+			e.history = []string{"users list", "users get"}
+			got, err := e.readLine("> ")
+			if err != nil || got != tt.expected {
+				t.Errorf("Expected %q, got %q (%v)", tt.expected, got, err)
+			}
+		})
+	}
+
+	// Ctrl-D on an empty line ends the input.
+	e := newLineEditor(strings.NewReader("\x04"), io.Discard, nil)
+	if _, err := e.readLine("> "); !errors.Is(err, io.EOF) {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+}
+
+// TestShellComplete tests completing commands, subcommands and user IDs from the server.
+func TestShellComplete(t *testing.T) {
+	setUsersServer(t, models.User{ID: 7, Name: "Frodo"}, models.User{ID: 12, Name: "Sam"})
+	sh := &shell{}
+
+	tests := []struct {
+		line          string
+		expected      []string
+		expectedStart int
+	}{
+		{"us", []string{"users"}, 0},
+		{"users de", []string{"delete"}, 6},
+		{"users get -id ", []string{"7", "12"}, 14},
+		{"users get -id 1", []string{"12"}, 14},
+		{"users get -id=1", []string{"12"}, 14},
+		{"users get -name ", nil, 16},
+	}
+	for _, tt := range tests {
+		line := []rune(tt.line)
+		got, start := sh.complete(line, len(line))
+		if !slices.Equal(got, tt.expected) || start != tt.expectedStart {
+			t.Errorf("complete(%q) = %q, %d; want %q, %d", tt.line, got, start, tt.expected, tt.expectedStart)
+		}
+	}
+}
+
+// TestShellHistory tests that lines are saved to the history file and reloaded.
+func TestShellHistory(t *testing.T) {
+	setUsersServer(t)
+	path := filepath.Join(t.TempDir(), "usercli", "history")
+
+	sh := &shell{historyFile: path, global: outputOptions{Format: "table"}}
+	for _, line := range []string{"users list", "users list", "  help  ", "bogus-command"} {
+		if sh.run(line) {
+			t.Fatalf("Expected %q not to end the session", line)
+		}
+	}
+	if !sh.run("exit") {
+		t.Errorf("Expected exit to end the session")
+	}
+
+	data, _ := os.ReadFile(path)
+	if string(data) != "users list\nhelp\nbogus-command\nexit\n" {
+		t.Errorf("Unexpected history file:\n%s", data)
+	}
+
+	reloaded := &shell{historyFile: path}
+	reloaded.loadHistory()
+	if len(reloaded.history) != 4 || reloaded.history[0] != "users list" {
+		t.Errorf("Unexpected reloaded history %q", reloaded.history)
+	}
+}
+
+// setUsersServer starts a server listing the given users and makes it the default server.
+func setUsersServer(t *testing.T, list ...models.User) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(list)
+	}))
+	t.Cleanup(ts.Close)
+
+	old := current.Server
+	current.Server = ts.URL
+	t.Cleanup(func() { current.Server = old })
+}
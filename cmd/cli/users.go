@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+	"user_api_with_concurrency/client"
+	"user_api_with_concurrency/models"
+)
+
+// newClient returns a client for the server, authenticated with the token of the settings.
+func newClient(server string) *client.Client {
+	c := client.New(server)
+	if current.Token != "" {
+		c.Header.Set("Authorization", "Bearer "+current.Token)
+	}
+	return c
+}
+
+// usersCommand returns the "users" command, running CRUD subcommands against the API and printing the result.
+// The server, timeout and output flags are shared by all subcommands; the output flags default to the global ones.
+func usersCommand() *command {
+	var server *string
+	var timeout *time.Duration
+	var out *outputOptions
+
+	// run runs a request with the shared timeout, after validating the output flags.
+	run := func(request func(ctx context.Context, c *client.Client) error) error {
+		if err := out.validate(); err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+		return request(ctx, newClient(*server))
+	}
+
+	// idFlag defines the -id flag; it returns an error if the ID is missing.
+	idFlag := func(fs *flag.FlagSet) func() (int, error) {
+		id := fs.Int("id", 0, "User ID")
+		return func() (int, error) {
+			if *id == 0 {
+				return 0, fmt.Errorf("user ID must be specified using -id")
+			}
+			return *id, nil
+		}
+	}
+
+	// userFlags defines the flags of the user fields.
+	userFlags := func(fs *flag.FlagSet) *models.User {
+		var user models.User
+		fs.StringVar(&user.Name, "name", "", "Name of the user")
+		fs.IntVar(&user.Age, "age", 0, "Age of the user")
+		fs.StringVar(&user.Email, "email", "", "Email of the user")
+		fs.StringVar(&user.Locale, "locale", "", "BCP 47 locale of the user, e.g. tr-TR")
+		return &user
+	}
+
+	return &command{
+		name:    "users",
+		summary: "List, get, create, update or delete users",
+		shared: func(fs *flag.FlagSet, global outputOptions) {
+			server = fs.String("server", current.Server, "Base URL of the API server")
+			timeout = fs.Duration("timeout", current.Timeout, "Timeout of the request")
+			out = addOutputFlags(fs, global)
+		},
+		subcommands: []*command{
+			{name: "list", summary: "List all users", usage: "[-server <url>]",
+				setup: func(fs *flag.FlagSet, global outputOptions) func() error {
+					return func() error {
+						return run(func(ctx context.Context, c *client.Client) error {
+							list, err := c.ListUsers(ctx)
+							if err != nil {
+								return err
+							}
+							return out.printUsers(os.Stdout, list)
+						})
+					}
+				}},
+			{name: "get", summary: "Get a user by ID", usage: "[-server <url>] -id <user_id>",
+				setup: func(fs *flag.FlagSet, global outputOptions) func() error {
+					id := idFlag(fs)
+					return func() error {
+						id, err := id()
+						if err != nil {
+							return err
+						}
+						return run(func(ctx context.Context, c *client.Client) error {
+							user, err := c.GetUser(ctx, id)
+							if err != nil {
+								return err
+							}
+							return out.printUser(os.Stdout, user)
+						})
+					}
+				}},
+			{name: "create", summary: "Create a user", usage: "[-server <url>] [-name <name>] [-age <age>] [-email <email>] [-locale <locale>]",
+				setup: func(fs *flag.FlagSet, global outputOptions) func() error {
+					user := userFlags(fs)
+					return func() error {
+						return run(func(ctx context.Context, c *client.Client) error {
+							created, err := c.CreateUser(ctx, *user)
+							if err != nil {
+								return err
+							}
+							return out.printUser(os.Stdout, created)
+						})
+					}
+				}},
+			{name: "update", summary: "Update the given fields of a user", usage: "[-server <url>] -id <user_id> [-name <name>] [-age <age>] [-email <email>] [-locale <locale>]",
+				setup: func(fs *flag.FlagSet, global outputOptions) func() error {
+					id := idFlag(fs)
+					user := userFlags(fs)
+					return func() error {
+						id, err := id()
+						if err != nil {
+							return err
+						}
+						return run(func(ctx context.Context, c *client.Client) error {
+							updated, err := updateUser(ctx, c, id, *user, fs)
+							if err != nil {
+								return err
+							}
+							return out.printUser(os.Stdout, updated)
+						})
+					}
+				}},
+			{name: "delete", summary: "Delete a user by ID", usage: "[-server <url>] -id <user_id>",
+				setup: func(fs *flag.FlagSet, global outputOptions) func() error {
+					id := idFlag(fs)
+					return func() error {
+						id, err := id()
+						if err != nil {
+							return err
+						}
+						return run(func(ctx context.Context, c *client.Client) error {
+							if err := c.DeleteUser(ctx, id); err != nil {
+								return err
+							}
+							deleted := struct {
+								Deleted int `json:"deleted"`
+							}{id}
+							return out.printValue(os.Stdout, deleted, func(w io.Writer) {
+								fmt.Fprintf(w, "Deleted user %d\n", id)
+							})
+						})
+					}
+				}},
+		},
+	}
+}
+
+// updateUser updates only the fields whose flags were set, keeping the others as stored.
+// It sends a PATCH rather than a GET then a PUT, since the user returned by the GET may be redacted.
+func updateUser(ctx context.Context, c *client.Client, id int, changes models.User, fs *flag.FlagSet) (models.User, error) {
+	var patch models.UserPatch
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "name":
+			patch.Name = &changes.Name
+		case "age":
+			patch.Age = &changes.Age
+		case "email":
+			patch.Email = &changes.Email
+		case "locale":
+			patch.Locale = &changes.Locale
+		}
+	})
+	return c.PatchUser(ctx, id, patch)
+}
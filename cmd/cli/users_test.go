@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"user_api_with_concurrency/api"
+	"user_api_with_concurrency/client"
+	"user_api_with_concurrency/models"
+	"user_api_with_concurrency/utils"
+)
+
+// TestUsersCommand tests the users subcommands against the API handlers.
+func TestUsersCommand(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /users", api.CreateUser)
+	mux.HandleFunc("GET /users", api.GetUsers)
+	mux.HandleFunc("GET /users/{id}", api.GetUserByID)
+	mux.HandleFunc("PUT /users/{id}", api.UpdateUser)
+	mux.HandleFunc("PATCH /users/{id}", api.PatchUser)
+	mux.HandleFunc("DELETE /users/{id}", api.DeleteUser)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	for _, args := range [][]string{
+		{"create", "-server", ts.URL, "-name", "Sam Gamgee", "-age", "38", "-email", "sam@tolkien.com"},
+		{"update", "-server", ts.URL, "-id", "1", "-age", "39"},
+		{"get", "-server", ts.URL, "-id", "1"},
+		{"list", "-server", ts.URL},
+		{"delete", "-server", ts.URL, "-id", "1"},
+	} {
+		if err := dispatch(append([]string{"users"}, args...), outputOptions{}); err != nil {
+			t.Errorf("users %v failed: %v", args, err)
+		}
+	}
+
+	if err := dispatch([]string{"users", "get", "-server", ts.URL, "-id", "1"}, outputOptions{}); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if err := dispatch([]string{"users", "frobnicate"}, outputOptions{}); err == nil {
+		t.Errorf("Expected an unknown subcommand to fail")
+	}
+}
+
+// TestUsersUpdate_Redacted tests that updating a user through a server that masks the responses
+// does not store the masked values.
+func TestUsersUpdate_Redacted(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /users", api.CreateUser)
+	mux.HandleFunc("GET /users/{id}", api.GetUserByID)
+	mux.HandleFunc("PATCH /users/{id}", api.PatchUser)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	c := client.New(ts.URL)
+	user, err := c.CreateUser(context.Background(), models.User{Name: "Sam Gamgee", Age: 38, Email: "sam@tolkien.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := utils.SetRedactionRoles(nil, "masked"); err != nil {
+		t.Fatal(err)
+	}
+	defer utils.SetRedactionRoles(nil, "none")
+	args := []string{"users", "update", "-server", ts.URL, "-id", strconv.Itoa(user.ID), "-name", "Samwise Gamgee"}
+	if err := dispatch(args, outputOptions{}); err != nil {
+		t.Fatalf("users update failed: %v", err)
+	}
+	utils.SetRedactionRoles(nil, "none")
+
+	expected := models.User{ID: user.ID, Name: "Samwise Gamgee", Age: 38, Email: "sam@tolkien.com"}
+	if stored, err := c.GetUser(context.Background(), user.ID); err != nil || stored != expected {
+		t.Errorf("Expected %+v to be stored, got %+v (%v)", expected, stored, err)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"user_api_with_concurrency/client"
+	"user_api_with_concurrency/models"
+	"user_api_with_concurrency/utils"
+)
+
+// Delays between reconnection attempts of the watch command, doubled after every failure.
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+)
+
+// setupWatch defines the flags of the "watch" command: it prints the changes of the user set as they happen,
+// reconnecting after a disconnect and resuming from the last event seen, until interrupted.
+func setupWatch(fs *flag.FlagSet, defaults outputOptions) func() error {
+	server := fs.String("server", current.Server, "Base URL of the API server")
+	ids := fs.String("id", "", "Comma-separated user IDs and ranges to watch, e.g. 1,5-20 (default all)")
+	types := fs.String("type", "", "Comma-separated event types to watch: create, update, delete (default all)")
+	since := fs.Int64("since", -1, "Replay the buffered events after this event ID before watching")
+	out := addOutputFlags(fs, defaults)
+
+	return func() error {
+		query, err := watchQuery(*ids, *types)
+		if err != nil {
+			return err
+		}
+		if err := out.validate(); err != nil {
+			return err
+		}
+		if out.Format == "csv" || out.Format == "yaml" {
+			return fmt.Errorf("-output %s is not supported by watch", out.Format)
+		}
+
+		// Stop watching on Ctrl-C.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		return watchChanges(ctx, newClient(*server), query, *since, out, os.Stdout, os.Stderr)
+	}
+}
+
+// watchQuery validates the ID and event type filters and returns them as query parameters.
+func watchQuery(ids, types string) (url.Values, error) {
+	query := url.Values{}
+
+	if ids != "" {
+		list, err := parseIDs(nil, ids, map[int]bool{})
+		if err != nil {
+			return nil, err
+		}
+		parts := make([]string, len(list))
+		for i, id := range list {
+			parts[i] = strconv.Itoa(id)
+		}
+		query.Set("id", strings.Join(parts, ","))
+	}
+
+	if types != "" {
+		valid := []string{utils.OpCreate, utils.OpUpdate, utils.OpDelete}
+		for _, typ := range strings.Split(types, ",") {
+			if !slices.Contains(valid, strings.TrimSpace(typ)) {
+				return nil, fmt.Errorf("unknown event type %q (expected create, update or delete)", typ)
+			}
+		}
+		query.Set("type", types)
+	}
+	return query, nil
+}
+
+// watchChanges prints the events of the change stream until ctx is done. After a disconnect it
+// reconnects with a growing delay, resuming after the last event printed. If those events are no
+// longer available on the server, a warning is printed and only new events are watched.
+// A negative since starts with new events.
+func watchChanges(ctx context.Context, c *client.Client, query url.Values, since int64, out *outputOptions, w, errw io.Writer) error {
+	delay := minReconnectDelay
+	for {
+		params := url.Values{}
+		for key, values := range query {
+			params[key] = values
+		}
+		if since >= 0 {
+			params.Set("since", strconv.FormatInt(since, 10))
+		}
+
+		stream, err := c.WatchChanges(ctx, params)
+		var apiErr *client.APIError
+		switch {
+		case ctx.Err() != nil:
+			return nil // Interrupted.
+		case errors.Is(err, client.ErrGone):
+			fmt.Fprintf(errw, "Warning: %v; some changes were missed, watching new changes\n", err)
+			since = -1
+			continue
+		case errors.As(err, &apiErr) && apiErr.StatusCode < 500:
+			return err // Retrying would not help, e.g. for an invalid filter.
+		case err != nil:
+			fmt.Fprintf(errw, "Watch failed: %v; retrying in %s\n", err, delay)
+			if !sleep(ctx, delay) {
+				return nil
+			}
+			delay = min(2*delay, maxReconnectDelay)
+			continue
+		}
+		delay = minReconnectDelay
+
+		// Print the events until the stream ends.
+		for {
+			event, err := stream.Next()
+			if err != nil {
+				break
+			}
+			since = event.ID
+			if err := printEvent(w, out, event); err != nil {
+				stream.Close()
+				return err
+			}
+		}
+		stream.Close()
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		fmt.Fprintf(errw, "Watch disconnected; reconnecting in %s\n", delay)
+		if !sleep(ctx, delay) {
+			return nil
+		}
+	}
+}
+
+// printEvent prints a change event in the output format; the table format prints one line per event.
+func printEvent(w io.Writer, out *outputOptions, event models.UserEvent) error {
+	return out.printValue(w, event, func(w io.Writer) {
+		user := event.User
+		fmt.Fprintf(w, "%d\t%s\t%-6s\tuser %d\t%s (%d, %s)\n",
+			event.ID, event.Time.Local().Format(time.DateTime), event.Type, user.ID, user.Name, user.Age, user.Email)
+	})
+}
+
+// sleep waits for the delay and reports whether ctx is still active.
+func sleep(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
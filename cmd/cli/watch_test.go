@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"user_api_with_concurrency/client"
+	"user_api_with_concurrency/models"
+)
+
+// TestWatchQuery tests the validation of the watch filters.
+func TestWatchQuery(t *testing.T) {
+	query, err := watchQuery("3,1-2", "create,delete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := query.Encode(); got != "id=3%2C1%2C2&type=create%2Cdelete" {
+		t.Errorf("Unexpected query %q", got)
+	}
+
+	for _, filters := range [][2]string{{"0", ""}, {"", "rename"}} {
+		if _, err := watchQuery(filters[0], filters[1]); err == nil {
+			t.Errorf("Expected filters %q to fail", filters)
+		}
+	}
+}
+
+// TestWatchChanges tests that the watch resumes after a disconnect from the last event printed,
+// and starts over with new events when the resume point has expired.
+func TestWatchChanges(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var queries []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		switch len(queries) {
+		case 1:
+			http.Error(w, "events are no longer available", http.StatusGone)
+		case 2:
+			enc := json.NewEncoder(w)
+			enc.Encode(models.UserEvent{ID: 7, Type: "create", User: models.User{ID: 1, Name: "Sam Gamgee"}})
+			w.Write([]byte("\n")) // A heartbeat.
+			enc.Encode(models.UserEvent{ID: 8, Type: "update", User: models.User{ID: 1, Name: "Sam Gamgee"}})
+		default:
+			cancel() // Stop the watch once it has reconnected.
+			<-r.Context().Done()
+		}
+	}))
+	defer ts.Close()
+
+	var out, errOut bytes.Buffer
+	err := watchChanges(ctx, client.New(ts.URL), nil, 5, &outputOptions{Format: "jsonl"}, &out, &errOut)
+	if err != nil {
+		t.Fatalf("watchChanges failed: %v", err)
+	}
+
+	if strings.Join(queries, " ") != "since=5  since=8" {
+		t.Errorf("Unexpected queries %q", queries)
+	}
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 2 || !strings.Contains(lines[1], `"type":"update"`) {
+		t.Errorf("Unexpected output %q", out.String())
+	}
+	if !strings.Contains(errOut.String(), "some changes were missed") {
+		t.Errorf("Expected a warning about missed changes, got %q", errOut.String())
+	}
+}
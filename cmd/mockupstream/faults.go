@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"math/rand"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FaultConfig describes the faults the mock upstream injects into its responses.
+// Each rate is a probability between 0 and 1 that is rolled independently for every request.
+type FaultConfig struct {
+	Latency       LatencyDistribution // Distribution used to delay every response.
+	ErrorRate     float64             // Probability of answering with 500 (Internal Server Error).
+	RateLimitRate float64             // Probability of answering with 429 (Too Many Requests).
+	RetryAfter    time.Duration       // Value advertised in the Retry-After header of 429 responses.
+	TruncateRate  float64             // Probability of cutting the JSON body in half.
+	SlowDripRate  float64             // Probability of sending the body a few bytes at a time.
+	DripInterval  time.Duration       // Pause between chunks of a slow-drip response.
+	DripChunkSize int                 // Number of bytes written per chunk of a slow-drip response.
+}
+
+// Validate checks that every rate is a valid probability and that the drip settings are usable.
+func (c FaultConfig) Validate() error {
+	rates := map[string]float64{
+		"error-rate":      c.ErrorRate,
+		"rate-limit-rate": c.RateLimitRate,
+		"truncate-rate":   c.TruncateRate,
+		"slow-drip-rate":  c.SlowDripRate,
+	}
+	for _, name := range slices.Sorted(maps.Keys(rates)) { // Sorted, so the error is deterministic.
+		if rate := rates[name]; rate < 0 || rate > 1 {
+			return fmt.Errorf("%s must be between 0 and 1, got %v", name, rate)
+		}
+	}
+	if c.DripChunkSize <= 0 {
+		return fmt.Errorf("drip-chunk must be positive, got %d", c.DripChunkSize)
+	}
+	return nil
+}
+
+// LatencyDistribution produces the artificial delay applied to a single response.
+type LatencyDistribution interface {
+	Sample(r *rand.Rand) time.Duration // Sample returns the delay for one request.
+	String() string                    // String returns the specification the distribution was parsed from.
+}
+
+// noLatency never delays a response.
+type noLatency struct{}
+
+func (noLatency) Sample(*rand.Rand) time.Duration { return 0 }
+func (noLatency) String() string                  { return "none" }
+
+// fixedLatency delays every response by the same amount.
+type fixedLatency struct{ d time.Duration }
+
+func (l fixedLatency) Sample(*rand.Rand) time.Duration { return l.d }
+func (l fixedLatency) String() string                  { return "fixed:" + l.d.String() }
+
+// uniformLatency delays responses by a value drawn uniformly from [min, max].
+type uniformLatency struct{ min, max time.Duration }
+
+func (l uniformLatency) Sample(r *rand.Rand) time.Duration {
+	return l.min + time.Duration(r.Int63n(int64(l.max-l.min)+1))
+}
+func (l uniformLatency) String() string { return "uniform:" + l.min.String() + "-" + l.max.String() }
+
+// normalLatency delays responses by a normally distributed value, clamped at zero.
+type normalLatency struct{ mean, stddev time.Duration }
+
+func (l normalLatency) Sample(r *rand.Rand) time.Duration {
+	d := time.Duration(r.NormFloat64()*float64(l.stddev)) + l.mean
+	return max(d, 0)
+}
+func (l normalLatency) String() string { return "normal:" + l.mean.String() + "," + l.stddev.String() }
+
+// expLatency delays responses by an exponentially distributed value, producing a long tail.
+type expLatency struct{ mean time.Duration }
+
+func (l expLatency) Sample(r *rand.Rand) time.Duration {
+	return time.Duration(math.Round(r.ExpFloat64() * float64(l.mean)))
+}
+func (l expLatency) String() string { return "exp:" + l.mean.String() }
+
+// ParseLatency parses a latency specification into a LatencyDistribution.
+// Supported forms are "none", "fixed:50ms", "uniform:10ms-200ms", "normal:100ms,30ms" and "exp:80ms".
+func ParseLatency(spec string) (LatencyDistribution, error) {
+	if spec == "" || spec == "none" {
+		return noLatency{}, nil
+	}
+
+	kind, args, ok := strings.Cut(spec, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid latency %q: expected <kind>:<args>", spec)
+	}
+
+	switch kind {
+	case "fixed":
+		d, err := parsePositiveDuration(args)
+		if err != nil {
+			return nil, err
+		}
+		return fixedLatency{d}, nil
+	case "uniform":
+		lo, hi, ok := strings.Cut(args, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid uniform latency %q: expected <min>-<max>", args)
+		}
+		minD, err := parsePositiveDuration(lo)
+		if err != nil {
+			return nil, err
+		}
+		maxD, err := parsePositiveDuration(hi)
+		if err != nil {
+			return nil, err
+		}
+		if maxD < minD {
+			return nil, fmt.Errorf("invalid uniform latency %q: max is lower than min", args)
+		}
+		return uniformLatency{minD, maxD}, nil
+	case "normal":
+		mean, stddev, ok := strings.Cut(args, ",")
+		if !ok {
+			return nil, fmt.Errorf("invalid normal latency %q: expected <mean>,<stddev>", args)
+		}
+		meanD, err := parsePositiveDuration(mean)
+		if err != nil {
+			return nil, err
+		}
+		stddevD, err := parsePositiveDuration(stddev)
+		if err != nil {
+			return nil, err
+		}
+		return normalLatency{meanD, stddevD}, nil
+	case "exp":
+		d, err := parsePositiveDuration(args)
+		if err != nil {
+			return nil, err
+		}
+		return expLatency{d}, nil
+	default:
+		return nil, fmt.Errorf("unknown latency distribution %q", kind)
+	}
+}
+
+// parsePositiveDuration parses a duration and rejects negative values.
+func parsePositiveDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("duration %q must not be negative", s)
+	}
+	return d, nil
+}
+
+// dice is a goroutine-safe source of randomness shared by all requests.
+// math/rand.Rand is not safe for concurrent use, so every access goes through the mutex.
+type dice struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+// newDice creates a dice seeded with the given value so runs can be reproduced.
+func newDice(seed int64) *dice {
+	return &dice{r: rand.New(rand.NewSource(seed))}
+}
+
+// roll reports whether an event with the given probability happens.
+func (d *dice) roll(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.r.Float64() < rate
+}
+
+// latency samples a delay from the given distribution.
+func (d *dice) latency(dist LatencyDistribution) time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return dist.Sample(d.r)
+}
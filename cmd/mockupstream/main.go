@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"user_api_with_concurrency/models"
+)
+
+// Synthetic first and last names combined to build deterministic users.
+var (
+	firstNames = []string{"Frodo", "Samwise", "Gandalf", "Aragorn", "Legolas", "Gimli", "Boromir", "Éowyn", "Galadriel", "Arwen"}
+	lastNames  = []string{"Baggins", "Gamgee", "Greyhame", "Elessar", "Greenleaf", "Gloinul", "Denethorion", "Rohirrim", "Lothlórien", "Undómiel"}
+)
+
+// upstream is the HTTP handler that serves synthetic users and injects faults.
+type upstream struct {
+	users  int                                             // Number of synthetic users served (IDs 1..users).
+	faults FaultConfig                                     // Faults injected into the responses.
+	dice   *dice                                           // Shared source of randomness for fault decisions.
+	logger *log.Logger                                     // Logger for injected faults; nil disables logging.
+	sleep  func(ctx context.Context, d time.Duration) bool // Sleep that stops when the request is canceled, replaceable in tests.
+}
+
+// newUpstream creates an upstream handler with the given user count, fault configuration and seed.
+func newUpstream(users int, faults FaultConfig, seed int64) *upstream {
+	return &upstream{
+		users:  users,
+		faults: faults,
+		dice:   newDice(seed),
+		sleep:  sleepContext,
+	}
+}
+
+// syntheticUser builds the user served for the given ID.
+// The same ID always produces the same user, with ages spread across the 18-year boundary.
+func syntheticUser(id int) models.User {
+	first := firstNames[id%len(firstNames)]
+	last := lastNames[(id/len(firstNames))%len(lastNames)]
+	return models.User{
+		ID:    id,
+		Name:  first + " " + last,
+		Age:   10 + (id*7)%70,
+		Email: fmt.Sprintf("%s.%d@tolkien.com", strings.ToLower(first), id),
+	}
+}
+
+// ServeHTTP handles GET /users/{id}, applying latency and the configured faults.
+func (u *upstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Extract the user ID from the URL path.
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/users/"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	// Delay the response according to the latency distribution.
+	if d := u.dice.latency(u.faults.Latency); d > 0 {
+		if !u.sleep(r.Context(), d) {
+			return // The client went away; do not hold the handler for the rest of the delay.
+		}
+	}
+
+	// Simulate throttling by the upstream.
+	if u.dice.roll(u.faults.RateLimitRate) {
+		u.logf("user %d: injecting 429", id)
+		// Retry-After is in whole seconds: round up, so sub-second delays are not advertised as 0 (retry now).
+		seconds := max(int((u.faults.RetryAfter+time.Second-1)/time.Second), 1)
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		return
+	}
+
+	// Simulate an internal failure of the upstream.
+	if u.dice.roll(u.faults.ErrorRate) {
+		u.logf("user %d: injecting 500", id)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if id < 1 || id > u.users {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	body, err := json.Marshal(syntheticUser(id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	// Simulate a connection dropped halfway through the body.
+	// Content-Length advertises the full body so clients can detect the short read.
+	if u.dice.roll(u.faults.TruncateRate) {
+		u.logf("user %d: injecting truncated body", id)
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(body[:len(body)/2])
+		return
+	}
+
+	// Simulate a slow upstream that trickles the body a few bytes at a time.
+	if u.dice.roll(u.faults.SlowDripRate) {
+		u.logf("user %d: injecting slow drip", id)
+		u.drip(w, r, body)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// drip writes the body in small chunks, flushing and pausing between them.
+// It stops early if the client goes away.
+func (u *upstream) drip(w http.ResponseWriter, r *http.Request, body []byte) {
+	flusher, _ := w.(http.Flusher)
+	w.WriteHeader(http.StatusOK)
+
+	for len(body) > 0 {
+		n := min(u.faults.DripChunkSize, len(body))
+		if _, err := w.Write(body[:n]); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		body = body[n:]
+
+		if len(body) == 0 {
+			return
+		}
+		if !u.sleep(r.Context(), u.faults.DripInterval) {
+			return
+		}
+	}
+}
+
+// sleepContext waits for d or until ctx is done, and reports whether the whole delay elapsed.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// logf logs an injected fault when logging is enabled.
+func (u *upstream) logf(format string, args ...any) {
+	if u.logger != nil {
+		u.logger.Printf(format, args...)
+	}
+}
+
+// main is the entry point of the mock upstream server.
+// It parses the fault injection flags and serves synthetic users at /users/{id}.
+func main() {
+	fs := flag.NewFlagSet("mockupstream", flag.ExitOnError)
+	port := fs.String("port", getEnv("PORT", "4000"), "Port to listen on")
+	users := fs.Int("users", 1000, "Number of synthetic users served (IDs 1..n)")
+	latency := fs.String("latency", "none", "Latency distribution: none, fixed:50ms, uniform:10ms-200ms, normal:100ms,30ms, exp:80ms")
+	errorRate := fs.Float64("error-rate", 0, "Fraction of requests answered with 500")
+	rateLimitRate := fs.Float64("rate-limit-rate", 0, "Fraction of requests answered with 429")
+	retryAfter := fs.Duration("retry-after", time.Second, "Retry-After advertised on 429 responses")
+	truncateRate := fs.Float64("truncate-rate", 0, "Fraction of responses with a truncated body")
+	slowDripRate := fs.Float64("slow-drip-rate", 0, "Fraction of responses sent a few bytes at a time")
+	dripInterval := fs.Duration("drip-interval", 100*time.Millisecond, "Pause between chunks of a slow-drip response")
+	dripChunk := fs.Int("drip-chunk", 4, "Bytes written per chunk of a slow-drip response")
+	seed := fs.Int64("seed", time.Now().UnixNano(), "Seed for the fault injection randomness")
+	quiet := fs.Bool("quiet", false, "Do not log injected faults")
+	fs.Parse(os.Args[1:])
+
+	dist, err := ParseLatency(*latency)
+	if err != nil {
+		log.Fatalf("Invalid -latency: %v", err)
+	}
+
+	faults := FaultConfig{
+		Latency:       dist,
+		ErrorRate:     *errorRate,
+		RateLimitRate: *rateLimitRate,
+		RetryAfter:    *retryAfter,
+		TruncateRate:  *truncateRate,
+		SlowDripRate:  *slowDripRate,
+		DripInterval:  *dripInterval,
+		DripChunkSize: *dripChunk,
+	}
+	if err := faults.Validate(); err != nil {
+		log.Fatalf("Invalid fault configuration: %v", err)
+	}
+
+	handler := newUpstream(*users, faults, *seed)
+	if !*quiet {
+		handler.logger = log.Default()
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /users/{id}", handler)
+
+	log.Printf("Mock upstream serving %d users on :%s (latency=%s, seed=%d)\n", *users, *port, dist, *seed)
+	log.Fatal(http.ListenAndServe("0.0.0.0:"+*port, mux))
+}
+
+// getEnv returns the value of the environment variable or the fallback if it is not set.
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"user_api_with_concurrency/models"
+)
+
+// newTestServer starts an httptest server backed by an upstream with the given faults.
+// Sleeping is disabled so latency and slow-drip faults do not slow the tests down.
+func newTestServer(t *testing.T, faults FaultConfig) *httptest.Server {
+	if faults.Latency == nil {
+		faults.Latency = noLatency{}
+	}
+	if faults.DripChunkSize == 0 {
+		faults.DripChunkSize = 4
+	}
+	u := newUpstream(10, faults, 1)
+	u.sleep = func(context.Context, time.Duration) bool { return true }
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /users/{id}", u)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// TestUpstreamServesSyntheticUsers verifies that a healthy upstream returns deterministic users.
+func TestUpstreamServesSyntheticUsers(t *testing.T) {
+	ts := newTestServer(t, FaultConfig{})
+
+	resp, err := http.Get(ts.URL + "/users/3")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	var user models.User
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if user != syntheticUser(3) {
+		t.Errorf("Expected %+v, got %+v", syntheticUser(3), user)
+	}
+
+	// IDs outside the synthetic range must return 404.
+	resp, err = http.Get(ts.URL + "/users/11")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+// TestUpstreamInjectsStatusFaults verifies that error and rate-limit rates of 1 always fail.
+func TestUpstreamInjectsStatusFaults(t *testing.T) {
+	tests := []struct {
+		name       string
+		faults     FaultConfig
+		status     int
+		retryAfter string
+	}{
+		{"error", FaultConfig{ErrorRate: 1}, http.StatusInternalServerError, ""},
+		{"rate limit", FaultConfig{RateLimitRate: 1, RetryAfter: 2 * time.Second}, http.StatusTooManyRequests, "2"},
+		{"rate limit rounded up", FaultConfig{RateLimitRate: 1, RetryAfter: 1500 * time.Millisecond}, http.StatusTooManyRequests, "2"},
+		{"sub-second rate limit", FaultConfig{RateLimitRate: 1, RetryAfter: 200 * time.Millisecond}, http.StatusTooManyRequests, "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, tt.faults)
+
+			resp, err := http.Get(ts.URL + "/users/1")
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("Expected status code %d, got %d", tt.status, resp.StatusCode)
+			}
+			if resp.Header.Get("Retry-After") != tt.retryAfter {
+				t.Errorf("Expected Retry-After %q, got %q", tt.retryAfter, resp.Header.Get("Retry-After"))
+			}
+		})
+	}
+}
+
+// TestUpstreamInjectsBodyFaults verifies that truncated bodies fail to read and slow drips arrive intact.
+func TestUpstreamInjectsBodyFaults(t *testing.T) {
+	ts := newTestServer(t, FaultConfig{TruncateRate: 1})
+	resp, err := http.Get(ts.URL + "/users/1")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	_, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err == nil {
+		t.Error("Expected an error reading a truncated body")
+	}
+
+	ts = newTestServer(t, FaultConfig{SlowDripRate: 1, DripChunkSize: 3})
+	resp, err = http.Get(ts.URL + "/users/1")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var user models.User
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		t.Fatalf("Failed to decode slow-drip response: %v", err)
+	}
+	if user.ID != 1 {
+		t.Errorf("Expected user ID 1, got %d", user.ID)
+	}
+}
+
+// TestParseLatency verifies the supported latency specifications.
+func TestParseLatency(t *testing.T) {
+	valid := []string{"none", "fixed:50ms", "uniform:10ms-200ms", "normal:100ms,30ms", "exp:80ms"}
+	for _, spec := range valid {
+		dist, err := ParseLatency(spec)
+		if err != nil {
+			t.Errorf("ParseLatency(%q) returned error: %v", spec, err)
+			continue
+		}
+		if dist.String() != spec {
+			t.Errorf("Expected %q to round-trip, got %q", spec, dist.String())
+		}
+	}
+
+	invalid := []string{"fixed", "uniform:200ms-10ms", "normal:100ms", "gamma:1s", "fixed:-5ms"}
+	for _, spec := range invalid {
+		if _, err := ParseLatency(spec); err == nil {
+			t.Errorf("Expected ParseLatency(%q) to fail", spec)
+		}
+	}
+}
+
+// TestUpstreamLatency_Canceled verifies that a canceled request does not wait for the injected latency.
+func TestUpstreamLatency_Canceled(t *testing.T) {
+	u := newUpstream(10, FaultConfig{Latency: fixedLatency{time.Minute}, DripChunkSize: 4}, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/users/1", nil).WithContext(ctx)
+
+	start := time.Now()
+	u.ServeHTTP(httptest.NewRecorder(), req)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the handler to return when the request was canceled, took %v", elapsed)
+	}
+}
+
+// TestFaultConfigValidate verifies that invalid rates are reported in a stable order.
+func TestFaultConfigValidate(t *testing.T) {
+	faults := FaultConfig{ErrorRate: 2, RateLimitRate: -1, TruncateRate: 3, SlowDripRate: 4, DripChunkSize: 4}
+	for i := 0; i < 10; i++ {
+		if err := faults.Validate(); err == nil || !strings.HasPrefix(err.Error(), "error-rate ") {
+			t.Fatalf("Expected the error-rate to be reported first, got %v", err)
+		}
+	}
+	if err := (FaultConfig{DripChunkSize: 4}).Validate(); err != nil {
+		t.Errorf("Expected a valid configuration, got %v", err)
+	}
+}
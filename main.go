@@ -5,7 +5,9 @@ import (
 	"net/http"
 	_ "net/http/pprof" // Import for pprof (profiling) support.
 	"os"
+	"strconv"
 	"user_api_with_concurrency/api"
+	"user_api_with_concurrency/utils"
 )
 
 // main is the entry point of the application.
@@ -21,6 +23,9 @@ func main() {
 	// Set up the API routes using the SetupRoutes function from the api package.
 	api.SetupRoutes()
 
+	// Fill the store with generated users if SEED_USERS is set.
+	seedUsers()
+
 	// Get the port to listen on from the environment variable or use a default value.
 	port := getPort()
 	log.Printf("Server started on :%s\n", port)
@@ -39,3 +44,28 @@ func getPort() string {
 	}
 	return port
 }
+
+// seedUsers stores SEED_USERS generated users, using the seed SEED_USERS_SEED (default 1).
+// Invalid values are logged and ignored.
+func seedUsers() {
+	v := os.Getenv("SEED_USERS")
+	if v == "" {
+		return
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Printf("Ignoring invalid SEED_USERS %q\n", v)
+		return
+	}
+
+	seed := uint64(1)
+	if v := os.Getenv("SEED_USERS_SEED"); v != "" {
+		if seed, err = strconv.ParseUint(v, 10, 64); err != nil {
+			log.Printf("Ignoring invalid SEED_USERS_SEED %q\n", v)
+			return
+		}
+	}
+
+	api.SeedUsers(utils.GenerateUsers(n, seed))
+	log.Printf("Seeded %d users with seed %d\n", n, seed)
+}
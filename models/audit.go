@@ -0,0 +1,73 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// AuditEntry records a change of a user: who made it, through which request, and the fields it changed.
+// It is returned by GET /audit and GET /users/{id}/history.
+type AuditEntry struct {
+	Seq        int64         `json:"seq"`                   // Monotonic sequence number of the entry.
+	Time       time.Time     `json:"time"`                  // Time of the change.
+	Actor      string        `json:"actor"`                 // Caller identity from X-Actor, or "anonymous".
+	Role       string        `json:"role,omitempty"`        // Caller role from the role header.
+	RemoteAddr string        `json:"remote_addr,omitempty"` // Network address of the caller.
+	RequestID  string        `json:"request_id,omitempty"`  // X-Request-ID of the request that made the change.
+	Source     string        `json:"source"`                // Request that made the change, e.g. "PUT /users/7".
+	Op         string        `json:"op"`                    // "create", "update" or "delete".
+	UserID     int           `json:"user_id"`               // ID of the changed user.
+	Changes    []FieldChange `json:"changes"`               // Fields whose value changed.
+}
+
+// FieldChange is the value of a user field before and after a change.
+// Before is omitted for creates and After for deletes.
+type FieldChange struct {
+	Field  string `json:"field"`
+	Before any    `json:"before,omitempty"`
+	After  any    `json:"after,omitempty"`
+}
+
+// DiffUsers returns the fields that differ between two versions of a user, by JSON name and in
+// declaration order. A nil before lists every field of a created user, and a nil after every field
+// of a deleted one. Empty fields of a created or deleted user, and the ID, are left out.
+func DiffUsers(before, after *User) []FieldChange {
+	changes := []FieldChange{}
+	t := reflect.TypeOf(User{})
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "id" || name == "-" {
+			continue
+		}
+
+		var old, updated reflect.Value
+		if before != nil {
+			old = reflect.ValueOf(*before).Field(i)
+		}
+		if after != nil {
+			updated = reflect.ValueOf(*after).Field(i)
+		}
+
+		change := FieldChange{Field: name}
+		switch {
+		case before == nil:
+			if updated.IsZero() {
+				continue // Empty field of a created user.
+			}
+			change.After = updated.Interface()
+		case after == nil:
+			if old.IsZero() {
+				continue // Empty field of a deleted user.
+			}
+			change.Before = old.Interface()
+		case old.Equal(updated):
+			continue // Unchanged.
+		default:
+			change.Before, change.After = old.Interface(), updated.Interface()
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// BackupVersion is the version of the backup format written by this version of the server.
+// Restores reject backups of a newer version.
+const BackupVersion = 1
+
+// Restore modes.
+const (
+	RestoreMerge   = "merge"   // Add or replace the users of the backup, keeping the others.
+	RestoreReplace = "replace" // Make the store an exact copy of the backup.
+)
+
+// Backup is a point-in-time snapshot of the user store.
+// It is returned by GET /admin/snapshot, saved by the CLI backup command and loaded by POST /admin/restore.
+type Backup struct {
+	Version int       `json:"version"`  // Version of the backup format.
+	TakenAt time.Time `json:"taken_at"` // Time the snapshot was taken.
+	NextID  int       `json:"next_id"`  // Next ID the store would assign, so restored stores do not reuse IDs.
+	Count   int       `json:"count"`    // Number of users, to detect truncated archives.
+	Users   []User    `json:"users"`    // Users sorted by ID.
+}
+
+// RestoreReport summarizes the outcome of a restore.
+type RestoreReport struct {
+	DryRun    bool   `json:"dry_run"`   // Whether the restore only computed the changes.
+	Mode      string `json:"mode"`      // "merge" or "replace".
+	Created   int    `json:"created"`   // Users of the backup that did not exist.
+	Updated   int    `json:"updated"`   // Existing users replaced by a different user of the backup.
+	Unchanged int    `json:"unchanged"` // Existing users equal to those of the backup.
+	Deleted   int    `json:"deleted"`   // Users missing from the backup, deleted in replace mode.
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// UserEvent describes a change of the user set.
+// It is streamed by GET /users/changes and printed by the CLI watch command.
+type UserEvent struct {
+	ID   int64     `json:"id"`   // Monotonic event ID, used to resume a stream after a disconnect.
+	Type string    `json:"type"` // Event type: "create", "update" or "delete".
+	Time time.Time `json:"time"` // Time of the change.
+	User User      `json:"user"` // User after the change; for deletes, the user as it was before.
+}
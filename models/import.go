@@ -0,0 +1,17 @@
+package models
+
+// ImportReport summarizes the outcome of a user import.
+// It is returned by POST /users/import and printed by the CLI import command.
+type ImportReport struct {
+	DryRun   bool          `json:"dry_run"`  // Whether the import only validated the rows.
+	Total    int           `json:"total"`    // Number of data rows read.
+	Imported int           `json:"imported"` // Number of rows imported (or that would be imported in a dry run).
+	Failed   int           `json:"failed"`   // Number of rows rejected.
+	Errors   []ImportError `json:"errors"`   // Row-level errors, sorted by row number.
+}
+
+// ImportError describes why a single row was rejected.
+type ImportError struct {
+	Row   int    `json:"row"`   // Row number in the source file (CSV rows count the header as row 1).
+	Error string `json:"error"` // Description of the problem.
+}
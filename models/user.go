@@ -1,10 +1,73 @@
 package models
 
+import (
+	"errors"
+	"net/mail"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
 // User represents a user entity in the application.
 // It defines the structure of a user, including their ID, name, age, and email.
 type User struct {
-	ID    int    `json:"id"`    // Unique identifier for the user.
-	Name  string `json:"name"`  // Full name of the user.
-	Age   int    `json:"age"`   // Age of the user.
-	Email string `json:"email"` // Email address of the user.
+	ID     int    `json:"id"`               // Unique identifier for the user.
+	Name   string `json:"name"`             // Full name of the user.
+	Age    int    `json:"age"`              // Age of the user.
+	Email  string `json:"email"`            // Email address of the user.
+	Locale string `json:"locale,omitempty"` // Optional BCP 47 locale (e.g. "tr") used to format the user's name.
+}
+
+// Validate checks that the user has a name, a plausible age and a well-formed email address.
+// All problems found are reported together, separated by semicolons.
+func (u User) Validate() error {
+	var problems []string
+
+	if strings.TrimSpace(u.Name) == "" {
+		problems = append(problems, "name is required")
+	}
+	if u.Age < 0 || u.Age > 150 {
+		problems = append(problems, "age must be between 0 and 150")
+	}
+	if u.Email == "" {
+		problems = append(problems, "email is required")
+	} else if addr, err := mail.ParseAddress(u.Email); err != nil || addr.Address != u.Email {
+		problems = append(problems, "email is invalid")
+	}
+	if u.Locale != "" {
+		if _, err := language.Parse(u.Locale); err != nil {
+			problems = append(problems, "locale is invalid")
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// UserPatch holds the fields of a partial update (PATCH /users/{id}).
+// Fields left nil keep their stored value.
+type UserPatch struct {
+	Name   *string `json:"name,omitempty"`
+	Age    *int    `json:"age,omitempty"`
+	Email  *string `json:"email,omitempty"`
+	Locale *string `json:"locale,omitempty"`
+}
+
+// Apply returns the user with the fields set in the patch replaced.
+func (p UserPatch) Apply(u User) User {
+	if p.Name != nil {
+		u.Name = *p.Name
+	}
+	if p.Age != nil {
+		u.Age = *p.Age
+	}
+	if p.Email != nil {
+		u.Email = *p.Email
+	}
+	if p.Locale != nil {
+		u.Locale = *p.Locale
+	}
+	return u
 }
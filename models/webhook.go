@@ -0,0 +1,40 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Delivery statuses.
+const (
+	DeliveryPending   = "pending"   // Waiting for its first attempt or a retry.
+	DeliveryDelivered = "delivered" // Accepted by the receiver with a 2xx response.
+	DeliveryDead      = "dead"      // Every attempt failed; kept in the dead-letter list.
+)
+
+// Webhook is a subscription of a URL to user change events.
+// It is created by POST /webhooks; the secret is only returned by that request.
+type Webhook struct {
+	ID        int       `json:"id"`               // Unique identifier of the webhook.
+	URL       string    `json:"url"`              // HTTP(S) URL the events are posted to.
+	Events    []string  `json:"events,omitempty"` // Event types sent to the URL; empty for all types.
+	Redact    string    `json:"redact,omitempty"` // Redaction profile applied to the users sent.
+	Secret    string    `json:"secret,omitempty"` // Key of the HMAC-SHA256 signature of the deliveries.
+	CreatedAt time.Time `json:"created_at"`       // Time the webhook was created.
+}
+
+// WebhookDelivery is the delivery of an event to a webhook, listed by GET /webhooks/{id}/deliveries.
+type WebhookDelivery struct {
+	ID           int64           `json:"id"`                      // Unique identifier of the delivery.
+	WebhookID    int             `json:"webhook_id"`              // Webhook the event is delivered to.
+	EventID      int64           `json:"event_id"`                // ID of the event in the change stream.
+	EventType    string          `json:"event_type"`              // "create", "update" or "delete".
+	Payload      json.RawMessage `json:"payload"`                 // Request body posted to the webhook.
+	Status       string          `json:"status"`                  // DeliveryPending, DeliveryDelivered or DeliveryDead.
+	Attempts     int             `json:"attempts"`                // Number of attempts made.
+	NextAttempt  *time.Time      `json:"next_attempt,omitempty"`  // Time of the next attempt of a pending delivery.
+	LastAttempt  *time.Time      `json:"last_attempt,omitempty"`  // Time of the last attempt.
+	ResponseCode int             `json:"response_code,omitempty"` // HTTP status of the last attempt, if one was received.
+	LastError    string          `json:"last_error,omitempty"`    // Why the last attempt failed.
+	CreatedAt    time.Time       `json:"created_at"`              // Time the event was queued.
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+	"user_api_with_concurrency/client"
+	"user_api_with_concurrency/models"
+)
+
+// FetchOptions controls a batch fetch with FetchUsersInfo.
+type FetchOptions struct {
+	Concurrency int                                       // Maximum number of concurrent requests; defaults to MaxConcurrentFetches.
+	Timeout     time.Duration                             // Timeout of each request; 0 means no timeout.
+	Progress    func(done, total int, result FetchResult) // Called after every fetch, from the calling goroutine.
+}
+
+// FetchResult is the outcome of fetching one user.
+type FetchResult struct {
+	ID   int         // Requested user ID.
+	User models.User // Fetched user, if Err is nil.
+	Err  error       // Error of the fetch, matching client.ErrNotFound for unknown users.
+}
+
+// FetchUsersInfo fetches additional information for the given users concurrently.
+// Unlike FetchAllUsersInfo, it reports the outcome of every ID, in the order of ids,
+// and does not export the users. Cancelling ctx stops the remaining fetches.
+func FetchUsersInfo(ctx context.Context, ids []int, opts FetchOptions) []FetchResult {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = MaxConcurrentFetches
+	}
+	c := newClient()
+
+	var wg sync.WaitGroup                    // WaitGroup to wait for all goroutines to finish.
+	indexes := make(chan int)                // Positions of the IDs left to fetch.
+	done := make(chan int, len(ids))         // Positions of the IDs fetched so far.
+	results := make([]FetchResult, len(ids)) // Results, in the order of the IDs.
+
+	// Start a fixed pool of workers, which bounds the number of concurrent requests.
+	for range min(concurrency, len(ids)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = fetchUser(ctx, c, ids[i], opts.Timeout)
+				done <- i
+			}
+		}()
+	}
+
+	// Feed the workers, then close the done channel once they all finished.
+	go func() {
+		for i := range ids {
+			indexes <- i
+		}
+		close(indexes)
+		wg.Wait()
+		close(done)
+	}()
+
+	// Report the progress from this goroutine, so the callback needs no locking.
+	count := 0
+	for i := range done {
+		count++
+		if opts.Progress != nil {
+			opts.Progress(count, len(ids), results[i])
+		}
+	}
+	return results
+}
+
+// fetchUser fetches one user, bounded by the timeout if it is set.
+func fetchUser(ctx context.Context, c *client.Client, id int, timeout time.Duration) FetchResult {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	user, err := c.GetUser(ctx, id)
+	return FetchResult{ID: id, User: user, Err: err}
+}
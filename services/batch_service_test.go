@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+	"user_api_with_concurrency/client"
+	"user_api_with_concurrency/models"
+)
+
+// TestFetchUsersInfo tests that every ID gets a result in order, with failures reported per ID.
+func TestFetchUsersInfo(t *testing.T) {
+	var active, peak atomic.Int32
+
+	// Simulate the external API; odd IDs above 5 do not exist and ID 4 is slow.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := active.Add(1)
+		defer active.Add(-1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+
+		var id int
+		fmt.Sscanf(r.URL.Path, "/users/%d", &id)
+		switch {
+		case id == 4:
+			time.Sleep(200 * time.Millisecond)
+		case id > 5 && id%2 == 1:
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+		json.NewEncoder(w).Encode(models.User{ID: id, Name: fmt.Sprintf("User %d", id)})
+	}))
+	defer ts.Close()
+
+	oldURL := externalAPIURL
+	externalAPIURL = ts.URL
+	defer func() { externalAPIURL = oldURL }()
+
+	ids := []int{9, 1, 2, 3, 4, 5, 6, 7, 8}
+	var calls int
+	results := FetchUsersInfo(context.Background(), ids, FetchOptions{
+		Concurrency: 3,
+		Timeout:     100 * time.Millisecond,
+		Progress: func(done, total int, result FetchResult) {
+			calls++
+			if done != calls || total != len(ids) {
+				t.Errorf("Unexpected progress %d/%d", done, total)
+			}
+		},
+	})
+
+	if calls != len(ids) || len(results) != len(ids) {
+		t.Fatalf("Expected %d results and progress calls, got %d and %d", len(ids), len(results), calls)
+	}
+	if p := peak.Load(); p > 3 {
+		t.Errorf("Expected at most 3 concurrent requests, got %d", p)
+	}
+	for i, r := range results {
+		if r.ID != ids[i] {
+			t.Errorf("Expected result %d for ID %d, got ID %d", i, ids[i], r.ID)
+		}
+		switch {
+		case r.ID == 4:
+			if !errors.Is(r.Err, context.DeadlineExceeded) {
+				t.Errorf("Expected ID 4 to time out, got %v", r.Err)
+			}
+		case r.ID > 5 && r.ID%2 == 1:
+			if !errors.Is(r.Err, client.ErrNotFound) {
+				t.Errorf("Expected ID %d to be not found, got %v", r.ID, r.Err)
+			}
+		case r.Err != nil || r.User.ID != r.ID:
+			t.Errorf("Unexpected result for ID %d: %+v", r.ID, r)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+package services
+
+import (
+	"context"
+	"io"
+	"user_api_with_concurrency/models"
+)
+
+// ImportUsers uploads a CSV, TSV or JSON Lines file to the API's import endpoint.
+// It returns the row-level report produced by the server. With dryRun, rows are only validated.
+func ImportUsers(r io.Reader, format string, dryRun bool) (models.ImportReport, error) {
+	return newClient().ImportUsers(context.Background(), r, format, dryRun)
+}
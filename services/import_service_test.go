@@ -0,0 +1,51 @@
+package services
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"user_api_with_concurrency/models"
+)
+
+// TestImportUsers tests the ImportUsers function.
+// It verifies that the file is uploaded with the format and dry-run parameters and that the report is decoded.
+func TestImportUsers(t *testing.T) {
+	var gotQuery, gotBody string
+
+	// Simulate the import endpoint of the API.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.RawQuery
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+
+		json.NewEncoder(w).Encode(models.ImportReport{
+			DryRun: true, Total: 2, Imported: 1, Failed: 1,
+			Errors: []models.ImportError{{Row: 3, Error: "email is invalid"}},
+		})
+	}))
+	defer ts.Close() // Ensure the test server is closed after the test.
+
+	// Replace the external API URL with the test server URL.
+	oldURL := externalAPIURL
+	externalAPIURL = ts.URL
+	defer func() { externalAPIURL = oldURL }() // Restore the original URL after the test.
+
+	file := "name,age,email\nFrodo,50,frodo@tolkien.com\nSam,38,not-an-email\n"
+	report, err := ImportUsers(strings.NewReader(file), "csv", true)
+	if err != nil {
+		t.Fatalf("ImportUsers failed: %v", err)
+	}
+
+	if gotQuery != "dry_run=true&format=csv" {
+		t.Errorf("Unexpected query %q", gotQuery)
+	}
+	if gotBody != file {
+		t.Errorf("Expected the file to be uploaded as the body, got %q", gotBody)
+	}
+	if report.Imported != 1 || report.Failed != 1 || report.Errors[0].Row != 3 {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"sync"
+	"user_api_with_concurrency/client"
 	"user_api_with_concurrency/models"
 	"user_api_with_concurrency/utils"
 )
@@ -14,6 +15,7 @@ const MaxConcurrentFetches = 5 // Global limit of competition
 
 var (
 	externalAPIURL string // Stores the URL of the external API.
+	apiToken       string // Bearer token sent to the API; empty sends none.
 )
 
 // init initializes the externalAPIURL variable.
@@ -26,6 +28,22 @@ func init() {
 	}
 }
 
+// Configure overrides the API URL read from EXTERNAL_API_URL and sets the bearer token
+// sent with every request, e.g. from the CLI's settings. It is meant to be called at startup.
+func Configure(apiURL, token string) {
+	externalAPIURL = apiURL
+	apiToken = token
+}
+
+// newClient returns a client for the API, authenticated with the configured token.
+func newClient() *client.Client {
+	c := client.New(externalAPIURL)
+	if apiToken != "" {
+		c.Header.Set("Authorization", "Bearer "+apiToken)
+	}
+	return c
+}
+
 // FetchAdditionalInfo fetches additional information for a specific user from the external API.
 // It is designed to be run as a goroutine and uses a semaphore to limit concurrency.
 func FetchAdditionalInfo(userID int, wg *sync.WaitGroup, results chan<- models.User, semaphore chan struct{}) {
@@ -33,7 +51,15 @@ func FetchAdditionalInfo(userID int, wg *sync.WaitGroup, results chan<- models.U
 	defer func() { <-semaphore }() // Release the semaphore slot when done.
 
 	// Make an HTTP GET request to the external API to fetch user information.
-	resp, err := http.Get(fmt.Sprintf("%s/users/%d", externalAPIURL, userID))
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/users/%d", externalAPIURL, userID), nil)
+	if err != nil {
+		fmt.Printf("Error fetching user info for user %d: %v\n", userID, err)
+		return
+	}
+	if apiToken != "" {
+		req.Header.Set("Authorization", "Bearer "+apiToken)
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		fmt.Printf("Error fetching user info for user %d: %v\n", userID, err)
 		return
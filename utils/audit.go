@@ -0,0 +1,162 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"user_api_with_concurrency/models"
+)
+
+// AuditLog is an append-only log of user changes, stored as JSON Lines. Entries are never modified
+// or removed, and queries scan the log from the start. Without a path, the log is kept in memory.
+type AuditLog struct {
+	path   string
+	mu     sync.Mutex   // Protects the fields below.
+	file   *os.File     // Log file opened for appending; nil until the first append.
+	memory bytes.Buffer // Entries of an in-memory log.
+	size   int64        // Size of the log; queries read no further, to skip a line being written.
+	torn   bool         // The file ends with a partial line, left by a crash.
+	seq    int64        // Sequence number of the last entry.
+}
+
+// AuditQuery selects audit entries. Zero fields select all entries.
+type AuditQuery struct {
+	UserID int       // Entries of this user.
+	Actor  string    // Entries of this actor.
+	Since  time.Time // Entries at or after this time.
+	After  int64     // Entries with a higher sequence number, to page through the log.
+	Limit  int       // Maximum number of entries returned.
+}
+
+// OpenAuditLog opens the audit log at path, continuing the sequence of its entries.
+// The file is created by the first append. An empty path keeps the log in memory.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	a := &AuditLog{path: path}
+	if path == "" {
+		return a, nil
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Find the last sequence number and the size of the log, and whether it ends with a partial line.
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		a.size += int64(len(line))
+		if err == io.EOF {
+			a.torn = len(line) > 0
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var entry struct{ Seq int64 }
+		if json.Unmarshal(line, &entry) == nil {
+			a.seq = max(a.seq, entry.Seq)
+		}
+	}
+	return a, nil
+}
+
+// Append records an entry, assigning its sequence number and, if unset, its time.
+// It is meant to be called while the store is locked, so entries follow the order of the changes.
+func (a *AuditLog) Append(entry models.AuditEntry) (models.AuditEntry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	entry.Seq = a.seq + 1
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return entry, err
+	}
+	data = append(data, '\n')
+
+	if a.path == "" {
+		a.memory.Write(data)
+		a.size += int64(len(data))
+	} else {
+		if a.file == nil {
+			if err := os.MkdirAll(filepath.Dir(a.path), 0o755); err != nil {
+				return entry, err
+			}
+			// The log holds personal data, so it is only readable by its owner.
+			file, err := os.OpenFile(a.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+			if err != nil {
+				return entry, err
+			}
+			a.file = file
+		}
+		if a.torn {
+			data = append([]byte{'\n'}, data...) // End the partial line, so the entry starts a new one.
+		}
+		n, err := a.file.Write(data)
+		a.size += int64(n)
+		if err != nil {
+			a.torn = a.torn || n > 0
+			return entry, err
+		}
+		a.torn = false
+	}
+
+	a.seq = entry.Seq
+	return entry, nil
+}
+
+// Query returns the entries selected by q, oldest first. Entries appended during the query are
+// not returned, and lines that cannot be decoded are skipped.
+func (a *AuditLog) Query(q AuditQuery) ([]models.AuditEntry, error) {
+	a.mu.Lock()
+	size := a.size
+	var r io.Reader
+	if a.path == "" {
+		r = bytes.NewReader(bytes.Clone(a.memory.Bytes()))
+	}
+	a.mu.Unlock()
+
+	if r == nil {
+		file, err := os.Open(a.path)
+		if errors.Is(err, os.ErrNotExist) {
+			return []models.AuditEntry{}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		r = io.LimitReader(file, size) // Skip the line of an append in progress.
+	}
+
+	entries := []models.AuditEntry{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	for scanner.Scan() {
+		var entry models.AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if entry.Seq <= q.After || entry.Time.Before(q.Since) ||
+			q.UserID != 0 && entry.UserID != q.UserID || q.Actor != "" && entry.Actor != q.Actor {
+			continue
+		}
+		entries = append(entries, entry)
+		if q.Limit > 0 && len(entries) == q.Limit {
+			break
+		}
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+	"user_api_with_concurrency/models"
+)
+
+// TestAuditLog tests that entries are numbered, persisted and filtered, and that a reopened log
+// continues the sequence after a partial line left by a crash.
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit", "audit.jsonl")
+	log, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().UTC().Add(-time.Second)
+	for _, e := range []models.AuditEntry{
+		{Actor: "alice", Op: OpCreate, UserID: 1},
+		{Actor: "bob", Op: OpUpdate, UserID: 1, Time: start.Add(-time.Hour)},
+		{Actor: "alice", Op: OpCreate, UserID: 2},
+	} {
+		if _, err := log.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query    AuditQuery
+		expected []int64
+	}{
+		{AuditQuery{}, []int64{1, 2, 3}},
+		{AuditQuery{UserID: 1}, []int64{1, 2}},
+		{AuditQuery{Actor: "alice"}, []int64{1, 3}},
+		{AuditQuery{Since: start}, []int64{1, 3}},
+		{AuditQuery{After: 1, Limit: 1}, []int64{2}},
+	}
+	for _, tt := range tests {
+		entries, err := log.Query(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var seqs []int64
+		for _, e := range entries {
+			seqs = append(seqs, e.Seq)
+		}
+		if !slices.Equal(seqs, tt.expected) {
+			t.Errorf("%+v: expected entries %v, got %v", tt.query, tt.expected, seqs)
+		}
+	}
+
+	// Simulate a crash in the middle of an append.
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	file.WriteString(`{"seq":4,"actor":"car`)
+	file.Close()
+
+	reopened, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := reopened.Append(models.AuditEntry{Actor: "carol", Op: OpDelete, UserID: 2})
+	if err != nil || entry.Seq != 4 {
+		t.Fatalf("Expected the sequence to continue at 4, got %d (%v)", entry.Seq, err)
+	}
+	entries, _ := reopened.Query(AuditQuery{UserID: 2})
+	if len(entries) != 2 || entries[1].Actor != "carol" || entries[1].Op != OpDelete {
+		t.Errorf("Expected the entries of user 2 to end with carol's delete, got %+v", entries)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected the log to be private, got mode %v", info.Mode())
+	}
+}
+
+// TestAuditLog_Memory tests an audit log without a file.
+func TestAuditLog_Memory(t *testing.T) {
+	log, _ := OpenAuditLog("")
+	if entries, err := log.Query(AuditQuery{}); err != nil || len(entries) != 0 {
+		t.Fatalf("Expected an empty log, got %v (%v)", entries, err)
+	}
+	log.Append(models.AuditEntry{Actor: "alice", Op: OpCreate, UserID: 1})
+	if entries, _ := log.Query(AuditQuery{Actor: "alice"}); len(entries) != 1 || entries[0].Seq != 1 {
+		t.Errorf("Unexpected entries %+v", entries)
+	}
+}
@@ -0,0 +1,287 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"user_api_with_concurrency/models"
+)
+
+// Change operations recorded in the changelog.
+const (
+	OpCreate = "create" // A user was created.
+	OpUpdate = "update" // A user was updated.
+	OpDelete = "delete" // A user was deleted, or no longer passes the export pipeline.
+)
+
+// changeFileName matches changelog segments and compacted snapshots, capturing the sequence number.
+var changeFileName = regexp.MustCompile(`^(changes|snapshot)-(\d{12})(\..+)$`)
+
+// ChangeRecord is one line of the changelog.
+type ChangeRecord struct {
+	Seq       int64     `json:"seq"`                // Monotonic sequence number of the change.
+	Op        string    `json:"op"`                 // One of OpCreate, OpUpdate or OpDelete.
+	ID        int       `json:"id"`                 // ID of the changed user.
+	Fields    Record    `json:"fields,omitempty"`   // Exported fields after the change; omitted for deletes.
+	Filtered  string    `json:"filtered,omitempty"` // Pipeline stage that dropped the user, turning the change into a delete.
+	Timestamp time.Time `json:"timestamp"`          // Time of the change.
+}
+
+// ChangeLog appends user changes to rolling JSON Lines segments and periodically compacts them
+// into a full snapshot. Consumers load the newest "snapshot-<seq>" file and apply the records of
+// the "changes-<seq>.jsonl" segments with a higher sequence number.
+type ChangeLog struct {
+	dir          string         // Directory holding segments and snapshots.
+	maxBytes     int64          // Size after which a new segment is started.
+	compactEvery int            // Number of changes between compactions (0 disables compaction).
+	opts         ExportOptions  // Resolved options for change records and snapshots.
+	mu           sync.Mutex     // Protects the fields below.
+	file         *os.File       // Current segment; nil until the next append.
+	size         int64          // Size of the current segment.
+	seq          int64          // Sequence number of the last change.
+	pending      int            // Changes since the last compaction.
+	compactMu    sync.Mutex     // Serializes compactions.
+	compactions  sync.WaitGroup // Tracks running compactions.
+}
+
+// NewChangeLog opens the changelog in dir, continuing the sequence of any existing segments.
+// The export options select the snapshot format and the pipeline applied to records and snapshots.
+func NewChangeLog(dir string, maxBytes int64, compactEvery int, opts ExportOptions) (*ChangeLog, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	opts, err := opts.resolve()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &ChangeLog{dir: dir, maxBytes: maxBytes, compactEvery: compactEvery, opts: opts}
+	if c.seq, err = c.lastSeq(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Append records a change of the user. It is meant to be called while the store is locked,
+// so sequence numbers follow the order of the mutations.
+// When a compaction is due, snapshot is called (still under the caller's lock) to copy the current users,
+// and the full snapshot is written in the background.
+func (c *ChangeLog) Append(op string, user models.User, snapshot func() []models.User) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seq++
+	record := ChangeRecord{Seq: c.seq, Op: op, ID: user.ID, Timestamp: time.Now().UTC()}
+	if op != OpDelete {
+		processed, reason, keep := c.opts.Pipeline.Process(user)
+		if keep {
+			record.Fields = c.opts.record(processed)
+		} else {
+			record.Op, record.Filtered = OpDelete, reason // The user left the export.
+		}
+	}
+
+	if err := c.write(record); err != nil {
+		return err
+	}
+
+	c.pending++
+	if c.compactEvery > 0 && c.pending >= c.compactEvery {
+		c.pending = 0
+		c.closeSegment() // Changes after the snapshot go to a new segment.
+		users, seq := snapshot(), c.seq
+		c.compactions.Add(1)
+		go func() {
+			defer c.compactions.Done()
+			if err := c.Compact(users, seq); err != nil {
+				log.Println("Failed to compact changelog:", err)
+			}
+		}()
+	}
+	return nil
+}
+
+// write appends the record to the current segment, starting a new segment when needed.
+// The caller must hold c.mu.
+func (c *ChangeLog) write(record ChangeRecord) error {
+	if c.file != nil && c.size >= c.maxBytes {
+		c.closeSegment()
+	}
+	if c.file == nil {
+		name := fmt.Sprintf("changes-%012d.jsonl", record.Seq)
+		file, err := os.OpenFile(filepath.Join(c.dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		c.file, c.size = file, 0
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	n, err := c.file.Write(append(data, '\n'))
+	c.size += int64(n)
+	return err
+}
+
+// closeSegment closes the current segment. The caller must hold c.mu.
+func (c *ChangeLog) closeSegment() {
+	if c.file != nil {
+		if err := c.file.Close(); err != nil {
+			log.Println("Failed to close changelog segment:", err)
+		}
+		c.file = nil
+	}
+}
+
+// Compact writes the users as a full snapshot reflecting every change up to seq,
+// then deletes the older snapshots and the segments fully covered by it.
+func (c *ChangeLog) Compact(users []models.User, seq int64) error {
+	c.compactMu.Lock()
+	defer c.compactMu.Unlock()
+
+	ext := "." + string(c.opts.Format)
+	if c.opts.Compress {
+		ext += ".gz"
+	}
+	name := fmt.Sprintf("snapshot-%012d%s", seq, ext)
+	path := filepath.Join(c.dir, name)
+
+	// Write the snapshot under a temporary name so consumers never see a partial snapshot.
+	tmp := filepath.Join(c.dir, ".tmp-"+name)
+	opts := c.opts
+	opts.Manifest = false
+	stats, err := ExportUsersToFile(StreamUsers(users), tmp, opts)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	manifest := NewManifest(name, opts, stats)
+	manifest.ChangeSeq = seq
+	if err := WriteManifest(ManifestPath(path), manifest); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	return c.prune(seq)
+}
+
+// prune deletes the snapshots older than seq and the segments whose changes are all included in it.
+// A segment is covered when the segment after it starts at or before seq+1.
+func (c *ChangeLog) prune(seq int64) error {
+	files, err := c.files()
+	if err != nil {
+		return err
+	}
+
+	var segments []changeFile
+	var errs []error
+	for _, f := range files {
+		if f.kind == "snapshot" && f.seq < seq {
+			for _, name := range []string{f.name, ManifestPath(f.name)} {
+				if err := os.Remove(filepath.Join(c.dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+					errs = append(errs, err)
+				}
+			}
+		}
+		if f.kind == "changes" {
+			segments = append(segments, f)
+		}
+	}
+
+	for i := 0; i+1 < len(segments) && segments[i+1].seq <= seq+1; i++ {
+		if err := os.Remove(filepath.Join(c.dir, segments[i].name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Wait blocks until the running compactions have finished.
+func (c *ChangeLog) Wait() {
+	c.compactions.Wait()
+}
+
+// Close waits for the running compactions and closes the current segment.
+func (c *ChangeLog) Close() {
+	c.Wait()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeSegment()
+}
+
+// changeFile is a segment or snapshot found in the changelog directory.
+type changeFile struct {
+	name string // File name.
+	kind string // "changes" or "snapshot".
+	seq  int64  // First sequence number of a segment, or last one included in a snapshot.
+}
+
+// files lists the segments and snapshots in the directory, sorted by sequence number.
+func (c *ChangeLog) files() ([]changeFile, error) {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []changeFile
+	for _, entry := range entries {
+		m := changeFileName.FindStringSubmatch(entry.Name())
+		if m == nil || strings.HasSuffix(entry.Name(), ManifestSuffix) {
+			continue
+		}
+		seq, _ := strconv.ParseInt(m[2], 10, 64)
+		files = append(files, changeFile{name: entry.Name(), kind: m[1], seq: seq})
+	}
+	slices.SortFunc(files, func(a, b changeFile) int { return int(a.seq - b.seq) })
+	return files, nil
+}
+
+// lastSeq returns the sequence number of the last change recorded in the directory.
+func (c *ChangeLog) lastSeq() (int64, error) {
+	files, err := c.files()
+	if err != nil {
+		return 0, err
+	}
+
+	var last int64
+	for _, f := range files {
+		if f.kind == "snapshot" {
+			last = max(last, f.seq)
+			continue
+		}
+		file, err := os.Open(filepath.Join(c.dir, f.name))
+		if err != nil {
+			return 0, err
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var record ChangeRecord
+			if json.Unmarshal(scanner.Bytes(), &record) == nil {
+				last = max(last, record.Seq)
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+	}
+	return last, nil
+}
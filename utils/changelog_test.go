@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"user_api_with_concurrency/models"
+)
+
+// readChanges reads every change record of the segments in dir, in file order.
+func readChanges(t *testing.T, dir string) []ChangeRecord {
+	matches, _ := filepath.Glob(filepath.Join(dir, "changes-*.jsonl"))
+	var records []ChangeRecord
+	for _, path := range matches {
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("Failed to open segment: %v", err)
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			// Fields is an ordered record that only marshals, so decode the other fields.
+			var r struct {
+				Seq      int64
+				Op       string
+				ID       int
+				Filtered string
+			}
+			if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+				t.Fatalf("Invalid change record %q: %v", scanner.Text(), err)
+			}
+			records = append(records, ChangeRecord{Seq: r.Seq, Op: r.Op, ID: r.ID, Filtered: r.Filtered})
+		}
+		file.Close()
+	}
+	return records
+}
+
+// TestChangeLogAppend tests that changes are appended with monotonic sequence numbers,
+// that users filtered out by the pipeline become deletes and that the sequence resumes after reopening.
+func TestChangeLogAppend(t *testing.T) {
+	dir := t.TempDir()
+	changes, err := NewChangeLog(dir, 1<<20, 0, ExportOptions{Format: FormatCSV})
+	if err != nil {
+		t.Fatalf("Failed to open changelog: %v", err)
+	}
+	noSnapshot := func() []models.User { t.Fatal("Unexpected compaction"); return nil }
+
+	changes.Append(OpCreate, models.User{ID: 1, Name: "frodo baggins", Age: 50, Email: "frodo@tolkien.com"}, noSnapshot)
+	changes.Append(OpUpdate, models.User{ID: 1, Name: "frodo baggins", Age: 16, Email: "frodo@tolkien.com"}, noSnapshot)
+	changes.Append(OpDelete, models.User{ID: 1}, noSnapshot)
+	changes.Close()
+
+	records := readChanges(t, dir)
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 records, got %d", len(records))
+	}
+	for i, r := range records {
+		if r.Seq != int64(i+1) || r.ID != 1 {
+			t.Errorf("Unexpected record %d: %+v", i, r)
+		}
+	}
+	if records[0].Op != OpCreate || records[1].Op != OpDelete || records[1].Filtered != "min_age:18" {
+		t.Errorf("Unexpected operations: %+v", records)
+	}
+
+	// Reopening continues the sequence.
+	changes, err = NewChangeLog(dir, 1<<20, 0, ExportOptions{Format: FormatCSV})
+	if err != nil {
+		t.Fatalf("Failed to reopen changelog: %v", err)
+	}
+	changes.Append(OpCreate, models.User{ID: 2, Name: "sam", Age: 38, Email: "sam@tolkien.com"}, noSnapshot)
+	changes.Close()
+
+	if records = readChanges(t, dir); records[len(records)-1].Seq != 4 {
+		t.Errorf("Expected sequence to resume at 4, got %d", records[len(records)-1].Seq)
+	}
+}
+
+// TestChangeLogCompaction tests that segments roll by size and that compaction writes a full
+// snapshot with a manifest and removes the segments it covers.
+func TestChangeLogCompaction(t *testing.T) {
+	dir := t.TempDir()
+	changes, err := NewChangeLog(dir, 1, 3, ExportOptions{Format: FormatJSONL})
+	if err != nil {
+		t.Fatalf("Failed to open changelog: %v", err)
+	}
+
+	var stored []models.User
+	snapshot := func() []models.User { return append([]models.User(nil), stored...) }
+	for id := 1; id <= 4; id++ {
+		user := models.User{ID: id, Name: "hobbit", Age: 30 + id, Email: "hobbit@tolkien.com"}
+		stored = append(stored, user)
+		if err := changes.Append(OpCreate, user, snapshot); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	changes.Close()
+
+	// The snapshot covers the first 3 changes; only the segment of the 4th remains.
+	snapshotPath := filepath.Join(dir, "snapshot-000000000003.jsonl")
+	m, err := VerifyExport(snapshotPath, "")
+	if err != nil {
+		t.Fatalf("Expected snapshot to verify: %v", err)
+	}
+	if m.Rows != 3 || m.ChangeSeq != 3 {
+		t.Errorf("Unexpected snapshot manifest: %+v", m)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	var segments []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), "changes-") {
+			segments = append(segments, e.Name())
+		}
+	}
+	if len(segments) != 1 || segments[0] != "changes-000000000004.jsonl" {
+		t.Errorf("Expected only the segment of change 4 to remain, got %v", segments)
+	}
+}
@@ -0,0 +1,183 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"user_api_with_concurrency/models"
+)
+
+// ImportFormats lists the formats that can be imported.
+var ImportFormats = []Format{FormatCSV, FormatTSV, FormatJSONL}
+
+// ImportRow is a single row read from an import file.
+// Err is set if the row could not be parsed; the row is then not validated.
+type ImportRow struct {
+	Row  int         // Line number of the row in the source file.
+	User models.User // User parsed from the row.
+	Err  error       // Parse error, if any.
+}
+
+// headerAliases maps normalized column headers to user fields.
+var headerAliases = map[string]string{
+	"id":           "id",
+	"userid":       "id",
+	"name":         "name",
+	"fullname":     "name",
+	"age":          "age",
+	"email":        "email",
+	"emailaddress": "email",
+	"mail":         "email",
+	"locale":       "locale",
+	"language":     "locale",
+}
+
+// ReadUsers parses users from an import file in the given format and sends them to a channel.
+// For CSV and TSV, the header row maps columns to user fields (case-insensitive, ignoring spaces,
+// dashes and underscores, so "Full Name" maps to name); unknown columns are ignored.
+// Header problems are returned immediately; row problems are reported in ImportRow.Err.
+func ReadUsers(r io.Reader, format Format) (<-chan ImportRow, error) {
+	switch format {
+	case FormatCSV, FormatTSV:
+		return readDelimitedUsers(r, format)
+	case FormatJSONL:
+		return readJSONLinesUsers(r), nil
+	default:
+		return nil, fmt.Errorf("cannot import format %q", format)
+	}
+}
+
+// readDelimitedUsers parses users from a CSV or TSV file with a header row.
+func readDelimitedUsers(r io.Reader, format Format) (<-chan ImportRow, error) {
+	reader := csv.NewReader(r)
+	if format == FormatTSV {
+		reader.Comma = '\t'
+	}
+	reader.FieldsPerRecord = -1 // Report short rows per row instead of failing the whole file.
+	reader.TrimLeadingSpace = true
+
+	// Read the header and map each column to a user field.
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("file is empty")
+		}
+		return nil, fmt.Errorf("invalid header: %w", err)
+	}
+	fields := make([]string, len(header))
+	seen := make(map[string]bool)
+	for i, h := range header {
+		key := strings.NewReplacer(" ", "", "_", "", "-", "").Replace(strings.ToLower(strings.TrimSpace(h)))
+		field, ok := headerAliases[key]
+		if !ok {
+			continue
+		}
+		if seen[field] {
+			return nil, fmt.Errorf("invalid header: column %q maps to %s more than once", h, field)
+		}
+		seen[field] = true
+		fields[i] = field
+	}
+	if len(seen) == 0 {
+		return nil, errors.New("invalid header: no column maps to a user field")
+	}
+
+	rows := make(chan ImportRow)
+	go func() {
+		defer close(rows) // Close the channel when done.
+		for {
+			record, err := reader.Read()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if err != nil {
+				var parseErr *csv.ParseError
+				if !errors.As(err, &parseErr) {
+					rows <- ImportRow{Err: err} // Reading failed; stop here.
+					return
+				}
+				rows <- ImportRow{Row: parseErr.StartLine, Err: parseErr.Err}
+				continue
+			}
+			line, _ := reader.FieldPos(0) // Line of the row, correct even after multi-line fields.
+			user, err := parseRecord(fields, record)
+			rows <- ImportRow{Row: line, User: user, Err: err}
+		}
+	}()
+
+	return rows, nil
+}
+
+// parseRecord converts a CSV record into a user using the column-to-field mapping.
+func parseRecord(fields, record []string) (models.User, error) {
+	var user models.User
+	for i, value := range record {
+		if i >= len(fields) {
+			break
+		}
+		value = strings.TrimSpace(value)
+		switch fields[i] {
+		case "id":
+			if value == "" {
+				continue
+			}
+			id, err := strconv.Atoi(value)
+			if err != nil || id < 0 {
+				return user, fmt.Errorf("invalid id %q", value)
+			}
+			user.ID = id
+		case "name":
+			user.Name = value
+		case "age":
+			age, err := strconv.Atoi(value)
+			if err != nil {
+				return user, fmt.Errorf("invalid age %q", value)
+			}
+			user.Age = age
+		case "email":
+			user.Email = value
+		case "locale":
+			user.Locale = value
+		}
+	}
+	return user, nil
+}
+
+// readJSONLinesUsers parses one user per line; blank lines are skipped.
+func readJSONLinesUsers(r io.Reader) <-chan ImportRow {
+	rows := make(chan ImportRow)
+	go func() {
+		defer close(rows) // Close the channel when done.
+
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024) // Allow lines up to 1 MiB.
+		row := 0
+		for scanner.Scan() {
+			row++
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+
+			var user models.User
+			if err := json.Unmarshal([]byte(line), &user); err != nil {
+				rows <- ImportRow{Row: row, Err: fmt.Errorf("invalid JSON: %w", err)}
+				continue
+			}
+			if user.ID < 0 {
+				rows <- ImportRow{Row: row, Err: fmt.Errorf("invalid id %d", user.ID)}
+				continue
+			}
+			rows <- ImportRow{Row: row, User: user}
+		}
+		if err := scanner.Err(); err != nil {
+			rows <- ImportRow{Row: row + 1, Err: err}
+		}
+	}()
+	return rows
+}
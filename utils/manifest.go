@@ -0,0 +1,206 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ManifestSchemaVersion is the version of the manifest layout written by this code.
+const ManifestSchemaVersion = 1
+
+// ManifestSuffix is appended to the name of an exported file to get the name of its manifest.
+const ManifestSuffix = ".manifest.json"
+
+// Manifest describes an exported file so consumers can check it is complete
+// and know how it was produced.
+type Manifest struct {
+	SchemaVersion int             `json:"schema_version"`       // Version of the manifest layout.
+	File          string          `json:"file"`                 // Base name of the exported file.
+	Format        Format          `json:"format"`               // Format of the exported file.
+	Compressed    bool            `json:"compressed"`           // Whether the file is gzip-compressed.
+	Columns       []string        `json:"columns"`              // Columns of every record, in order.
+	Size          int64           `json:"size"`                 // Size of the file in bytes.
+	SHA256        string          `json:"sha256"`               // Hex-encoded SHA-256 checksum of the file.
+	Rows          int             `json:"rows"`                 // Number of records in the file.
+	FilteredRows  int             `json:"filtered_rows"`        // Number of users filtered out by the pipeline.
+	Filtered      map[string]int  `json:"filtered"`             // Users filtered out, by the stage that dropped them.
+	Stages        []string        `json:"stages"`               // Names of the pipeline stages, in order.
+	Pipeline      *PipelineConfig `json:"pipeline,omitempty"`   // Pipeline configuration, when known.
+	Redaction     string          `json:"redaction,omitempty"`  // Name of the redaction profile applied, if any.
+	ChangeSeq     int64           `json:"change_seq,omitempty"` // Last changelog sequence number included, for compacted snapshots.
+	GeneratedAt   time.Time       `json:"generated_at"`         // Time the export finished.
+}
+
+// ManifestPath returns the path of the manifest of an exported file.
+func ManifestPath(filename string) string {
+	return filename + ManifestSuffix
+}
+
+// NewManifest builds the manifest of a finished export from its resolved options and statistics.
+func NewManifest(file string, opts ExportOptions, stats ExportStats) Manifest {
+	m := Manifest{
+		SchemaVersion: ManifestSchemaVersion,
+		File:          file,
+		Format:        opts.Format,
+		Compressed:    opts.Compress,
+		Columns:       opts.Columns,
+		Size:          stats.Bytes,
+		SHA256:        stats.SHA256,
+		Rows:          stats.Rows,
+		Filtered:      stats.Filtered,
+		Stages:        make([]string, 0, len(opts.Pipeline)),
+		Pipeline:      opts.PipelineConfig,
+		GeneratedAt:   time.Now().UTC(),
+	}
+	if opts.Redaction != nil && !opts.Redaction.IsNoop() {
+		m.Redaction = opts.Redaction.Name
+	}
+	if m.Filtered == nil {
+		m.Filtered = map[string]int{}
+	}
+	for _, n := range m.Filtered {
+		m.FilteredRows += n
+	}
+	for _, stage := range opts.Pipeline {
+		m.Stages = append(m.Stages, stage.Name)
+	}
+	return m
+}
+
+// WriteManifest writes the manifest as indented JSON, replacing any previous manifest atomically.
+func WriteManifest(path string, m Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
+
+// ReadManifest reads a manifest written by WriteManifest.
+func ReadManifest(path string) (Manifest, error) {
+	var m Manifest
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("invalid manifest %s: %w", path, err)
+	}
+	if m.SchemaVersion < 1 || m.SchemaVersion > ManifestSchemaVersion {
+		return m, fmt.Errorf("unsupported manifest schema version %d", m.SchemaVersion)
+	}
+	return m, nil
+}
+
+// VerifyExport checks an exported file against its manifest.
+// If manifestPath is empty, the manifest next to the file is used. The size, the SHA-256 checksum
+// and the number of records are compared; every mismatch is reported in the returned error.
+func VerifyExport(filename, manifestPath string) (Manifest, error) {
+	if manifestPath == "" {
+		manifestPath = ManifestPath(filename)
+	}
+	m, err := ReadManifest(manifestPath)
+	if err != nil {
+		return m, err
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return m, err
+	}
+
+	var problems []error
+	if name := filepath.Base(filename); name != m.File {
+		problems = append(problems, fmt.Errorf("manifest describes %s, not %s", m.File, name))
+	}
+	if size := int64(len(data)); size != m.Size {
+		problems = append(problems, fmt.Errorf("size is %d bytes, manifest says %d", size, m.Size))
+	}
+	sum := sha256.Sum256(data)
+	if checksum := hex.EncodeToString(sum[:]); checksum != m.SHA256 {
+		problems = append(problems, fmt.Errorf("sha256 is %s, manifest says %s", checksum, m.SHA256))
+	}
+
+	rows, err := countRows(bytes.NewReader(data), m.Format, m.Compressed)
+	if err != nil {
+		problems = append(problems, fmt.Errorf("cannot count rows: %w", err))
+	} else if rows != m.Rows {
+		problems = append(problems, fmt.Errorf("file has %d rows, manifest says %d", rows, m.Rows))
+	}
+
+	return m, errors.Join(problems...)
+}
+
+// countRows counts the records of an exported file, excluding headers.
+func countRows(r io.Reader, format Format, compressed bool) (int, error) {
+	if compressed {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return 0, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	switch format {
+	case FormatCSV, FormatTSV:
+		reader := csv.NewReader(r)
+		if format == FormatTSV {
+			reader.Comma = '\t'
+		}
+		rows := -1 // Do not count the header.
+		for {
+			_, err := reader.Read()
+			if errors.Is(err, io.EOF) {
+				return max(rows, 0), nil
+			}
+			if err != nil {
+				return 0, err
+			}
+			rows++
+		}
+	case FormatJSONL:
+		rows := 0
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			if len(bytes.TrimSpace(scanner.Bytes())) > 0 {
+				rows++
+			}
+		}
+		return rows, scanner.Err()
+	case FormatJSON:
+		var records []json.RawMessage
+		err := json.NewDecoder(r).Decode(&records)
+		return len(records), err
+	case FormatXML:
+		rows := 0
+		decoder := xml.NewDecoder(r)
+		for {
+			token, err := decoder.Token()
+			if errors.Is(err, io.EOF) {
+				return rows, nil
+			}
+			if err != nil {
+				return 0, err
+			}
+			if start, ok := token.(xml.StartElement); ok && start.Name.Local == "user" {
+				rows++
+			}
+		}
+	default:
+		return 0, fmt.Errorf("unsupported format %q", format)
+	}
+}
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestExportManifest tests that a file export writes a manifest that verifies,
+// and that tampering with the file is detected.
+func TestExportManifest(t *testing.T) {
+	for _, format := range Formats {
+		t.Run(string(format), func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "users."+string(format)+".gz")
+
+			stats, err := ExportUsersToFile(StreamUsers(testUsers()), filename, ExportOptions{Manifest: true})
+			if err != nil {
+				t.Fatalf("Export failed: %v", err)
+			}
+
+			m, err := VerifyExport(filename, "")
+			if err != nil {
+				t.Fatalf("Expected export to verify, got: %v", err)
+			}
+			if m.Rows != 2 || m.FilteredRows != 1 || m.Filtered["min_age:18"] != 1 || m.SHA256 != stats.SHA256 {
+				t.Errorf("Unexpected manifest: %+v", m)
+			}
+			if m.Pipeline == nil || m.Pipeline.MinAge != 18 || !m.Compressed || m.Format != format {
+				t.Errorf("Unexpected manifest configuration: %+v", m)
+			}
+
+			// Append garbage to the file and verify again.
+			file, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0)
+			if err != nil {
+				t.Fatalf("Failed to open export: %v", err)
+			}
+			file.WriteString("tampered")
+			file.Close()
+
+			_, err = VerifyExport(filename, "")
+			if err == nil || !strings.Contains(err.Error(), "sha256") || !strings.Contains(err.Error(), "size") {
+				t.Errorf("Expected size and checksum mismatches, got: %v", err)
+			}
+		})
+	}
+}
+
+// TestSnapshotManifest tests that snapshot manifests are stored next to the snapshot
+// without being listed as snapshots themselves.
+func TestSnapshotManifest(t *testing.T) {
+	store, err := NewSnapshotStore(t.TempDir(), "users", 0, 0)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	old := snapshotStore
+	snapshotStore = store
+	defer func() { snapshotStore = old }()
+
+	taken := testUsers()
+	writeSnapshot(store.now(), StreamUsers(taken))
+
+	snapshots, err := store.List()
+	if err != nil || len(snapshots) != 1 {
+		t.Fatalf("Expected 1 snapshot, got %+v (%v)", snapshots, err)
+	}
+
+	m, err := VerifyExport(filepath.Join(store.dir, snapshots[0].Name), "")
+	if err != nil {
+		t.Fatalf("Expected snapshot to verify, got: %v", err)
+	}
+	if m.File != snapshots[0].Name || m.Rows != 2 {
+		t.Errorf("Unexpected manifest: %+v", m)
+	}
+
+	file, _, err := store.Open(snapshots[0].Name + ManifestSuffix)
+	if err != nil {
+		t.Fatalf("Failed to open manifest: %v", err)
+	}
+	file.Close()
+}
@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+	"user_api_with_concurrency/models"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+// NameCasings lists the supported name casings.
+// "none" only collapses whitespace; "preserve" leaves names exactly as stored.
+var NameCasings = []string{"title", "upper", "lower", "none", "preserve"}
+
+// DefaultNameParticles lists the surname particles kept in lower case when they are not the first word,
+// as in "Ludwig van Beethoven" or "Leonardo da Vinci".
+var DefaultNameParticles = []string{
+	"bin", "da", "das", "de", "del", "della", "der", "di", "do", "dos", "du",
+	"ibn", "la", "le", "ten", "ter", "van", "von", "zu",
+}
+
+// NameOptions configures how names are normalized.
+type NameOptions struct {
+	Casing     string       // One of NameCasings.
+	Language   language.Tag // Language used for casing users without a locale.
+	Particles  []string     // Lower-case particles kept in lower case after the first word.
+	UserLocale bool         // Case each name in the user's own locale when it has one.
+}
+
+// NormalizeNames returns a stage that trims and collapses whitespace in names and applies the casing.
+// Title casing is name-aware: particles stay in lower case, mixed-case words such as "McDonald" or
+// "DeShawn" are kept as written, and letters after "O'" or "D'" and "Mc" are capitalized.
+// Casers are created per stage because cases.Caser must not be shared between goroutines.
+func NormalizeNames(opts NameOptions) (Stage, error) {
+	if !slices.Contains(NameCasings, opts.Casing) {
+		return Stage{}, fmt.Errorf("unknown name casing %q", opts.Casing)
+	}
+	if opts.Casing == "preserve" {
+		return Stage{
+			Name:  "normalize_names:preserve",
+			Apply: func(u models.User) (models.User, bool) { return u, true },
+		}, nil
+	}
+
+	// Casers keep state between calls, and the stage is shared by concurrent exports, so they are
+	// only used under mu.
+	var mu sync.Mutex
+	casers := make(map[language.Tag]*nameCaser) // Casers by language, created on demand.
+	caserFor := func(u models.User) *nameCaser {
+		tag := opts.Language
+		if opts.UserLocale && u.Locale != "" {
+			if t, err := language.Parse(u.Locale); err == nil {
+				tag = t // The user's own locale takes precedence.
+			}
+		}
+		c, ok := casers[tag]
+		if !ok {
+			c = newNameCaser(tag, opts.Particles)
+			casers[tag] = c
+		}
+		return c
+	}
+
+	return Stage{
+		Name: "normalize_names:" + opts.Casing,
+		Apply: func(u models.User) (models.User, bool) {
+			u.Name = strings.Join(strings.Fields(u.Name), " ") // Trim and collapse whitespace.
+			mu.Lock()
+			defer mu.Unlock()
+			switch opts.Casing {
+			case "title":
+				u.Name = caserFor(u).title(u.Name)
+			case "upper":
+				u.Name = caserFor(u).upper.String(u.Name)
+			case "lower":
+				u.Name = caserFor(u).lower.String(u.Name)
+			}
+			return u, true
+		},
+	}, nil
+}
+
+// nameCaser applies name-aware casing rules for one language.
+type nameCaser struct {
+	titleCaser cases.Caser
+	upper      cases.Caser
+	lower      cases.Caser
+	particles  []string
+}
+
+// newNameCaser creates the casers for the language.
+func newNameCaser(tag language.Tag, particles []string) *nameCaser {
+	return &nameCaser{
+		titleCaser: cases.Title(tag),
+		upper:      cases.Upper(tag),
+		lower:      cases.Lower(tag),
+		particles:  particles,
+	}
+}
+
+// title formats a name word by word.
+func (c *nameCaser) title(name string) string {
+	words := strings.Split(name, " ")
+	for i, word := range words {
+		switch {
+		case i > 0 && slices.Contains(c.particles, c.lower.String(word)):
+			words[i] = c.lower.String(word) // "Van Der Berg" -> "van der Berg".
+		case isMixedCase(word):
+			// Keep deliberate capitalization such as "McDonald" or "DeShawn".
+		default:
+			words[i] = c.fixPrefixes(c.titleCaser.String(word))
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// fixPrefixes capitalizes the letter following an "O'"-style or "Mc" prefix of a title-cased word,
+// e.g. "O'neill" -> "O'Neill", "D'angelo" -> "D'Angelo", "Mcdonald" -> "McDonald".
+func (c *nameCaser) fixPrefixes(word string) string {
+	runes := []rune(word)
+	at := -1
+	switch {
+	case len(runes) > 2 && (runes[1] == '\'' || runes[1] == '’'):
+		at = 2
+	case len(runes) > 2 && runes[0] == 'M' && runes[1] == 'c':
+		at = 2
+	}
+	if at < 0 || !unicode.IsLetter(runes[at]) {
+		return word
+	}
+	return string(runes[:at]) + c.upper.String(string(runes[at])) + string(runes[at+1:])
+}
+
+// isMixedCase reports whether the word has an upper-case letter after its first letter
+// and at least one lower-case letter, i.e. it was capitalized deliberately.
+func isMixedCase(word string) bool {
+	hasLower, innerUpper := false, false
+	for i, r := range []rune(word) {
+		if unicode.IsLower(r) {
+			hasLower = true
+		}
+		if i > 0 && unicode.IsUpper(r) {
+			innerUpper = true
+		}
+	}
+	return hasLower && innerUpper
+}
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"testing"
+	"user_api_with_concurrency/models"
+
+	"golang.org/x/text/language"
+)
+
+// TestNormalizeNamesTitle tests the name-aware title casing.
+// It covers surname particles, apostrophe and "Mc" prefixes, deliberate mixed case and Turkish dotted i.
+func TestNormalizeNamesTitle(t *testing.T) {
+	stage, err := NormalizeNames(NameOptions{
+		Casing:     "title",
+		Language:   language.English,
+		Particles:  DefaultNameParticles,
+		UserLocale: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create stage: %v", err)
+	}
+
+	tests := []struct {
+		user     models.User
+		expected string
+	}{
+		{models.User{Name: "erick rettozi"}, "Erick Rettozi"},
+		{models.User{Name: "VAN DER BERG"}, "Van der Berg"},
+		{models.User{Name: "ludwig van beethoven"}, "Ludwig van Beethoven"},
+		{models.User{Name: "leonardo DA vinci"}, "Leonardo da Vinci"},
+		{models.User{Name: "shane o'neill"}, "Shane O'Neill"},
+		{models.User{Name: "D’ANGELO"}, "D’Angelo"},
+		{models.User{Name: "ronald mcdonald"}, "Ronald McDonald"},
+		{models.User{Name: "DeShawn MacKenzie"}, "DeShawn MacKenzie"},
+		{models.User{Name: "jean-luc picard"}, "Jean-Luc Picard"},
+		{models.User{Name: "ilker ışık", Locale: "tr"}, "İlker Işık"},
+		{models.User{Name: "ilker ışık"}, "Ilker Işık"},
+	}
+
+	for _, tt := range tests {
+		user, keep := stage.Apply(tt.user)
+		if !keep {
+			t.Errorf("Expected %q to be kept", tt.user.Name)
+		}
+		if user.Name != tt.expected {
+			t.Errorf("Title(%q, locale %q) = %q, expected %q", tt.user.Name, tt.user.Locale, user.Name, tt.expected)
+		}
+	}
+}
+
+// TestNormalizeNamesOptions tests the per-export language, ignoring user locales and preserving casing.
+func TestNormalizeNamesOptions(t *testing.T) {
+	// A per-export Turkish language applies to users without a locale.
+	config := DefaultPipelineConfig()
+	config.Language = "tr"
+	config.NameCase = "upper"
+	p, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build pipeline: %v", err)
+	}
+	if user, _, _ := p.Process(models.User{Name: "ilker", Age: 30}); user.Name != "İLKER" {
+		t.Errorf("Expected Turkish upper casing, got %q", user.Name)
+	}
+
+	// Ignoring user locales cases every name in the export language.
+	config.IgnoreLocale = true
+	config.Language = "en"
+	p, _ = config.Build()
+	if user, _, _ := p.Process(models.User{Name: "ilker", Age: 30, Locale: "tr"}); user.Name != "ILKER" {
+		t.Errorf("Expected English upper casing, got %q", user.Name)
+	}
+
+	// Preserving casing leaves the name untouched.
+	config.NameCase = "preserve"
+	p, _ = config.Build()
+	if user, _, _ := p.Process(models.User{Name: " mcDONALD  ", Age: 30}); user.Name != " mcDONALD  " {
+		t.Errorf("Expected name to be preserved, got %q", user.Name)
+	}
+}
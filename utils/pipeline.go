@@ -0,0 +1,312 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"user_api_with_concurrency/models"
+
+	"golang.org/x/text/language"
+)
+
+// Stage is a single step of the data-processing pipeline.
+// Apply either returns the (possibly transformed) user and true, or false to filter the user out.
+type Stage struct {
+	Name  string                                     // Name identifies the stage, e.g. "min_age:18".
+	Apply func(user models.User) (models.User, bool) // Apply transforms or filters a single user.
+}
+
+// Pipeline is an ordered list of stages applied to every exported user.
+type Pipeline []Stage
+
+// Process runs the user through every stage of the pipeline.
+// If a stage filters the user out, it returns false along with the name of that stage.
+func (p Pipeline) Process(user models.User) (models.User, string, bool) {
+	for _, stage := range p {
+		var keep bool
+		if user, keep = stage.Apply(user); !keep {
+			return user, stage.Name, false
+		}
+	}
+	return user, "", true
+}
+
+// MinAge returns a stage that filters out users younger than age.
+func MinAge(age int) Stage {
+	return Stage{
+		Name:  "min_age:" + strconv.Itoa(age),
+		Apply: func(u models.User) (models.User, bool) { return u, u.Age >= age },
+	}
+}
+
+// MaxAge returns a stage that filters out users older than age.
+func MaxAge(age int) Stage {
+	return Stage{
+		Name:  "max_age:" + strconv.Itoa(age),
+		Apply: func(u models.User) (models.User, bool) { return u, u.Age <= age },
+	}
+}
+
+// AllowEmailDomains returns a stage that keeps only users whose email domain is in the list.
+// Domains are matched case-insensitively, and subdomains of an allowed domain are allowed too.
+func AllowEmailDomains(domains ...string) Stage {
+	return Stage{
+		Name:  "allow_domains",
+		Apply: func(u models.User) (models.User, bool) { return u, matchesDomain(u.Email, domains) },
+	}
+}
+
+// DenyEmailDomains returns a stage that filters out users whose email domain is in the list.
+func DenyEmailDomains(domains ...string) Stage {
+	return Stage{
+		Name:  "deny_domains",
+		Apply: func(u models.User) (models.User, bool) { return u, !matchesDomain(u.Email, domains) },
+	}
+}
+
+// matchesDomain reports whether the domain of the email is one of the domains or a subdomain of one.
+func matchesDomain(email string, domains []string) bool {
+	_, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return false
+	}
+	domain = strings.ToLower(domain)
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimPrefix(d, "@"))
+		if domain == d || strings.HasSuffix(domain, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// MaskFields returns a stage that masks the given fields ("name" and/or "email").
+// Names keep the first letter of every word; emails keep the first letter of the local part and the domain.
+func MaskFields(fields ...string) (Stage, error) {
+	for _, f := range fields {
+		if f != "name" && f != "email" {
+			return Stage{}, fmt.Errorf("cannot mask unknown field %q", f)
+		}
+	}
+
+	return Stage{
+		Name: "mask:" + strings.Join(fields, ","),
+		Apply: func(u models.User) (models.User, bool) {
+			if slices.Contains(fields, "name") {
+				words := strings.Fields(u.Name)
+				for i, w := range words {
+					words[i] = maskString(w)
+				}
+				u.Name = strings.Join(words, " ")
+			}
+			if slices.Contains(fields, "email") {
+				u.Email = MaskEmail(u.Email)
+			}
+			return u, true
+		},
+	}, nil
+}
+
+// MaskEmail replaces all but the first character of the local part of an email with asterisks.
+func MaskEmail(email string) string {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return maskString(email)
+	}
+	return maskString(local) + "@" + domain
+}
+
+// maskString keeps the first rune of s and replaces the rest with asterisks.
+func maskString(s string) string {
+	runes := []rune(s)
+	if len(runes) <= 1 {
+		return s
+	}
+	return string(runes[0]) + strings.Repeat("*", len(runes)-1)
+}
+
+// PipelineConfig is the serializable description of a pipeline.
+// It can be loaded from a JSON file or from request parameters and built into a Pipeline.
+type PipelineConfig struct {
+	MinAge       int      `json:"min_age"`                      // Minimum age to keep (0 disables the filter).
+	MaxAge       int      `json:"max_age,omitempty"`            // Maximum age to keep (0 disables the filter).
+	AllowDomains []string `json:"allow_domains,omitempty"`      // Email domains to keep; empty keeps all.
+	DenyDomains  []string `json:"deny_domains,omitempty"`       // Email domains to filter out.
+	NameCase     string   `json:"name_case"`                    // Name casing: "title", "upper", "lower", "none" or "preserve".
+	Language     string   `json:"language"`                     // BCP 47 language used for name casing.
+	Particles    []string `json:"name_particles,omitempty"`     // Surname particles kept in lower case; defaults to DefaultNameParticles.
+	IgnoreLocale bool     `json:"ignore_user_locale,omitempty"` // Case every name in Language, ignoring the users' locales.
+	Mask         []string `json:"mask,omitempty"`               // Fields to mask: "name" and/or "email".
+	Redact       string   `json:"redact,omitempty"`             // Redaction profile applied to exported records.
+}
+
+// DefaultPipelineConfig returns the historical behavior of the export:
+// keep users aged 18 or older and format their names in English title case.
+func DefaultPipelineConfig() PipelineConfig {
+	return PipelineConfig{MinAge: 18, NameCase: "title", Language: "en"}
+}
+
+// Build validates the configuration and assembles the corresponding pipeline.
+// Filters run before transforms so masked values never influence filtering.
+func (c PipelineConfig) Build() (Pipeline, error) {
+	var p Pipeline
+
+	if c.MinAge > 0 {
+		p = append(p, MinAge(c.MinAge))
+	}
+	if c.MaxAge > 0 {
+		if c.MaxAge < c.MinAge {
+			return nil, fmt.Errorf("max_age %d is lower than min_age %d", c.MaxAge, c.MinAge)
+		}
+		p = append(p, MaxAge(c.MaxAge))
+	}
+	if len(c.AllowDomains) > 0 {
+		p = append(p, AllowEmailDomains(c.AllowDomains...))
+	}
+	if len(c.DenyDomains) > 0 {
+		p = append(p, DenyEmailDomains(c.DenyDomains...))
+	}
+
+	tag := language.English
+	if c.Language != "" {
+		var err error
+		if tag, err = language.Parse(c.Language); err != nil {
+			return nil, fmt.Errorf("invalid language %q: %w", c.Language, err)
+		}
+	}
+	particles := c.Particles
+	if particles == nil {
+		particles = DefaultNameParticles
+	}
+	names, err := NormalizeNames(NameOptions{
+		Casing:     c.NameCase,
+		Language:   tag,
+		Particles:  particles,
+		UserLocale: !c.IgnoreLocale,
+	})
+	if err != nil {
+		return nil, err
+	}
+	p = append(p, names)
+
+	if len(c.Mask) > 0 {
+		mask, err := MaskFields(c.Mask...)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, mask)
+	}
+
+	return p, nil
+}
+
+// LoadPipelineConfig reads a JSON pipeline configuration from a file.
+// Fields missing from the file keep their default values.
+func LoadPipelineConfig(path string) (PipelineConfig, error) {
+	config := DefaultPipelineConfig()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("invalid pipeline config %s: %w", path, err)
+	}
+	if _, err := config.Build(); err != nil {
+		return config, fmt.Errorf("invalid pipeline config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// PipelineConfigFromParams overrides the base configuration with request parameters.
+// Supported parameters are min_age, max_age, allow_domains, deny_domains, name_case, language,
+// name_particles, ignore_user_locale and mask; list parameters are comma-separated.
+func PipelineConfigFromParams(base PipelineConfig, params url.Values) (PipelineConfig, error) {
+	config := base
+
+	for _, key := range []string{"min_age", "max_age"} {
+		if !params.Has(key) {
+			continue
+		}
+		n, err := strconv.Atoi(params.Get(key))
+		if err != nil || n < 0 {
+			return config, fmt.Errorf("invalid %s %q", key, params.Get(key))
+		}
+		if key == "min_age" {
+			config.MinAge = n
+		} else {
+			config.MaxAge = n
+		}
+	}
+
+	if params.Has("allow_domains") {
+		config.AllowDomains = splitList(params.Get("allow_domains"))
+	}
+	if params.Has("deny_domains") {
+		config.DenyDomains = splitList(params.Get("deny_domains"))
+	}
+	if params.Has("name_case") {
+		config.NameCase = params.Get("name_case")
+	}
+	if params.Has("language") {
+		config.Language = params.Get("language")
+	}
+	if params.Has("name_particles") {
+		config.Particles = splitList(params.Get("name_particles"))
+	}
+	if params.Has("ignore_user_locale") {
+		ignore, err := strconv.ParseBool(params.Get("ignore_user_locale"))
+		if err != nil {
+			return config, fmt.Errorf("invalid ignore_user_locale %q", params.Get("ignore_user_locale"))
+		}
+		config.IgnoreLocale = ignore
+	}
+	if params.Has("mask") {
+		config.Mask = splitList(params.Get("mask"))
+	}
+
+	if _, err := config.Build(); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// splitList splits a comma-separated list, trimming spaces and dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// activePipelineConfig is the pipeline configuration used when no explicit pipeline is given.
+var activePipelineConfig = DefaultPipelineConfig()
+
+// init loads the pipeline configuration from the file named by the PIPELINE_CONFIG environment variable.
+// If the variable is not set or the file is invalid, the default pipeline is used.
+func init() {
+	path := os.Getenv("PIPELINE_CONFIG")
+	if path == "" {
+		return
+	}
+
+	config, err := LoadPipelineConfig(path)
+	if err != nil {
+		log.Println("Failed to load pipeline config, using defaults:", err)
+		return
+	}
+	activePipelineConfig = config
+}
+
+// ActivePipelineConfig returns the pipeline configuration used by default for exports.
+func ActivePipelineConfig() PipelineConfig {
+	return activePipelineConfig
+}
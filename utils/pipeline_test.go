@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"net/url"
+	"testing"
+	"user_api_with_concurrency/models"
+)
+
+// TestDefaultPipeline tests that the default pipeline keeps the historical export behavior.
+// Users under 18 are filtered out and names are formatted in title case.
+func TestDefaultPipeline(t *testing.T) {
+	p, err := DefaultPipelineConfig().Build()
+	if err != nil {
+		t.Fatalf("Failed to build default pipeline: %v", err)
+	}
+
+	user, _, keep := p.Process(models.User{ID: 1, Name: "  erick   rettozi ", Age: 48, Email: "erettozi@tolkien.com"})
+	if !keep {
+		t.Fatal("Expected adult user to be kept")
+	}
+	if user.Name != "Erick Rettozi" {
+		t.Errorf("Expected name %q, got %q", "Erick Rettozi", user.Name)
+	}
+
+	_, reason, keep := p.Process(models.User{ID: 2, Name: "pippin took", Age: 17})
+	if keep {
+		t.Error("Expected minor to be filtered out")
+	}
+	if reason != "min_age:18" {
+		t.Errorf("Expected reason %q, got %q", "min_age:18", reason)
+	}
+}
+
+// TestPipelineStages tests the domain filters, age bounds and masking stages.
+func TestPipelineStages(t *testing.T) {
+	config := PipelineConfig{
+		MinAge:       21,
+		MaxAge:       60,
+		AllowDomains: []string{"tolkien.com"},
+		DenyDomains:  []string{"mordor.tolkien.com"},
+		NameCase:     "none",
+		Mask:         []string{"email"},
+	}
+	p, err := config.Build()
+	if err != nil {
+		t.Fatalf("Failed to build pipeline: %v", err)
+	}
+
+	tests := []struct {
+		user   models.User
+		keep   bool
+		reason string
+	}{
+		{models.User{Name: "Aragorn", Age: 37, Email: "aragorn@tolkien.com"}, true, ""},
+		{models.User{Name: "Frodo", Age: 50, Email: "frodo@Shire.Tolkien.com"}, true, ""},
+		{models.User{Name: "Pippin", Age: 19, Email: "pippin@tolkien.com"}, false, "min_age:21"},
+		{models.User{Name: "Gandalf", Age: 2019, Email: "gandalf@tolkien.com"}, false, "max_age:60"},
+		{models.User{Name: "Saruman", Age: 50, Email: "saruman@isengard.com"}, false, "allow_domains"},
+		{models.User{Name: "Gothmog", Age: 50, Email: "gothmog@mordor.tolkien.com"}, false, "deny_domains"},
+	}
+
+	for _, tt := range tests {
+		user, reason, keep := p.Process(tt.user)
+		if keep != tt.keep || reason != tt.reason {
+			t.Errorf("Process(%s) = (%v, %q), expected (%v, %q)", tt.user.Name, keep, reason, tt.keep, tt.reason)
+		}
+		if keep && user.Email == tt.user.Email {
+			t.Errorf("Expected email of %s to be masked, got %q", tt.user.Name, user.Email)
+		}
+	}
+
+	if got := MaskEmail("aragorn@tolkien.com"); got != "a******@tolkien.com" {
+		t.Errorf("Unexpected masked email %q", got)
+	}
+}
+
+// TestPipelineConfigFromParams tests overriding a pipeline configuration with request parameters.
+func TestPipelineConfigFromParams(t *testing.T) {
+	params := url.Values{
+		"min_age":      {"0"},
+		"deny_domains": {"mordor.com, isengard.com"},
+		"name_case":    {"upper"},
+	}
+
+	config, err := PipelineConfigFromParams(DefaultPipelineConfig(), params)
+	if err != nil {
+		t.Fatalf("Failed to parse params: %v", err)
+	}
+	if config.MinAge != 0 || config.NameCase != "upper" || len(config.DenyDomains) != 2 {
+		t.Errorf("Unexpected config: %+v", config)
+	}
+
+	// Invalid values must be rejected.
+	for _, bad := range []url.Values{{"min_age": {"-1"}}, {"name_case": {"sarcastic"}}, {"mask": {"age"}}} {
+		if _, err := PipelineConfigFromParams(DefaultPipelineConfig(), bad); err == nil {
+			t.Errorf("Expected params %v to be rejected", bad)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Redaction actions.
+const (
+	RedactMask   = "mask"   // Keep the first character of every word (or of the email local part).
+	RedactHash   = "hash"   // Replace the value with a salted HMAC-SHA256, truncated to 16 hex characters.
+	RedactDrop   = "drop"   // Remove the field entirely.
+	RedactBucket = "bucket" // Replace an age with its range, e.g. "25-34".
+)
+
+// DefaultAgeBuckets are the lower bounds of the age ranges used by the bucket action.
+var DefaultAgeBuckets = []int{18, 25, 35, 45, 55, 65}
+
+// RedactionRule redacts one field.
+type RedactionRule struct {
+	Field   string `json:"field"`             // Field to redact: "id", "name", "age", "email" or "locale".
+	Action  string `json:"action"`            // One of RedactMask, RedactHash, RedactDrop or RedactBucket.
+	Buckets []int  `json:"buckets,omitempty"` // Ascending lower bounds of the age ranges; defaults to DefaultAgeBuckets.
+}
+
+// RedactionProfile is a named set of redaction rules, applied in order.
+type RedactionProfile struct {
+	Name  string          `json:"name"`  // Name used to select the profile.
+	Rules []RedactionRule `json:"rules"` // Rules applied to every record.
+}
+
+// Built-in redaction profiles, which custom profiles may override.
+var builtinProfiles = []RedactionProfile{
+	{Name: "none"},
+	{Name: "masked", Rules: []RedactionRule{{Field: "email", Action: RedactMask}}},
+	{Name: "pseudonymized", Rules: []RedactionRule{
+		{Field: "email", Action: RedactHash},
+		{Field: "name", Action: RedactMask},
+	}},
+	{Name: "public", Rules: []RedactionRule{
+		{Field: "email", Action: RedactDrop},
+		{Field: "name", Action: RedactMask},
+		{Field: "age", Action: RedactBucket},
+	}},
+}
+
+// Redaction settings, loaded from the environment.
+var (
+	redactionProfiles = make(map[string]RedactionProfile) // Profiles by name.
+	redactionRoles    = make(map[string]string)           // Profile name by caller role.
+	redactionDefault  = "none"                            // Profile for callers without a mapped role.
+	redactionSalt     []byte                              // Key of the hash action.
+)
+
+// init loads the redaction settings.
+// REDACTION_PROFILES names a JSON file with an array of custom profiles, REDACTION_ROLES maps roles
+// to profiles ("support=masked,partner=public"), REDACTION_DEFAULT_PROFILE applies to other callers
+// (default "none") and REDACTION_SALT keys the hash action.
+func init() {
+	for _, p := range builtinProfiles {
+		redactionProfiles[p.Name] = p
+	}
+	redactionSalt = []byte(os.Getenv("REDACTION_SALT"))
+
+	if path := os.Getenv("REDACTION_PROFILES"); path != "" {
+		if err := loadRedactionProfiles(path); err != nil {
+			log.Println("Failed to load redaction profiles:", err)
+		}
+	}
+
+	for _, pair := range splitList(os.Getenv("REDACTION_ROLES")) {
+		role, profile, ok := strings.Cut(pair, "=")
+		if _, exists := redactionProfiles[strings.TrimSpace(profile)]; !ok || !exists {
+			log.Printf("Ignoring invalid REDACTION_ROLES entry %q\n", pair)
+			continue
+		}
+		redactionRoles[strings.TrimSpace(role)] = strings.TrimSpace(profile)
+	}
+
+	if name := os.Getenv("REDACTION_DEFAULT_PROFILE"); name != "" {
+		if _, exists := redactionProfiles[name]; exists {
+			redactionDefault = name
+		} else {
+			log.Printf("Ignoring unknown REDACTION_DEFAULT_PROFILE %q\n", name)
+		}
+	}
+}
+
+// loadRedactionProfiles reads custom profiles from a JSON file, validating every rule.
+func loadRedactionProfiles(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var profiles []RedactionProfile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return fmt.Errorf("invalid redaction profiles %s: %w", path, err)
+	}
+	for _, p := range profiles {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, p := range profiles {
+		redactionProfiles[p.Name] = p
+	}
+	return nil
+}
+
+// Validate checks that the profile has a name and that every rule is applicable.
+func (p RedactionProfile) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("redaction profile without a name")
+	}
+	for _, rule := range p.Rules {
+		if !slices.Contains(AvailableColumns, rule.Field) {
+			return fmt.Errorf("profile %s: unknown field %q", p.Name, rule.Field)
+		}
+		switch rule.Action {
+		case RedactMask, RedactHash, RedactDrop:
+		case RedactBucket:
+			if rule.Field != "age" {
+				return fmt.Errorf("profile %s: only age can be bucketed", p.Name)
+			}
+			if !slices.IsSorted(rule.Buckets) {
+				return fmt.Errorf("profile %s: buckets must be ascending", p.Name)
+			}
+		default:
+			return fmt.Errorf("profile %s: unknown action %q", p.Name, rule.Action)
+		}
+	}
+	return nil
+}
+
+// LookupRedactionProfile returns the profile with the given name.
+func LookupRedactionProfile(name string) (RedactionProfile, error) {
+	p, ok := redactionProfiles[name]
+	if !ok {
+		return p, fmt.Errorf("unknown redaction profile %q", name)
+	}
+	return p, nil
+}
+
+// RedactionProfileForRole returns the profile mapped to a caller role, or the default profile.
+func RedactionProfileForRole(role string) RedactionProfile {
+	name, ok := redactionRoles[role]
+	if !ok {
+		name = redactionDefault
+	}
+	return redactionProfiles[name]
+}
+
+// StrictestRedactionProfile combines the default profile with every profile mapped to a role.
+// It applies to callers whose role is unknown, so they never see more than any role would.
+func StrictestRedactionProfile() RedactionProfile {
+	names := slices.Sorted(maps.Values(redactionRoles))
+	profiles := []RedactionProfile{redactionProfiles[redactionDefault]}
+	for _, name := range slices.Compact(names) {
+		if name != redactionDefault {
+			profiles = append(profiles, redactionProfiles[name])
+		}
+	}
+	return CombineRedactionProfiles(profiles...)
+}
+
+// SetRedactionRoles replaces the mapping of caller roles to profiles and the default profile.
+// It is meant to be called during initialization, before any request is served.
+func SetRedactionRoles(roles map[string]string, defaultProfile string) error {
+	for _, name := range append(slices.Collect(maps.Values(roles)), defaultProfile) {
+		if _, err := LookupRedactionProfile(name); err != nil {
+			return err
+		}
+	}
+	redactionRoles = maps.Clone(roles)
+	redactionDefault = defaultProfile
+	return nil
+}
+
+// CombineRedactionProfiles chains profiles into one that applies all their rules in order.
+// Combining never weakens a profile, since later rules only see already-redacted values.
+func CombineRedactionProfiles(profiles ...RedactionProfile) RedactionProfile {
+	var combined RedactionProfile
+	var names []string
+	for _, p := range profiles {
+		if len(p.Rules) == 0 {
+			continue
+		}
+		names = append(names, p.Name)
+		combined.Rules = append(combined.Rules, p.Rules...)
+	}
+	combined.Name = strings.Join(names, "+")
+	if combined.Name == "" {
+		combined.Name = "none"
+	}
+	return combined
+}
+
+// IsNoop reports whether the profile leaves records unchanged.
+func (p RedactionProfile) IsNoop() bool {
+	return len(p.Rules) == 0
+}
+
+// Columns returns the columns left after the profile drops fields.
+func (p RedactionProfile) Columns(columns []string) []string {
+	return slices.DeleteFunc(slices.Clone(columns), func(c string) bool {
+		return slices.ContainsFunc(p.Rules, func(r RedactionRule) bool {
+			return r.Field == c && r.Action == RedactDrop
+		})
+	})
+}
+
+// Apply redacts a record in place and returns it. Dropped fields are removed.
+func (p RedactionProfile) Apply(record Record) Record {
+	for _, rule := range p.Rules {
+		i := slices.IndexFunc(record, func(f Field) bool { return f.Name == rule.Field })
+		if i < 0 {
+			continue // The field is not exported or was already dropped.
+		}
+		value := fmt.Sprint(record[i].Value)
+
+		switch rule.Action {
+		case RedactDrop:
+			record = slices.Delete(record, i, i+1)
+		case RedactMask:
+			if rule.Field == "email" {
+				record[i].Value = MaskEmail(value)
+			} else {
+				words := strings.Fields(value)
+				for j, w := range words {
+					words[j] = maskString(w)
+				}
+				record[i].Value = strings.Join(words, " ")
+			}
+		case RedactHash:
+			mac := hmac.New(sha256.New, redactionSalt)
+			mac.Write([]byte(value))
+			record[i].Value = hex.EncodeToString(mac.Sum(nil))[:16]
+		case RedactBucket:
+			if age, ok := record[i].Value.(int); ok {
+				record[i].Value = ageBucket(age, rule.Buckets)
+			}
+		}
+	}
+	return record
+}
+
+// ageBucket returns the range containing the age, e.g. "<18", "25-34" or "65+".
+func ageBucket(age int, bounds []int) string {
+	if len(bounds) == 0 {
+		bounds = DefaultAgeBuckets
+	}
+	if age < bounds[0] {
+		return "<" + strconv.Itoa(bounds[0])
+	}
+	for i := 1; i < len(bounds); i++ {
+		if age < bounds[i] {
+			return strconv.Itoa(bounds[i-1]) + "-" + strconv.Itoa(bounds[i]-1)
+		}
+	}
+	return strconv.Itoa(bounds[len(bounds)-1]) + "+"
+}
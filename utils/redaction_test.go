@@ -0,0 +1,161 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"user_api_with_concurrency/models"
+)
+
+// TestRedactionProfiles tests every redaction action on a record.
+func TestRedactionProfiles(t *testing.T) {
+	user := models.User{ID: 1, Name: "Jane Doe", Age: 30, Email: "jane@example.com"}
+
+	tests := []struct {
+		profile string
+		want    map[string]any
+	}{
+		{"none", map[string]any{"name": "Jane Doe", "age": 30, "email": "jane@example.com"}},
+		{"masked", map[string]any{"name": "Jane Doe", "age": 30, "email": "j***@example.com"}},
+		{"public", map[string]any{"name": "J*** D**", "age": "25-34"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.profile, func(t *testing.T) {
+			profile, err := LookupRedactionProfile(tt.profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			record := profile.Apply(NewRecord(user, profile.Columns(ExportColumns)))
+			if len(record) != len(tt.want)+1 { // Plus the ID.
+				t.Fatalf("Expected %d fields, got %+v", len(tt.want)+1, record)
+			}
+			for _, f := range record[1:] {
+				if f.Value != tt.want[f.Name] {
+					t.Errorf("Expected %s %v, got %v", f.Name, tt.want[f.Name], f.Value)
+				}
+			}
+		})
+	}
+}
+
+// TestRedactionHash tests that hashing is deterministic and depends on the salt.
+func TestRedactionHash(t *testing.T) {
+	profile, _ := LookupRedactionProfile("pseudonymized")
+	hash := func() any {
+		record := profile.Apply(NewRecord(models.User{Email: "jane@example.com"}, []string{"email"}))
+		return record[0].Value
+	}
+
+	first := hash()
+	if s, _ := first.(string); len(s) != 16 || strings.Contains(s, "jane") {
+		t.Fatalf("Unexpected hash %v", first)
+	}
+	if hash() != first {
+		t.Errorf("Expected hashing to be deterministic")
+	}
+
+	old := redactionSalt
+	redactionSalt = []byte("pepper")
+	defer func() { redactionSalt = old }()
+	if hash() == first {
+		t.Errorf("Expected the salt to change the hash")
+	}
+}
+
+// TestAgeBucket tests the age ranges, including custom bounds.
+func TestAgeBucket(t *testing.T) {
+	tests := []struct {
+		age    int
+		bounds []int
+		want   string
+	}{
+		{10, nil, "<18"},
+		{18, nil, "18-24"},
+		{64, nil, "55-64"},
+		{90, nil, "65+"},
+		{42, []int{0, 50}, "0-49"},
+	}
+	for _, tt := range tests {
+		if got := ageBucket(tt.age, tt.bounds); got != tt.want {
+			t.Errorf("ageBucket(%d, %v) = %q, want %q", tt.age, tt.bounds, got, tt.want)
+		}
+	}
+}
+
+// TestCombineRedactionProfiles tests that combined profiles apply every rule in order.
+func TestCombineRedactionProfiles(t *testing.T) {
+	masked, _ := LookupRedactionProfile("masked")
+	public, _ := LookupRedactionProfile("public")
+	none, _ := LookupRedactionProfile("none")
+
+	combined := CombineRedactionProfiles(none, masked, public)
+	if combined.Name != "masked+public" || len(combined.Rules) != 4 {
+		t.Errorf("Unexpected combined profile: %+v", combined)
+	}
+	if !CombineRedactionProfiles(none).IsNoop() {
+		t.Errorf("Expected combining no rules to be a no-op")
+	}
+	if cols := combined.Columns(ExportColumns); slices.Contains(cols, "email") {
+		t.Errorf("Expected email to be dropped, got %v", cols)
+	}
+}
+
+// TestLoadRedactionProfiles tests loading custom profiles and rejecting invalid ones.
+func TestLoadRedactionProfiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "profiles.json")
+		os.WriteFile(path, []byte(content), 0o644)
+		return path
+	}
+
+	err := loadRedactionProfiles(write(`[{"name": "custom", "rules": [{"field": "age", "action": "bucket", "buckets": [0, 40]}]}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer delete(redactionProfiles, "custom")
+	profile, err := LookupRedactionProfile("custom")
+	if err != nil || profile.Apply(NewRecord(models.User{Age: 45}, []string{"age"}))[0].Value != "40+" {
+		t.Errorf("Unexpected custom profile %+v: %v", profile, err)
+	}
+
+	for _, content := range []string{
+		`[{"name": "bad", "rules": [{"field": "name", "action": "bucket"}]}]`,
+		`[{"name": "bad", "rules": [{"field": "phone", "action": "mask"}]}]`,
+		`[{"name": "bad", "rules": [{"field": "name", "action": "shred"}]}]`,
+		`[{"rules": []}]`,
+	} {
+		if err := loadRedactionProfiles(write(content)); err == nil {
+			t.Errorf("Expected %s to be rejected", content)
+		}
+	}
+}
+
+// TestExportRedaction tests that exports apply the redaction profile of the pipeline configuration
+// and record it in the manifest.
+func TestExportRedaction(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "users.csv")
+	config := DefaultPipelineConfig()
+	config.Redact = "public"
+
+	_, err := ExportUsersToFile(StreamUsers(testUsers()), filename, ExportOptions{PipelineConfig: &config, Manifest: true})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(filename)
+	if strings.Contains(string(data), "@") || !strings.HasPrefix(string(data), "ID,Name,Age\n") {
+		t.Errorf("Expected emails to be dropped, got:\n%s", data)
+	}
+	m, err := VerifyExport(filename, "")
+	if err != nil || m.Redaction != "public" || slices.Contains(m.Columns, "email") {
+		t.Errorf("Unexpected manifest %+v: %v", m, err)
+	}
+
+	config.Redact = "unknown"
+	if _, err := ExportUsersToFile(StreamUsers(testUsers()), filename, ExportOptions{PipelineConfig: &config}); err == nil {
+		t.Errorf("Expected an unknown profile to fail")
+	}
+}
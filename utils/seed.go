@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"unicode"
+	"user_api_with_concurrency/models"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// seedNames lists first and last names by locale, used to generate realistic users.
+// They include accents, non-Latin scripts, apostrophes and surname particles on purpose.
+var seedNames = []struct {
+	locale string
+	first  []string
+	last   []string
+}{
+	{"en", []string{"Emma", "Liam", "Olivia", "Noah", "Ava", "Ethan", "Zoë"}, []string{"Smith", "Johnson", "O'Brien", "McDonald", "Walker"}},
+	{"es", []string{"José", "María", "Mateo", "Lucía", "Álvaro"}, []string{"García", "Fernández", "Núñez", "de la Cruz"}},
+	{"fr", []string{"François", "Chloé", "Anaïs", "Renée", "Jérôme"}, []string{"Lefèvre", "Dubois", "Bélanger", "d'Arcy"}},
+	{"de", []string{"Jürgen", "Jörg", "Lena", "Günther"}, []string{"Müller", "Schröder", "Weiß", "von Braun"}},
+	{"nl", []string{"Daan", "Sanne", "Bram"}, []string{"van Dijk", "de Vries", "Jansen"}},
+	{"pl", []string{"Łukasz", "Małgorzata", "Zofia", "Paweł"}, []string{"Wiśniewski", "Kowalczyk", "Żak"}},
+	{"tr", []string{"İsmail", "Çağla", "Ayşe", "Şule", "Irmak"}, []string{"Yılmaz", "Şahin", "Öztürk", "Çelik"}},
+	{"sv", []string{"Björn", "Åsa", "Sören"}, []string{"Åström", "Lindqvist", "Öberg"}},
+	{"pt", []string{"João", "Inês", "Gonçalo"}, []string{"Gonçalves", "Conceição", "Simões"}},
+	{"ru", []string{"Ольга", "Дмитрий", "Анна"}, []string{"Иванова", "Смирнов", "Кузнецова"}},
+	{"ja", []string{"さくら", "Hiroshi", "Yūki"}, []string{"山田", "Satō", "Tanaka"}},
+	{"vi", []string{"Minh", "Thảo", "Phương"}, []string{"Nguyễn", "Trần", "Lê"}},
+}
+
+// seedDomains lists the email domains of generated users.
+var seedDomains = []string{"example.com", "example.org", "example.net", "mail.example"}
+
+// asciiFolds maps letters that do not decompose into an ASCII letter and accents.
+var asciiFolds = strings.NewReplacer("ł", "l", "Ł", "L", "ø", "o", "Ø", "O", "ß", "ss", "ı", "i", "æ", "ae", "Æ", "AE", "đ", "d", "Đ", "D")
+
+// GenerateUsers returns n realistic users generated from the seed; the same seed always gives the same users.
+// Names are drawn from several locales, including non-ASCII and non-Latin ones, and half of the users
+// carry the locale of their name. About a third of the ages lie between 14 and 21, around the
+// adult boundary of 18, and the others between 22 and 90. Emails are unique within the result.
+// The users have no ID, so they can be created or imported as new users.
+func GenerateUsers(n int, seed uint64) []models.User {
+	rng := rand.New(rand.NewPCG(seed, 0))
+	users := make([]models.User, 0, max(n, 0))
+
+	for i := 1; i <= n; i++ {
+		names := seedNames[rng.IntN(len(seedNames))]
+		first := names.first[rng.IntN(len(names.first))]
+		last := names.last[rng.IntN(len(names.last))]
+
+		age := 22 + rng.IntN(69)
+		if rng.IntN(3) == 0 {
+			age = 14 + rng.IntN(8) // Around the adult boundary.
+		}
+
+		user := models.User{
+			Name:  first + " " + last,
+			Age:   age,
+			Email: emailLocalPart(first) + "." + emailLocalPart(last) + "." + strconv.Itoa(i) + "@" + seedDomains[rng.IntN(len(seedDomains))],
+		}
+		if rng.IntN(2) == 0 {
+			user.Locale = names.locale
+		}
+		users = append(users, user)
+	}
+	return users
+}
+
+// emailLocalPart folds a name to lower-case ASCII letters for the local part of an email,
+// e.g. "d'Arcy" becomes "darcy" and "Łukasz" becomes "lukasz". Names without any Latin letter become "user".
+func emailLocalPart(name string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(asciiFolds.Replace(name)) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	if b.Len() == 0 {
+		return "user"
+	}
+	return b.String()
+}
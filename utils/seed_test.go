@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+	"unicode/utf8"
+)
+
+// TestGenerateUsers tests that generated users are deterministic, valid, varied and have unique emails.
+func TestGenerateUsers(t *testing.T) {
+	users := GenerateUsers(500, 42)
+	if len(users) != 500 {
+		t.Fatalf("Expected 500 users, got %d", len(users))
+	}
+	if !reflect.DeepEqual(users, GenerateUsers(500, 42)) {
+		t.Errorf("Expected the same seed to generate the same users")
+	}
+	if reflect.DeepEqual(users, GenerateUsers(500, 43)) {
+		t.Errorf("Expected different seeds to generate different users")
+	}
+
+	emails := make(map[string]bool)
+	var minors, adults, nonASCII, withLocale int
+	for _, u := range users {
+		if err := u.Validate(); err != nil {
+			t.Errorf("Generated user %+v is invalid: %v", u, err)
+		}
+		if emails[u.Email] {
+			t.Errorf("Duplicate email %q", u.Email)
+		}
+		emails[u.Email] = true
+
+		if u.Age < 18 {
+			minors++
+		} else {
+			adults++
+		}
+		if utf8.RuneCountInString(u.Name) != len(u.Name) {
+			nonASCII++
+		}
+		if u.Locale != "" {
+			withLocale++
+		}
+	}
+	if minors == 0 || adults == 0 || nonASCII == 0 || withLocale == 0 {
+		t.Errorf("Expected varied users, got %d minors, %d adults, %d non-ASCII names and %d locales", minors, adults, nonASCII, withLocale)
+	}
+}
+
+// TestEmailLocalPart tests the folding of names to email local parts.
+func TestEmailLocalPart(t *testing.T) {
+	tests := map[string]string{
+		"d'Arcy":     "darcy",
+		"Łukasz":     "lukasz",
+		"Çağla":      "cagla",
+		"İsmail":     "ismail",
+		"Weiß":       "weiss",
+		"de la Cruz": "delacruz",
+		"山田":         "user",
+	}
+	for name, expected := range tests {
+		if got := emailLocalPart(name); got != expected {
+			t.Errorf("emailLocalPart(%q) = %q, expected %q", name, got, expected)
+		}
+	}
+}
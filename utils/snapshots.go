@@ -0,0 +1,246 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// snapshotTimeFormat is the timestamp embedded in snapshot names.
+// It has a fixed width so names sort chronologically.
+const snapshotTimeFormat = "20060102T150405.000000000Z"
+
+// LatestSnapshot is the name of the pointer file holding the name of the newest snapshot.
+// It can also be passed to Open to get the newest snapshot.
+const LatestSnapshot = "latest"
+
+// snapshotName matches snapshot file names, e.g. "users-20261018T173136.123456789Z.csv.gz".
+var snapshotName = regexp.MustCompile(`^(.+)-(\d{8}T\d{6}\.\d{9}Z)(\.[a-z.]+)$`)
+
+// ErrSnapshotNotFound is returned when a snapshot does not exist or its name is invalid.
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+// SnapshotInfo describes a stored snapshot.
+type SnapshotInfo struct {
+	Name      string    `json:"name"`       // File name of the snapshot.
+	Size      int64     `json:"size"`       // Size of the snapshot in bytes.
+	CreatedAt time.Time `json:"created_at"` // Time the snapshot was taken.
+}
+
+// SnapshotStore writes timestamped export snapshots to a directory and applies a retention policy.
+// A "latest" pointer file always names the newest snapshot.
+type SnapshotStore struct {
+	dir      string           // Directory holding the snapshots.
+	prefix   string           // Prefix of snapshot names, e.g. "users".
+	maxCount int              // Maximum number of snapshots kept (0 means unlimited).
+	maxAge   time.Duration    // Maximum age of snapshots kept (0 means unlimited).
+	now      func() time.Time // Clock, replaceable in tests.
+	mu       sync.Mutex       // Serializes writes and pruning.
+}
+
+// NewSnapshotStore creates a snapshot store in dir, creating the directory if needed.
+// Snapshots beyond maxCount or older than maxAge are deleted after every write; zero disables a limit.
+func NewSnapshotStore(dir, prefix string, maxCount int, maxAge time.Duration) (*SnapshotStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &SnapshotStore{dir: dir, prefix: prefix, maxCount: maxCount, maxAge: maxAge, now: time.Now}, nil
+}
+
+// Write creates a new snapshot taken now with the given extension (e.g. ".csv.gz") using the write function.
+func (s *SnapshotStore) Write(ext string, write func(w io.Writer) error) (SnapshotInfo, error) {
+	return s.WriteAt(s.now(), ext, write)
+}
+
+// WriteAt creates a new snapshot of the data as it was at the given time.
+// The snapshot is written to a temporary file and renamed into place, so readers never see partial files.
+// The latest pointer only moves forward, so a snapshot that finishes after a newer one does not replace it.
+// The retention policy is applied after every write.
+func (s *SnapshotStore) WriteAt(taken time.Time, ext string, write func(w io.Writer) error) (SnapshotInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	created := taken.UTC()
+	name := s.prefix + "-" + created.Format(snapshotTimeFormat) + ext
+
+	tmp, err := os.CreateTemp(s.dir, ".tmp-"+s.prefix+"-*")
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	defer os.Remove(tmp.Name()) // Clean up if the rename does not happen.
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return SnapshotInfo{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return SnapshotInfo{}, err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, name)); err != nil {
+		return SnapshotInfo{}, err
+	}
+
+	latest, err := s.Latest()
+	if err != nil || name > latest {
+		if err := writeFileAtomic(filepath.Join(s.dir, LatestSnapshot), []byte(name+"\n")); err != nil {
+			return SnapshotInfo{}, err
+		}
+		latest = name
+	}
+
+	info, err := os.Stat(filepath.Join(s.dir, name))
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	return SnapshotInfo{Name: name, Size: info.Size(), CreatedAt: created}, s.prune(latest)
+}
+
+// List returns the stored snapshots, newest first.
+func (s *SnapshotStore) List() ([]SnapshotInfo, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []SnapshotInfo
+	for _, entry := range entries {
+		created, ok := s.parseName(entry.Name())
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // The snapshot was pruned in the meantime.
+		}
+		snapshots = append(snapshots, SnapshotInfo{Name: entry.Name(), Size: info.Size(), CreatedAt: created})
+	}
+
+	slices.SortFunc(snapshots, func(a, b SnapshotInfo) int { return strings.Compare(b.Name, a.Name) })
+	return snapshots, nil
+}
+
+// Latest returns the name of the newest snapshot, as recorded by the latest pointer.
+func (s *SnapshotStore) Latest() (string, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, LatestSnapshot))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", ErrSnapshotNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Open opens a snapshot or a snapshot manifest by name; the name "latest" resolves to the newest snapshot.
+// Names that are not snapshot names of this store are rejected, so callers cannot escape the directory.
+func (s *SnapshotStore) Open(name string) (*os.File, SnapshotInfo, error) {
+	if name == LatestSnapshot {
+		var err error
+		if name, err = s.Latest(); err != nil {
+			return nil, SnapshotInfo{}, err
+		}
+	}
+
+	created, ok := s.parseName(strings.TrimSuffix(name, ManifestSuffix))
+	if !ok {
+		return nil, SnapshotInfo{}, ErrSnapshotNotFound
+	}
+	file, err := os.Open(filepath.Join(s.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, SnapshotInfo{}, ErrSnapshotNotFound
+	}
+	if err != nil {
+		return nil, SnapshotInfo{}, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, SnapshotInfo{}, err
+	}
+	return file, SnapshotInfo{Name: name, Size: info.Size(), CreatedAt: created}, nil
+}
+
+// WriteManifest stores the manifest of a snapshot next to it, as "<snapshot>.manifest.json".
+// It returns ErrSnapshotNotFound if the snapshot was already pruned.
+func (s *SnapshotStore) WriteManifest(m Manifest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.parseName(m.File); !ok {
+		return fmt.Errorf("%s is not a snapshot of this store", m.File)
+	}
+	if _, err := os.Stat(filepath.Join(s.dir, m.File)); errors.Is(err, os.ErrNotExist) {
+		return ErrSnapshotNotFound
+	}
+	return WriteManifest(filepath.Join(s.dir, ManifestPath(m.File)), m)
+}
+
+// prune deletes the snapshots exceeding the retention count or age, along with their manifests.
+// The snapshot named keep (the newest one) is never deleted. The caller must hold s.mu.
+func (s *SnapshotStore) prune(keep string) error {
+	snapshots, err := s.List()
+	if err != nil {
+		return err
+	}
+
+	cutoff := s.now().Add(-s.maxAge)
+	var errs []error
+	for i, snapshot := range snapshots {
+		tooMany := s.maxCount > 0 && i >= s.maxCount
+		tooOld := s.maxAge > 0 && snapshot.CreatedAt.Before(cutoff)
+		if snapshot.Name == keep || (!tooMany && !tooOld) {
+			continue
+		}
+		for _, name := range []string{snapshot.Name, snapshot.Name + ManifestSuffix} {
+			if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// parseName reports whether name is a snapshot of this store and returns its creation time.
+// Manifests are not snapshots.
+func (s *SnapshotStore) parseName(name string) (time.Time, bool) {
+	if strings.HasSuffix(name, ManifestSuffix) {
+		return time.Time{}, false
+	}
+	m := snapshotName.FindStringSubmatch(name)
+	if m == nil || m[1] != s.prefix {
+		return time.Time{}, false
+	}
+	created, err := time.Parse(snapshotTimeFormat, m[2])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return created, true
+}
+
+// writeFileAtomic writes data to a temporary file and renames it over path.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-"+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Clean up if the rename does not happen.
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("replacing %s: %w", path, err)
+	}
+	return nil
+}
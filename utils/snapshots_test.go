@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// TestSnapshotStore tests writing, listing and opening snapshots and the latest pointer.
+func TestSnapshotStore(t *testing.T) {
+	store, err := NewSnapshotStore(t.TempDir(), "users", 0, 0)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	base := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	// Write two snapshots, the newer one finishing first.
+	newer, err := store.WriteAt(base.Add(time.Minute), ".csv", func(w io.Writer) error {
+		_, err := io.WriteString(w, "newer")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	if _, err := store.WriteAt(base, ".csv", func(w io.Writer) error {
+		_, err := io.WriteString(w, "older")
+		return err
+	}); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+
+	// Verify the list is sorted newest first and the latest pointer did not move backwards.
+	snapshots, err := store.List()
+	if err != nil {
+		t.Fatalf("Failed to list snapshots: %v", err)
+	}
+	if len(snapshots) != 2 || snapshots[0].Name != newer.Name {
+		t.Errorf("Unexpected snapshots: %+v", snapshots)
+	}
+	if newer.Name != "users-20261018T120100.000000000Z.csv" {
+		t.Errorf("Unexpected snapshot name %q", newer.Name)
+	}
+
+	file, info, err := store.Open(LatestSnapshot)
+	if err != nil {
+		t.Fatalf("Failed to open latest snapshot: %v", err)
+	}
+	defer file.Close()
+	data, _ := io.ReadAll(file)
+	if info.Name != newer.Name || string(data) != "newer" {
+		t.Errorf("Expected latest to be %q, got %q with %q", newer.Name, info.Name, data)
+	}
+
+	// Names outside the store must be rejected.
+	for _, name := range []string{"../users-20261018T120100.000000000Z.csv", "latest.csv", "orders-20261018T120100.000000000Z.csv"} {
+		if _, _, err := store.Open(name); !errors.Is(err, ErrSnapshotNotFound) {
+			t.Errorf("Expected Open(%q) to fail with ErrSnapshotNotFound, got %v", name, err)
+		}
+	}
+}
+
+// TestSnapshotStoreRetention tests that snapshots beyond the retention count or age are deleted.
+func TestSnapshotStoreRetention(t *testing.T) {
+	store, err := NewSnapshotStore(t.TempDir(), "users", 3, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	write := func(at time.Time) {
+		if _, err := store.WriteAt(at, ".jsonl.gz", func(io.Writer) error { return nil }); err != nil {
+			t.Fatalf("Failed to write snapshot: %v", err)
+		}
+	}
+
+	write(now.Add(-2 * time.Hour)) // Too old.
+	for i := 4; i >= 0; i-- {
+		write(now.Add(-time.Duration(i) * time.Minute)) // Only the 3 newest fit the count.
+	}
+
+	snapshots, err := store.List()
+	if err != nil {
+		t.Fatalf("Failed to list snapshots: %v", err)
+	}
+	if len(snapshots) != 3 {
+		t.Fatalf("Expected 3 snapshots, got %d: %+v", len(snapshots), snapshots)
+	}
+	if !snapshots[2].CreatedAt.Equal(now.Add(-2 * time.Minute)) {
+		t.Errorf("Expected oldest kept snapshot to be 2 minutes old, got %v", snapshots[2].CreatedAt)
+	}
+}
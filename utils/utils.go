@@ -1,72 +1,214 @@
 package utils
 
 import (
-	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
 	"user_api_with_concurrency/models"
-
-	"golang.org/x/text/cases"
-	"golang.org/x/text/language"
 )
 
 // ProcessAndWriteToCSV processes users from a channel and writes them to a CSV file.
-// It filters users to include only those aged 18 or older and formats their names in title case.
-func ProcessAndWriteToCSV(userChan <-chan models.User, filename string) error {
-	// Create the CSV file.
-	file, err := os.Create(filename)
+// Every user is run through the pipeline before being written. If no pipeline is given,
+// the active configuration is used, which by default keeps users aged 18 or older and
+// formats their names in title case.
+func ProcessAndWriteToCSV(userChan <-chan models.User, filename string, pipeline ...Pipeline) error {
+	opts := ExportOptions{Format: FormatCSV}
+	if len(pipeline) > 0 {
+		opts.Pipeline = pipeline[0]
+	}
+
+	_, err := ExportUsersToFile(userChan, filename, opts)
+	return err
+}
+
+// Export settings used by SendUsersToCSV.
+var (
+	exportFile    string         // Default export filename, relative to the project root.
+	exportFormat  Format         // Explicit export format; empty means derive it from exportFile.
+	snapshotStore *SnapshotStore // Store for timestamped snapshots; nil when snapshots are disabled.
+	changeLog     *ChangeLog     // Changelog for incremental exports; nil in full export mode.
+
+	exportMu  sync.Mutex    // Serializes the exports of SendUsersToCSV.
+	exportSeq atomic.Uint64 // Number of the latest export requested from SendUsersToCSV.
+)
+
+// init initializes the export settings.
+// It reads the filename from EXPORT_FILE (default "users.csv") and the optional format from EXPORT_FORMAT.
+// Test binaries of any package export to a temporary directory unless EXPORT_FILE is set, so running
+// the tests never writes to the repository.
+// If EXPORT_SNAPSHOT_DIR is set, exports are written as timestamped snapshots in that directory instead,
+// keeping EXPORT_RETENTION_COUNT snapshots (default 10, 0 for unlimited) no older than EXPORT_RETENTION_AGE.
+func init() {
+	exportFile = os.Getenv("EXPORT_FILE")
+	if exportFile == "" {
+		exportFile = "users.csv"
+		if testing.Testing() {
+			if dir, err := os.MkdirTemp("", "users-export-"); err == nil {
+				exportFile = filepath.Join(dir, exportFile)
+			}
+		}
+	}
+
+	if name := os.Getenv("EXPORT_FORMAT"); name != "" {
+		format, err := ParseFormat(name)
+		if err != nil {
+			log.Println("Ignoring EXPORT_FORMAT:", err)
+		} else {
+			exportFormat = format
+		}
+	}
+
+	if dir := os.Getenv("EXPORT_SNAPSHOT_DIR"); dir != "" {
+		store, err := newSnapshotStoreFromEnv(dir)
+		if err != nil {
+			log.Println("Export snapshots disabled:", err)
+		} else {
+			snapshotStore = store
+		}
+	}
+
+	switch mode := os.Getenv("EXPORT_MODE"); mode {
+	case "", "full":
+	case "incremental":
+		changes, err := newChangeLogFromEnv()
+		if err != nil {
+			log.Println("Incremental export disabled:", err)
+			return
+		}
+		changeLog = changes
+	default:
+		log.Printf("Ignoring unknown EXPORT_MODE %q\n", mode)
+	}
+}
+
+// newChangeLogFromEnv creates the changelog for incremental exports.
+// It is stored in EXPORT_CHANGELOG_DIR (default "changes"), rolls segments after
+// EXPORT_CHANGELOG_MAX_BYTES (default 10 MiB) and compacts every EXPORT_COMPACT_EVERY changes (default 1000).
+// Snapshots use the format of the export file.
+func newChangeLogFromEnv() (*ChangeLog, error) {
+	dir := os.Getenv("EXPORT_CHANGELOG_DIR")
+	if dir == "" {
+		dir = "changes"
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(GetProjectRoot(), dir)
+	}
+
+	maxBytes := int64(10 << 20)
+	if v := os.Getenv("EXPORT_CHANGELOG_MAX_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid EXPORT_CHANGELOG_MAX_BYTES %q", v)
+		}
+		maxBytes = n
+	}
+
+	compactEvery := 1000
+	if v := os.Getenv("EXPORT_COMPACT_EVERY"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid EXPORT_COMPACT_EVERY %q", v)
+		}
+		compactEvery = n
+	}
+
+	format, compress, err := FormatFromFilename(exportFile)
+	if exportFormat != "" {
+		format, err = exportFormat, nil
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer file.Close() // Ensure the file is closed when done.
+	return NewChangeLog(dir, maxBytes, compactEvery, ExportOptions{Format: format, Compress: compress})
+}
+
+// SetExportFile replaces the export filename; relative names are resolved against the project root.
+// It is meant to be called during initialization, before any export runs, e.g. by tests.
+func SetExportFile(filename string) {
+	exportFile = filename
+}
 
-	// Create a CSV writer.
-	writer := csv.NewWriter(file)
-	defer writer.Flush() // Ensure all buffered data is written to the file.
+// Changes returns the changelog for incremental exports, or nil in full export mode.
+func Changes() *ChangeLog {
+	return changeLog
+}
 
-	// Create a title caser for formatting names.
-	titleCaser := cases.Title(language.English)
+// SetChanges replaces the changelog used for incremental exports; nil selects full exports.
+// It is meant to be called during initialization, before any export runs.
+func SetChanges(changes *ChangeLog) {
+	changeLog = changes
+}
 
-	// Write the CSV header.
-	if err := writer.Write([]string{"ID", "Name", "Age", "Email"}); err != nil {
-		return err
+// newSnapshotStoreFromEnv creates the snapshot store using the retention environment variables.
+// Relative directories are resolved against the project root, like the export file.
+func newSnapshotStoreFromEnv(dir string) (*SnapshotStore, error) {
+	maxCount := 10
+	if v := os.Getenv("EXPORT_RETENTION_COUNT"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid EXPORT_RETENTION_COUNT %q", v)
+		}
+		maxCount = n
 	}
 
-	// Process users from the channel and write them to the CSV file.
-	for user := range userChan {
-		if user.Age >= 18 { // Only include users aged 18 or older.
-			record := []string{
-				strconv.Itoa(user.ID),        // Convert ID to string.
-				titleCaser.String(user.Name), // Format name in title case.
-				strconv.Itoa(user.Age),       // Convert age to string.
-				user.Email,                   // Include email.
-			}
-			if err := writer.Write(record); err != nil {
-				return err
-			}
+	var maxAge time.Duration
+	if v := os.Getenv("EXPORT_RETENTION_AGE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid EXPORT_RETENTION_AGE %q", v)
 		}
+		maxAge = d
 	}
 
-	// Check for errors during writing.
-	if err := writer.Error(); err != nil {
-		return err
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(GetProjectRoot(), dir)
 	}
+	prefix := strings.TrimSuffix(filepath.Base(exportFile), exportExtension(exportFile))
+	return NewSnapshotStore(dir, prefix, maxCount, maxAge)
+}
+
+// Snapshots returns the store of export snapshots, or nil if snapshots are disabled.
+func Snapshots() *SnapshotStore {
+	return snapshotStore
+}
 
-	return nil
+// SetSnapshots replaces the store used for export snapshots; nil disables snapshots.
+// It is meant to be called during initialization, before any export runs.
+func SetSnapshots(store *SnapshotStore) {
+	snapshotStore = store
 }
 
-// SendUsersToCSV writes a list or map of users to a CSV file.
+// exportExtension returns the export extension of a filename, including a trailing ".gz" (e.g. ".csv.gz").
+func exportExtension(filename string) string {
+	ext := filepath.Ext(filename)
+	if strings.EqualFold(ext, ".gz") {
+		ext = filepath.Ext(strings.TrimSuffix(filename, ext)) + ext
+	}
+	return ext
+}
+
+// SendUsersToCSV writes a list or map of users to the export file.
 // It supports both slices and maps of users and allows specifying a custom filename.
+// Despite its name, the format is taken from EXPORT_FORMAT or the filename extension,
+// so "users.jsonl.gz" produces gzip-compressed JSON Lines; the default remains CSV.
 func SendUsersToCSV(users any, filename ...string) {
 	var userSlice []models.User
 
 	// Convert the input to a slice of users.
 	switch u := users.(type) {
 	case []models.User:
-		userSlice = u
+		userSlice = slices.Clone(u) // Copy so the caller may reuse the slice while the export runs.
 	case map[int]models.User:
 		userSlice = make([]models.User, 0, len(u))
 		for _, user := range u {
@@ -77,37 +219,101 @@ func SendUsersToCSV(users any, filename ...string) {
 		return
 	}
 
+	// Write a timestamped snapshot instead of overwriting the export file when snapshots are enabled.
+	if snapshotStore != nil && (len(filename) == 0 || filename[0] == "") {
+		go writeSnapshot(time.Now(), StreamUsers(userSlice))
+		return
+	}
+
 	// Determine the filename.
-	file := "users.csv"
+	file := exportFile
 	if len(filename) > 0 && filename[0] != "" {
 		file = filename[0]
 	}
 
 	// Use a temporary directory for test environments.
-	if os.Getenv("ENV") == "test" {
+	if os.Getenv("ENV") == "test" && !filepath.IsAbs(file) {
 		file = "/tmp/" + file
 	}
 
-	// Get the full file path by joining the project root directory with the filename.
-	filePath := filepath.Join(GetProjectRoot(), file)
+	// Get the full file path by joining the project root directory with a relative filename.
+	filePath := file
+	if !filepath.IsAbs(file) {
+		filePath = filepath.Join(GetProjectRoot(), file)
+	}
+
+	// Send users through a channel to the export goroutine.
+	userChan := StreamUsers(userSlice)
+	seq := exportSeq.Add(1)
+
+	// Start a goroutine to process and write users to the export file.
+	// Exports run one at a time, so the file and its manifest always describe the same write,
+	// and an export superseded by a newer one while it waited is skipped.
+	go func() {
+		exportMu.Lock()
+		defer exportMu.Unlock()
+		if seq < exportSeq.Load() {
+			drainUsers(userChan)
+			return
+		}
+		if _, err := ExportUsersToFile(userChan, filePath, ExportOptions{Format: exportFormat, Manifest: true}); err != nil {
+			log.Println("Failed to process and write export:", err)
+		}
+	}()
+}
+
+// writeSnapshot exports the users from the channel as a snapshot taken at the given time,
+// in the format of the export file.
+func writeSnapshot(taken time.Time, userChan <-chan models.User) {
+	defer drainUsers(userChan)
 
+	ext := exportExtension(exportFile)
+	format, compress, err := FormatFromFilename(exportFile)
+	if exportFormat != "" {
+		format, err = exportFormat, nil
+	}
+	if err != nil {
+		log.Println("Failed to write export snapshot:", err)
+		return
+	}
+
+	opts, err := ExportOptions{Format: format, Compress: compress}.resolve()
+	if err != nil {
+		log.Println("Failed to write export snapshot:", err)
+		return
+	}
+
+	var stats ExportStats
+	snapshot, err := snapshotStore.WriteAt(taken, ext, func(w io.Writer) error {
+		stats, err = ExportUsers(userChan, w, opts)
+		return err
+	})
+	if err != nil {
+		log.Println("Failed to write export snapshot:", err)
+		return
+	}
+
+	// Describe the snapshot in a manifest stored next to it.
+	err = snapshotStore.WriteManifest(NewManifest(snapshot.Name, opts, stats))
+	if err != nil && !errors.Is(err, ErrSnapshotNotFound) { // The snapshot may already be pruned.
+		log.Println("Failed to write export manifest:", err)
+	}
+}
+
+// StreamUsers sends the users to a channel from a goroutine, closing the channel when done.
+func StreamUsers(users []models.User) <-chan models.User {
 	// Create a channel to send users.
 	userChan := make(chan models.User)
 
 	// Start a goroutine to send users to the channel.
 	go func() {
 		defer close(userChan) // Close the channel when done.
-		for _, u := range userSlice {
+		for _, u := range users {
 			userChan <- u
 		}
 	}()
 
-	// Start a goroutine to process and write users to the CSV file.
-	go func() {
-		if err := ProcessAndWriteToCSV(userChan, filePath); err != nil {
-			log.Println("Failed to process and write CSV:", err)
-		}
-	}()
+	return userChan
 }
 
 // GetProjectRoot returns the root directory of the project.
@@ -2,7 +2,9 @@ package utils
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 	"user_api_with_concurrency/models"
 )
 
@@ -40,3 +42,31 @@ func TestProcessAndWriteToCSV(t *testing.T) {
 	}
 	defer file.Close() // Ensure the file is closed after checking.
 }
+
+// TestSendUsersToCSV_Concurrent tests that overlapping exports leave the export file of the latest one,
+// described by its manifest.
+func TestSendUsersToCSV_Concurrent(t *testing.T) {
+	saved := exportFile
+	filename := filepath.Join(t.TempDir(), "users.csv")
+	SetExportFile(filename)
+	t.Cleanup(func() { SetExportFile(saved) })
+
+	var list []models.User
+	for i := 1; i <= 50; i++ {
+		list = append(list, models.User{ID: i, Name: "Frodo Baggins", Age: 50, Email: "frodo@tolkien.com"})
+		SendUsersToCSV(list)
+	}
+
+	// Wait for the exports, which run in the background.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		manifest, err := VerifyExport(filename, "")
+		if err == nil && manifest.Rows == len(list) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the export of all %d users to verify, got %+v (%v)", len(list), manifest, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
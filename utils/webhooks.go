@@ -0,0 +1,526 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+	"user_api_with_concurrency/models"
+)
+
+// Settings of webhook deliveries.
+const (
+	webhookTimeout     = 10 * time.Second // Time a receiver has to answer a delivery.
+	webhookConcurrency = 4                // Number of deliveries attempted at the same time.
+	webhookHistory     = 100              // Delivered deliveries kept per webhook for inspection.
+	webhookCompactMin  = 1000             // Obsolete journal lines tolerated before the journal is compacted.
+)
+
+// Files of a webhook dispatcher's directory.
+const (
+	webhooksFile   = "webhooks.json"    // Webhooks and ID counters, rewritten on every change.
+	deliveriesFile = "deliveries.jsonl" // Journal of the delivery states; the last line of a delivery wins.
+)
+
+// Errors returned by the webhook dispatcher.
+var (
+	ErrWebhookNotFound  = errors.New("webhook not found")
+	ErrDeliveryNotFound = errors.New("delivery not found")
+	ErrDeliveryPending  = errors.New("delivery is still pending")
+)
+
+// WebhookOptions configure the retries of a WebhookDispatcher.
+type WebhookOptions struct {
+	MaxAttempts int           // Attempts before a delivery is moved to the dead-letter list.
+	BaseDelay   time.Duration // Delay before the first retry, doubled after every failed attempt.
+	MaxDelay    time.Duration // Maximum delay between two attempts.
+}
+
+// webhookState is the content of the webhooks file.
+type webhookState struct {
+	LastWebhookID  int              `json:"last_webhook_id"`
+	LastDeliveryID int64            `json:"last_delivery_id"`
+	Webhooks       []models.Webhook `json:"webhooks"`
+}
+
+// WebhookDispatcher posts user change events to the registered webhooks.
+// Deliveries are queued in a journal in its directory, so pending ones survive a restart, and
+// failed attempts are retried with exponential backoff until they succeed or the maximum number
+// of attempts moves them to the dead-letter list. Deliveries run concurrently, so a receiver
+// may get events out of order; the event ID in the payload tells their order.
+type WebhookDispatcher struct {
+	dir    string
+	opts   WebhookOptions
+	client *http.Client
+
+	mu             sync.Mutex                        // Protects the fields below.
+	state          webhookState                      // Webhooks, sorted by ID, and ID counters.
+	deliveries     map[int64]*models.WebhookDelivery // Deliveries by ID.
+	inflight       map[int64]bool                    // Deliveries being attempted.
+	journal        *os.File                          // Delivery journal; nil until the next write.
+	journalRecords int                               // Lines in the journal.
+	running        bool                              // The delivery loop is started.
+	wake           chan struct{}                     // Wakes the delivery loop up.
+}
+
+// OpenWebhooks loads the webhooks and deliveries saved in dir. The directory is created on the
+// first write, so a server without webhooks leaves no files behind. Pending deliveries are resumed.
+func OpenWebhooks(dir string, opts WebhookOptions) (*WebhookDispatcher, error) {
+	d := &WebhookDispatcher{
+		dir:  dir,
+		opts: opts,
+		client: &http.Client{
+			Timeout: webhookTimeout,
+			// Redirects are failed attempts: following them would turn the POST into a GET.
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		deliveries: make(map[int64]*models.WebhookDelivery),
+		inflight:   make(map[int64]bool),
+		wake:       make(chan struct{}, 1),
+	}
+
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, delivery := range d.deliveries {
+		if delivery.Status == models.DeliveryPending {
+			d.kick()
+			break
+		}
+	}
+	return d, nil
+}
+
+// load reads the webhooks file and replays the delivery journal, then compacts the journal.
+func (d *WebhookDispatcher) load() error {
+	data, err := os.ReadFile(filepath.Join(d.dir, webhooksFile))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return nil // Nothing was saved yet; without webhooks, the journal is irrelevant.
+	case err != nil:
+		return err
+	}
+	if err := json.Unmarshal(data, &d.state); err != nil {
+		return fmt.Errorf("invalid %s: %w", webhooksFile, err)
+	}
+
+	file, err := os.Open(filepath.Join(d.dir, deliveriesFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var delivery models.WebhookDelivery
+		if err := json.Unmarshal(scanner.Bytes(), &delivery); err != nil {
+			// A crash may leave the last line half written; the delivery keeps its previous state.
+			log.Printf("Skipping invalid line %d of %s: %v\n", line, deliveriesFile, err)
+			continue
+		}
+		if _, ok := d.webhook(delivery.WebhookID); !ok {
+			continue // The webhook was deleted.
+		}
+		d.deliveries[delivery.ID] = &delivery
+		d.state.LastDeliveryID = max(d.state.LastDeliveryID, delivery.ID)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	for _, hook := range d.state.Webhooks {
+		d.pruneHistory(hook.ID)
+	}
+	return d.compact()
+}
+
+// Add registers a webhook, assigning its ID and creation time.
+func (d *WebhookDispatcher) Add(hook models.Webhook) (models.Webhook, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.state.LastWebhookID++
+	hook.ID = d.state.LastWebhookID
+	hook.CreatedAt = time.Now().UTC()
+	d.state.Webhooks = append(d.state.Webhooks, hook)
+	if err := d.saveState(); err != nil {
+		d.state.Webhooks = d.state.Webhooks[:len(d.state.Webhooks)-1]
+		return models.Webhook{}, err
+	}
+	return hook, nil
+}
+
+// Webhooks returns the registered webhooks, sorted by ID.
+func (d *WebhookDispatcher) Webhooks() []models.Webhook {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]models.Webhook{}, d.state.Webhooks...)
+}
+
+// Remove deletes a webhook and its deliveries.
+func (d *WebhookDispatcher) Remove(id int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	i := slices.IndexFunc(d.state.Webhooks, func(h models.Webhook) bool { return h.ID == id })
+	if i < 0 {
+		return ErrWebhookNotFound
+	}
+	hook := d.state.Webhooks[i]
+	d.state.Webhooks = slices.Delete(d.state.Webhooks, i, i+1)
+	if err := d.saveState(); err != nil {
+		d.state.Webhooks = slices.Insert(d.state.Webhooks, i, hook)
+		return err
+	}
+
+	// The journal lines of the deliveries are dropped by the next compaction.
+	for deliveryID, delivery := range d.deliveries {
+		if delivery.WebhookID == id {
+			delete(d.deliveries, deliveryID)
+		}
+	}
+	return nil
+}
+
+// Deliveries returns the deliveries of a webhook, newest first. A non-empty status only returns
+// the deliveries with that status, e.g. models.DeliveryDead for the dead-letter list.
+func (d *WebhookDispatcher) Deliveries(webhookID int, status string) ([]models.WebhookDelivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.webhook(webhookID); !ok {
+		return nil, ErrWebhookNotFound
+	}
+	list := []models.WebhookDelivery{}
+	for _, delivery := range d.deliveries {
+		if delivery.WebhookID == webhookID && (status == "" || delivery.Status == status) {
+			list = append(list, *delivery)
+		}
+	}
+	slices.SortFunc(list, func(a, b models.WebhookDelivery) int { return int(b.ID - a.ID) })
+	return list, nil
+}
+
+// Redeliver queues a delivered or dead delivery again, with a fresh count of attempts.
+func (d *WebhookDispatcher) Redeliver(webhookID int, id int64) (models.WebhookDelivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.webhook(webhookID); !ok {
+		return models.WebhookDelivery{}, ErrWebhookNotFound
+	}
+	delivery, ok := d.deliveries[id]
+	if !ok || delivery.WebhookID != webhookID {
+		return models.WebhookDelivery{}, ErrDeliveryNotFound
+	}
+	if delivery.Status == models.DeliveryPending {
+		return models.WebhookDelivery{}, ErrDeliveryPending
+	}
+
+	now := time.Now().UTC()
+	delivery.Status, delivery.Attempts, delivery.NextAttempt = models.DeliveryPending, 0, &now
+	d.record(delivery)
+	d.kick()
+	return *delivery, nil
+}
+
+// Enqueue queues the delivery of an event to the webhooks subscribed to its type.
+// payload returns the request body for a webhook, e.g. with the webhook's redaction applied.
+// It is meant to be called while the store is locked, so it only writes to the journal:
+// errors are logged, and the deliveries are still attempted.
+func (d *WebhookDispatcher) Enqueue(eventID int64, eventType string, payload func(models.Webhook) ([]byte, error)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now().UTC()
+	queued := false
+	for _, hook := range d.state.Webhooks {
+		if len(hook.Events) > 0 && !slices.Contains(hook.Events, eventType) {
+			continue
+		}
+		body, err := payload(hook)
+		if err != nil {
+			log.Printf("Failed to build the payload of event %d for webhook %d: %v\n", eventID, hook.ID, err)
+			continue
+		}
+
+		d.state.LastDeliveryID++
+		delivery := &models.WebhookDelivery{
+			ID:          d.state.LastDeliveryID,
+			WebhookID:   hook.ID,
+			EventID:     eventID,
+			EventType:   eventType,
+			Payload:     body,
+			Status:      models.DeliveryPending,
+			NextAttempt: &now,
+			CreatedAt:   now,
+		}
+		d.deliveries[delivery.ID] = delivery
+		d.record(delivery)
+		queued = true
+	}
+	if queued {
+		d.kick()
+	}
+}
+
+// kick starts the delivery loop if needed, and wakes it up. The caller must hold d.mu.
+func (d *WebhookDispatcher) kick() {
+	if !d.running {
+		d.running = true
+		go d.run()
+	}
+	select {
+	case d.wake <- struct{}{}:
+	default: // A wake-up is already pending.
+	}
+}
+
+// run attempts the due deliveries, webhookConcurrency at a time, and sleeps until the next one is due.
+func (d *WebhookDispatcher) run() {
+	for {
+		d.mu.Lock()
+		now := time.Now()
+		var due []*models.WebhookDelivery
+		var next time.Time
+		for _, delivery := range d.deliveries {
+			if delivery.Status != models.DeliveryPending || d.inflight[delivery.ID] {
+				continue
+			}
+			if !delivery.NextAttempt.After(now) {
+				due = append(due, delivery)
+			} else if next.IsZero() || delivery.NextAttempt.Before(next) {
+				next = *delivery.NextAttempt
+			}
+		}
+
+		// Start the oldest deliveries first; the others wait for a free slot.
+		slices.SortFunc(due, func(a, b *models.WebhookDelivery) int { return int(a.ID - b.ID) })
+		for _, delivery := range due[:min(len(due), webhookConcurrency-len(d.inflight))] {
+			hook, _ := d.webhook(delivery.WebhookID)
+			d.inflight[delivery.ID] = true
+			go d.deliver(hook, *delivery)
+		}
+		d.mu.Unlock()
+
+		var timeout <-chan time.Time // Nil, so it blocks, when no retry is scheduled.
+		if !next.IsZero() {
+			timeout = time.After(time.Until(next))
+		}
+		select {
+		case <-d.wake:
+		case <-timeout:
+		}
+	}
+}
+
+// deliver makes an attempt of a delivery and records its outcome.
+func (d *WebhookDispatcher) deliver(hook models.Webhook, delivery models.WebhookDelivery) {
+	code, err := d.post(hook, delivery)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.inflight, delivery.ID)
+	defer d.kick() // A slot is free.
+
+	current, ok := d.deliveries[delivery.ID]
+	if !ok {
+		return // The webhook was deleted during the attempt.
+	}
+	now := time.Now().UTC()
+	current.Attempts++
+	current.LastAttempt, current.ResponseCode = &now, code
+	switch {
+	case err == nil:
+		current.Status, current.NextAttempt, current.LastError = models.DeliveryDelivered, nil, ""
+	case current.Attempts >= d.opts.MaxAttempts:
+		current.Status, current.NextAttempt, current.LastError = models.DeliveryDead, nil, err.Error()
+		log.Printf("Webhook %d: delivery %d failed after %d attempts: %v\n", hook.ID, delivery.ID, current.Attempts, err)
+	default:
+		next := now.Add(d.backoff(current.Attempts))
+		current.NextAttempt, current.LastError = &next, err.Error()
+	}
+	d.record(current)
+	if current.Status == models.DeliveryDelivered {
+		d.pruneHistory(hook.ID)
+	}
+}
+
+// post sends a delivery to its webhook. It returns the response status, if one was received,
+// and an error unless the status is 2xx.
+func (d *WebhookDispatcher) post(hook models.Webhook, delivery models.WebhookDelivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "user-api-webhooks")
+	req.Header.Set("X-Webhook-ID", strconv.Itoa(hook.ID))
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", SignWebhook(hook.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)) // Let the connection be reused.
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// SignWebhook returns the X-Webhook-Signature header of a delivery: "sha256=" followed by the hex
+// HMAC-SHA256 of the X-Webhook-Timestamp header, a dot and the body, keyed with the webhook's secret.
+// Receivers compute it the same way, and reject old timestamps to prevent replays.
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff returns the delay after the given number of failed attempts: the base delay,
+// doubled after every attempt, up to the maximum delay.
+func (d *WebhookDispatcher) backoff(attempts int) time.Duration {
+	delay := d.opts.BaseDelay
+	for i := 1; i < attempts && delay < d.opts.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, d.opts.MaxDelay)
+}
+
+// webhook returns the webhook with the given ID. The caller must hold d.mu.
+func (d *WebhookDispatcher) webhook(id int) (models.Webhook, bool) {
+	i := slices.IndexFunc(d.state.Webhooks, func(h models.Webhook) bool { return h.ID == id })
+	if i < 0 {
+		return models.Webhook{}, false
+	}
+	return d.state.Webhooks[i], true
+}
+
+// pruneHistory forgets the oldest delivered deliveries of a webhook beyond webhookHistory.
+// Dead ones are kept until they are redelivered or the webhook is deleted. The caller must hold d.mu.
+func (d *WebhookDispatcher) pruneHistory(webhookID int) {
+	var delivered []int64
+	for id, delivery := range d.deliveries {
+		if delivery.WebhookID == webhookID && delivery.Status == models.DeliveryDelivered {
+			delivered = append(delivered, id)
+		}
+	}
+	if len(delivered) <= webhookHistory {
+		return
+	}
+	slices.Sort(delivered)
+	for _, id := range delivered[:len(delivered)-webhookHistory] {
+		delete(d.deliveries, id)
+	}
+}
+
+// record appends the state of a delivery to the journal, and compacts the journal when most of
+// its lines are obsolete. Errors are logged. The caller must hold d.mu.
+func (d *WebhookDispatcher) record(delivery *models.WebhookDelivery) {
+	if err := d.appendJournal(delivery); err != nil {
+		log.Printf("Failed to journal webhook delivery %d: %v\n", delivery.ID, err)
+		return
+	}
+	if d.journalRecords > 2*len(d.deliveries)+webhookCompactMin {
+		if err := d.compact(); err != nil {
+			log.Println("Failed to compact the webhook delivery journal:", err)
+		}
+	}
+}
+
+// appendJournal writes a line to the journal, opening it if needed. The caller must hold d.mu.
+func (d *WebhookDispatcher) appendJournal(delivery *models.WebhookDelivery) error {
+	if d.journal == nil {
+		if err := os.MkdirAll(d.dir, 0o755); err != nil {
+			return err
+		}
+		file, err := os.OpenFile(filepath.Join(d.dir, deliveriesFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return err
+		}
+		d.journal = file
+	}
+
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	if _, err := d.journal.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	d.journalRecords++
+	return nil
+}
+
+// compact rewrites the journal with one line per current delivery, and saves the ID counters
+// so that the IDs of dropped deliveries are not reused. The caller must hold d.mu, or be loading.
+func (d *WebhookDispatcher) compact() error {
+	if err := d.saveState(); err != nil {
+		return err
+	}
+
+	ids := make([]int64, 0, len(d.deliveries))
+	for id := range d.deliveries {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	var buf bytes.Buffer
+	for _, id := range ids {
+		data, err := json.Marshal(d.deliveries[id])
+		if err != nil {
+			return err
+		}
+		buf.Write(append(data, '\n'))
+	}
+
+	if d.journal != nil {
+		d.journal.Close()
+		d.journal = nil // Reopened by the next write, after the rename.
+	}
+	if err := writeFileAtomic(filepath.Join(d.dir, deliveriesFile), buf.Bytes()); err != nil {
+		return err
+	}
+	d.journalRecords = len(ids)
+	return nil
+}
+
+// saveState writes the webhooks file. The caller must hold d.mu.
+func (d *WebhookDispatcher) saveState() error {
+	if err := os.MkdirAll(d.dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(d.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(d.dir, webhooksFile), data) // Created 0600: it holds the secrets.
+}
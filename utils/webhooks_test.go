@@ -0,0 +1,170 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+	"user_api_with_concurrency/models"
+)
+
+// waitDeliveries polls the deliveries of a webhook until all of them have the given status.
+func waitDeliveries(t *testing.T, d *WebhookDispatcher, webhookID, count int, status string) []models.WebhookDelivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		list, err := d.Deliveries(webhookID, status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) == count {
+			return list
+		}
+		if time.Now().After(deadline) {
+			all, _ := d.Deliveries(webhookID, "")
+			t.Fatalf("Expected %d %s deliveries, got %+v", count, status, all)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// payload returns a fixed payload for Enqueue.
+func payload(body string) func(models.Webhook) ([]byte, error) {
+	return func(models.Webhook) ([]byte, error) { return []byte(body), nil }
+}
+
+// TestWebhookDispatcher_SignedDelivery tests that events are posted to the subscribed webhooks
+// with a valid signature, and that other event types are skipped.
+func TestWebhookDispatcher_SignedDelivery(t *testing.T) {
+	received := make(chan *http.Request, 10)
+	bodies := make(chan string, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body [256]byte
+		n, _ := r.Body.Read(body[:])
+		received <- r
+		bodies <- string(body[:n])
+	}))
+	defer receiver.Close()
+
+	d, err := OpenWebhooks(t.TempDir(), WebhookOptions{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook, err := d.Add(models.Webhook{URL: receiver.URL, Events: []string{OpCreate}, Secret: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.Enqueue(1, OpUpdate, payload(`{"id":1}`)) // Not subscribed.
+	d.Enqueue(2, OpCreate, payload(`{"id":2}`))
+
+	r, body := <-received, <-bodies
+	if body != `{"id":2}` || r.Header.Get("X-Webhook-Event") != OpCreate || r.Header.Get("X-Webhook-Delivery") != "1" {
+		t.Errorf("Unexpected delivery %q with headers %v", body, r.Header)
+	}
+	if expected := SignWebhook("s3cret", r.Header.Get("X-Webhook-Timestamp"), []byte(body)); r.Header.Get("X-Webhook-Signature") != expected {
+		t.Errorf("Expected signature %q, got %q", expected, r.Header.Get("X-Webhook-Signature"))
+	}
+
+	list := waitDeliveries(t, d, hook.ID, 1, models.DeliveryDelivered)
+	if list[0].EventID != 2 || list[0].Attempts != 1 || list[0].ResponseCode != http.StatusOK {
+		t.Errorf("Unexpected delivery %+v", list[0])
+	}
+}
+
+// TestWebhookDispatcher_RetryAndDeadLetter tests that failed deliveries are retried until the
+// maximum number of attempts, then dead-lettered, and that a redelivery queues them again.
+func TestWebhookDispatcher_RetryAndDeadLetter(t *testing.T) {
+	var calls atomic.Int32
+	var healthy atomic.Bool
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer receiver.Close()
+
+	d, err := OpenWebhooks(t.TempDir(), WebhookOptions{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 4 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook, _ := d.Add(models.Webhook{URL: receiver.URL, Secret: "s3cret"})
+	d.Enqueue(1, OpDelete, payload(`{}`))
+
+	dead := waitDeliveries(t, d, hook.ID, 1, models.DeliveryDead)
+	if calls.Load() != 3 || dead[0].Attempts != 3 || dead[0].ResponseCode != http.StatusServiceUnavailable || dead[0].LastError == "" {
+		t.Errorf("Expected 3 failed attempts, got %d calls and %+v", calls.Load(), dead[0])
+	}
+
+	healthy.Store(true)
+	if _, err := d.Redeliver(hook.ID, dead[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	waitDeliveries(t, d, hook.ID, 1, models.DeliveryDelivered)
+	if _, err := d.Redeliver(hook.ID, 42); err != ErrDeliveryNotFound {
+		t.Errorf("Expected ErrDeliveryNotFound, got %v", err)
+	}
+}
+
+// TestWebhookDispatcher_Backoff tests the exponential backoff and its maximum.
+func TestWebhookDispatcher_Backoff(t *testing.T) {
+	d := &WebhookDispatcher{opts: WebhookOptions{BaseDelay: time.Second, MaxDelay: 5 * time.Second}}
+	for attempts, expected := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 60: 5 * time.Second} {
+		if delay := d.backoff(attempts); delay != expected {
+			t.Errorf("backoff(%d): expected %v, got %v", attempts, expected, delay)
+		}
+	}
+}
+
+// TestWebhookDispatcher_Persistence tests that webhooks and pending deliveries survive a restart,
+// that a torn journal line is skipped, and that deleted webhooks lose their deliveries.
+func TestWebhookDispatcher_Persistence(t *testing.T) {
+	dir := t.TempDir()
+	opts := WebhookOptions{MaxAttempts: 3, BaseDelay: time.Hour, MaxDelay: time.Hour}
+
+	// The receiver is down, so the deliveries stay pending after their first attempt.
+	d, _ := OpenWebhooks(dir, opts)
+	kept, _ := d.Add(models.Webhook{URL: "http://127.0.0.1:1/hook", Secret: "a"})
+	deleted, _ := d.Add(models.Webhook{URL: "http://127.0.0.1:1/other", Secret: "b"})
+	d.Enqueue(1, OpCreate, payload(`{"id":1}`))
+	d.Enqueue(2, OpUpdate, payload(`{"id":2}`))
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		list := waitDeliveries(t, d, kept.ID, 2, models.DeliveryPending)
+		if list[0].Attempts == 1 && list[1].Attempts == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the first attempts to fail, got %+v", list)
+		}
+	}
+	if err := d.Remove(deleted.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	journal, _ := os.OpenFile(filepath.Join(dir, deliveriesFile), os.O_WRONLY|os.O_APPEND, 0)
+	journal.WriteString(`{"id":99,"webhook_`)
+	journal.Close()
+
+	reopened, err := OpenWebhooks(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hooks := reopened.Webhooks(); len(hooks) != 1 || hooks[0].ID != kept.ID || hooks[0].Secret != "a" {
+		t.Errorf("Unexpected webhooks after a restart: %+v", hooks)
+	}
+	list := waitDeliveries(t, reopened, kept.ID, 2, models.DeliveryPending)
+	if list[0].EventID != 2 || list[1].EventID != 1 || list[0].Attempts != 1 {
+		t.Errorf("Unexpected deliveries after a restart: %+v", list)
+	}
+
+	// New deliveries do not reuse the IDs of the deleted webhook's deliveries.
+	reopened.Enqueue(3, OpDelete, payload(`{}`))
+	list = waitDeliveries(t, reopened, kept.ID, 3, models.DeliveryPending)
+	if list[0].ID != 5 {
+		t.Errorf("Expected delivery ID 5, got %d", list[0].ID)
+	}
+}
@@ -0,0 +1,481 @@
+package utils
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"user_api_with_concurrency/models"
+)
+
+// Format identifies an export file format.
+type Format string
+
+// Supported export formats.
+const (
+	FormatCSV   Format = "csv"   // Comma-separated values with a header row.
+	FormatTSV   Format = "tsv"   // Tab-separated values with a header row.
+	FormatJSONL Format = "jsonl" // One JSON object per line.
+	FormatJSON  Format = "json"  // A single JSON array of objects.
+	FormatXML   Format = "xml"   // A <users> document with one <user> element per record.
+)
+
+// Formats lists every supported export format.
+var Formats = []Format{FormatCSV, FormatTSV, FormatJSONL, FormatJSON, FormatXML}
+
+// ExportColumns lists the user fields exported by default, in their default order.
+var ExportColumns = []string{"id", "name", "age", "email"}
+
+// AvailableColumns lists every user field that can be selected for export.
+var AvailableColumns = []string{"id", "name", "age", "email", "locale"}
+
+// columnTitles maps column names to the titles used in CSV and TSV headers.
+var columnTitles = map[string]string{"id": "ID", "name": "Name", "age": "Age", "email": "Email", "locale": "Locale"}
+
+// ParseFormat validates a format name such as "csv" or "jsonl".
+func ParseFormat(name string) (Format, error) {
+	f := Format(strings.ToLower(strings.TrimPrefix(name, ".")))
+	if f == "ndjson" {
+		f = FormatJSONL
+	}
+	if !slices.Contains(Formats, f) {
+		return "", fmt.Errorf("unsupported format %q", name)
+	}
+	return f, nil
+}
+
+// FormatFromFilename derives the export format from a file extension.
+// A trailing ".gz" enables gzip compression, e.g. "users.jsonl.gz" is compressed JSON Lines.
+func FormatFromFilename(filename string) (Format, bool, error) {
+	compress := false
+	if strings.EqualFold(filepath.Ext(filename), ".gz") {
+		compress = true
+		filename = strings.TrimSuffix(filename, filepath.Ext(filename))
+	}
+	ext := filepath.Ext(filename)
+	if ext == "" {
+		return "", compress, fmt.Errorf("cannot determine format of %q", filename)
+	}
+	f, err := ParseFormat(ext)
+	return f, compress, err
+}
+
+// ParseColumns validates a list of column names, returning ExportColumns if the list is empty.
+func ParseColumns(columns []string) ([]string, error) {
+	if len(columns) == 0 {
+		return ExportColumns, nil
+	}
+	for _, c := range columns {
+		if !slices.Contains(AvailableColumns, c) {
+			return nil, fmt.Errorf("unknown column %q", c)
+		}
+	}
+	return columns, nil
+}
+
+// Field is a single named value of an exported record.
+type Field struct {
+	Name  string // Column name, e.g. "email".
+	Value any    // Column value; numbers keep their type so JSON output stays typed.
+}
+
+// Record is an ordered list of fields representing one exported user.
+type Record []Field
+
+// NewRecord builds a record with the given columns of the user.
+func NewRecord(user models.User, columns []string) Record {
+	record := make(Record, 0, len(columns))
+	for _, c := range columns {
+		var value any
+		switch c {
+		case "id":
+			value = user.ID
+		case "name":
+			value = user.Name
+		case "age":
+			value = user.Age
+		case "email":
+			value = user.Email
+		case "locale":
+			value = user.Locale
+		}
+		record = append(record, Field{Name: c, Value: value})
+	}
+	return record
+}
+
+// Strings returns the values of the record formatted as strings.
+func (r Record) Strings() []string {
+	values := make([]string, len(r))
+	for i, f := range r {
+		values[i] = fmt.Sprint(f.Value)
+	}
+	return values
+}
+
+// MarshalJSON encodes the record as a JSON object, preserving the column order.
+func (r Record) MarshalJSON() ([]byte, error) {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, f := range r {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, err := json.Marshal(f.Name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(f.Value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return []byte(b.String()), nil
+}
+
+// RecordWriter writes exported records in a specific format.
+// Close must be called to write any trailer and flush buffered data; it does not close the underlying writer.
+type RecordWriter interface {
+	Write(record Record) error
+	Close() error
+}
+
+// NewRecordWriter creates a writer for the format that writes the given columns to w.
+// If compress is true, the output is gzip-compressed.
+func NewRecordWriter(w io.Writer, format Format, columns []string, compress bool) (RecordWriter, error) {
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+
+	var rw RecordWriter
+	var err error
+	switch format {
+	case FormatCSV:
+		rw, err = newDelimitedWriter(w, ',', columns)
+	case FormatTSV:
+		rw, err = newDelimitedWriter(w, '\t', columns)
+	case FormatJSONL:
+		rw = &jsonLinesWriter{enc: json.NewEncoder(w)}
+	case FormatJSON:
+		rw, err = newJSONArrayWriter(w)
+	case FormatXML:
+		rw, err = newXMLWriter(w)
+	default:
+		err = fmt.Errorf("unsupported format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if gz != nil {
+		return &gzipRecordWriter{RecordWriter: rw, gz: gz}, nil
+	}
+	return rw, nil
+}
+
+// delimitedWriter writes CSV or TSV records with a header row.
+type delimitedWriter struct {
+	w *csv.Writer
+}
+
+// newDelimitedWriter creates a delimited writer and writes the header row.
+func newDelimitedWriter(w io.Writer, comma rune, columns []string) (*delimitedWriter, error) {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+
+	header := make([]string, len(columns))
+	for i, c := range columns {
+		header[i] = columnTitles[c]
+	}
+	if err := cw.Write(header); err != nil {
+		return nil, err
+	}
+	return &delimitedWriter{w: cw}, nil
+}
+
+func (d *delimitedWriter) Write(record Record) error {
+	return d.w.Write(record.Strings())
+}
+
+func (d *delimitedWriter) Close() error {
+	d.w.Flush()
+	return d.w.Error()
+}
+
+// jsonLinesWriter writes one JSON object per line.
+type jsonLinesWriter struct {
+	enc *json.Encoder
+}
+
+func (j *jsonLinesWriter) Write(record Record) error {
+	return j.enc.Encode(record)
+}
+
+func (j *jsonLinesWriter) Close() error {
+	return nil
+}
+
+// jsonArrayWriter writes records as the elements of a single JSON array.
+type jsonArrayWriter struct {
+	w     io.Writer
+	count int
+}
+
+// newJSONArrayWriter creates a JSON array writer and writes the opening bracket.
+func newJSONArrayWriter(w io.Writer) (*jsonArrayWriter, error) {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return nil, err
+	}
+	return &jsonArrayWriter{w: w}, nil
+}
+
+func (j *jsonArrayWriter) Write(record Record) error {
+	data, err := record.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if j.count == 0 {
+		sep = "\n"
+	}
+	j.count++
+	if _, err := io.WriteString(j.w, sep); err != nil {
+		return err
+	}
+	_, err = j.w.Write(data)
+	return err
+}
+
+func (j *jsonArrayWriter) Close() error {
+	_, err := io.WriteString(j.w, "\n]\n")
+	return err
+}
+
+// xmlWriter writes records as <user> elements inside a <users> document.
+type xmlWriter struct {
+	w *bufio.Writer
+}
+
+// newXMLWriter creates an XML writer and writes the prolog and the root element.
+func newXMLWriter(w io.Writer) (*xmlWriter, error) {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(xml.Header + "<users>\n"); err != nil {
+		return nil, err
+	}
+	return &xmlWriter{w: bw}, nil
+}
+
+func (x *xmlWriter) Write(record Record) error {
+	x.w.WriteString("  <user>")
+	for _, f := range record {
+		x.w.WriteString("<" + f.Name + ">")
+		if err := xml.EscapeText(x.w, []byte(fmt.Sprint(f.Value))); err != nil {
+			return err
+		}
+		x.w.WriteString("</" + f.Name + ">")
+	}
+	_, err := x.w.WriteString("</user>\n")
+	return err
+}
+
+func (x *xmlWriter) Close() error {
+	if _, err := x.w.WriteString("</users>\n"); err != nil {
+		return err
+	}
+	return x.w.Flush()
+}
+
+// gzipRecordWriter closes the gzip stream after the wrapped writer has written its trailer.
+type gzipRecordWriter struct {
+	RecordWriter
+	gz *gzip.Writer
+}
+
+func (g *gzipRecordWriter) Close() error {
+	if err := g.RecordWriter.Close(); err != nil {
+		return err
+	}
+	return g.gz.Close()
+}
+
+// ExportOptions configures an export.
+type ExportOptions struct {
+	Format         Format            // Output format; if empty, it is derived from the filename.
+	Compress       bool              // Gzip the output; also enabled by a ".gz" filename extension.
+	Columns        []string          // Columns to export; defaults to ExportColumns.
+	Pipeline       Pipeline          // Pipeline applied to every user; defaults to the active configuration.
+	PipelineConfig *PipelineConfig   // Configuration the pipeline was built from, recorded in manifests.
+	Redaction      *RedactionProfile // Redaction applied to every record; defaults to the profile named by the pipeline configuration.
+	Manifest       bool              // Write a manifest next to the exported file (file exports only).
+}
+
+// resolve fills in the defaults of the options and validates them.
+// If no pipeline is given, it is built from PipelineConfig or the active configuration,
+// and so is the redaction profile. Columns dropped by the redaction are removed.
+func (o ExportOptions) resolve() (ExportOptions, error) {
+	if o.Format == "" {
+		o.Format = FormatCSV
+	}
+
+	columns, err := ParseColumns(o.Columns)
+	if err != nil {
+		return o, err
+	}
+	o.Columns = columns
+
+	if o.Pipeline == nil {
+		config := ActivePipelineConfig()
+		if o.PipelineConfig != nil {
+			config = *o.PipelineConfig
+		}
+		if o.Pipeline, err = config.Build(); err != nil {
+			return o, err
+		}
+		o.PipelineConfig = &config
+	}
+
+	if o.Redaction == nil {
+		config := ActivePipelineConfig()
+		if o.PipelineConfig != nil {
+			config = *o.PipelineConfig
+		}
+		if config.Redact != "" {
+			profile, err := LookupRedactionProfile(config.Redact)
+			if err != nil {
+				return o, err
+			}
+			o.Redaction = &profile
+		}
+	}
+	if o.Redaction != nil {
+		o.Columns = o.Redaction.Columns(o.Columns)
+	}
+	return o, nil
+}
+
+// record converts a processed user to the record to export, redacting it if needed.
+func (o ExportOptions) record(user models.User) Record {
+	record := NewRecord(user, o.Columns)
+	if o.Redaction != nil {
+		record = o.Redaction.Apply(record)
+	}
+	return record
+}
+
+// ExportStats summarizes the outcome of an export.
+type ExportStats struct {
+	Rows     int            // Number of records written.
+	Filtered map[string]int // Number of users filtered out, by the name of the stage that dropped them.
+	Bytes    int64          // Number of bytes written, after compression.
+	SHA256   string         // Hex-encoded SHA-256 checksum of the bytes written.
+}
+
+// ExportUsers runs the users from the channel through the pipeline and writes them to w.
+// The channel is always drained, even if writing fails, so the sender never blocks forever.
+func ExportUsers(userChan <-chan models.User, w io.Writer, opts ExportOptions) (ExportStats, error) {
+	stats := ExportStats{Filtered: make(map[string]int)}
+	defer drainUsers(userChan)
+
+	opts, err := opts.resolve()
+	if err != nil {
+		return stats, err
+	}
+
+	// Checksum and count everything written, after compression.
+	hw := &hashingWriter{w: w, hash: sha256.New()}
+	rw, err := NewRecordWriter(hw, opts.Format, opts.Columns, opts.Compress)
+	if err != nil {
+		return stats, err
+	}
+
+	// Process users from the channel and write the ones kept by the pipeline.
+	for user := range userChan {
+		user, reason, keep := opts.Pipeline.Process(user)
+		if !keep {
+			stats.Filtered[reason]++
+			continue
+		}
+		if err := rw.Write(opts.record(user)); err != nil {
+			return stats, err
+		}
+		stats.Rows++
+	}
+
+	err = rw.Close()
+	stats.Bytes = hw.n
+	stats.SHA256 = hex.EncodeToString(hw.hash.Sum(nil))
+	return stats, err
+}
+
+// hashingWriter computes the checksum and size of the data written through it.
+type hashingWriter struct {
+	w    io.Writer
+	hash hash.Hash
+	n    int64
+}
+
+func (h *hashingWriter) Write(p []byte) (int, error) {
+	n, err := h.w.Write(p)
+	h.hash.Write(p[:n])
+	h.n += int64(n)
+	return n, err
+}
+
+// ExportUsersToFile exports the users from the channel to a file.
+// The format and compression are taken from the options or, if unset, from the filename extension.
+// If opts.Manifest is set, a manifest describing the export is written next to the file.
+func ExportUsersToFile(userChan <-chan models.User, filename string, opts ExportOptions) (ExportStats, error) {
+	defer drainUsers(userChan)
+
+	// Derive the format and compression from the extension unless they are set explicitly.
+	format, compress, err := FormatFromFilename(filename)
+	if opts.Format == "" {
+		if err != nil {
+			return ExportStats{}, err
+		}
+		opts.Format = format
+	}
+	opts.Compress = opts.Compress || compress
+
+	if opts, err = opts.resolve(); err != nil {
+		return ExportStats{}, err
+	}
+
+	// Create the export file.
+	file, err := os.Create(filename)
+	if err != nil {
+		return ExportStats{}, err
+	}
+
+	stats, err := ExportUsers(userChan, file, opts)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || !opts.Manifest {
+		return stats, err
+	}
+
+	return stats, WriteManifest(ManifestPath(filename), NewManifest(filepath.Base(filename), opts, stats))
+}
+
+// drainUsers discards the remaining users of a channel until it is closed.
+func drainUsers(userChan <-chan models.User) {
+	for range userChan {
+	}
+}
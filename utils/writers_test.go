@@ -0,0 +1,145 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"user_api_with_concurrency/models"
+)
+
+// testUsers returns the users shared by the export tests, including a minor filtered out by default.
+func testUsers() []models.User {
+	return []models.User{
+		{ID: 1, Name: "erick rettozi", Age: 48, Email: "erettozi@tolkien.com"},
+		{ID: 2, Name: "aragorn elessar", Age: 37, Email: "aragorn@tolkien.com"},
+		{ID: 3, Name: "pippin took", Age: 17, Email: "pippin@tolkien.com"},
+	}
+}
+
+// TestExportUsersFormats tests that every format writes the users kept by the default pipeline.
+func TestExportUsersFormats(t *testing.T) {
+	tests := []struct {
+		format Format
+		check  func(t *testing.T, out string)
+	}{
+		{FormatCSV, func(t *testing.T, out string) {
+			expected := "ID,Name,Age,Email\n1,Erick Rettozi,48,erettozi@tolkien.com\n2,Aragorn Elessar,37,aragorn@tolkien.com\n"
+			if out != expected {
+				t.Errorf("Unexpected CSV output:\n%s", out)
+			}
+		}},
+		{FormatTSV, func(t *testing.T, out string) {
+			if !strings.HasPrefix(out, "ID\tName\tAge\tEmail\n1\tErick Rettozi\t48\t") {
+				t.Errorf("Unexpected TSV output:\n%s", out)
+			}
+		}},
+		{FormatJSONL, func(t *testing.T, out string) {
+			lines := strings.Split(strings.TrimSpace(out), "\n")
+			if len(lines) != 2 || lines[0] != `{"id":1,"name":"Erick Rettozi","age":48,"email":"erettozi@tolkien.com"}` {
+				t.Errorf("Unexpected JSON Lines output:\n%s", out)
+			}
+		}},
+		{FormatJSON, func(t *testing.T, out string) {
+			var users []models.User
+			if err := json.Unmarshal([]byte(out), &users); err != nil {
+				t.Fatalf("Invalid JSON array: %v\n%s", err, out)
+			}
+			if len(users) != 2 || users[1].Name != "Aragorn Elessar" {
+				t.Errorf("Unexpected JSON users: %+v", users)
+			}
+		}},
+		{FormatXML, func(t *testing.T, out string) {
+			var doc struct {
+				Users []struct {
+					ID    int    `xml:"id"`
+					Name  string `xml:"name"`
+					Email string `xml:"email"`
+				} `xml:"user"`
+			}
+			if err := xml.Unmarshal([]byte(out), &doc); err != nil {
+				t.Fatalf("Invalid XML: %v\n%s", err, out)
+			}
+			if len(doc.Users) != 2 || doc.Users[0].Email != "erettozi@tolkien.com" {
+				t.Errorf("Unexpected XML users: %+v", doc.Users)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			var buf bytes.Buffer
+			stats, err := ExportUsers(StreamUsers(testUsers()), &buf, ExportOptions{Format: tt.format})
+			if err != nil {
+				t.Fatalf("Export failed: %v", err)
+			}
+			if stats.Rows != 2 || stats.Filtered["min_age:18"] != 1 {
+				t.Errorf("Unexpected stats: %+v", stats)
+			}
+			tt.check(t, buf.String())
+		})
+	}
+}
+
+// TestExportUsersToFileCompressed tests that a ".gz" extension produces a gzip-compressed file
+// in the format of the inner extension, restricted to the selected columns.
+func TestExportUsersToFileCompressed(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "users.jsonl.gz")
+
+	_, err := ExportUsersToFile(StreamUsers(testUsers()), filename, ExportOptions{Columns: []string{"id", "email"}})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatalf("Failed to open export: %v", err)
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("Export is not gzip-compressed: %v", err)
+	}
+	data, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("Failed to decompress export: %v", err)
+	}
+
+	if !strings.HasPrefix(string(data), `{"id":1,"email":"erettozi@tolkien.com"}`+"\n") {
+		t.Errorf("Unexpected export content:\n%s", data)
+	}
+}
+
+// TestFormatFromFilename tests deriving the format and compression from file extensions.
+func TestFormatFromFilename(t *testing.T) {
+	tests := []struct {
+		filename string
+		format   Format
+		compress bool
+	}{
+		{"users.csv", FormatCSV, false},
+		{"users.TSV", FormatTSV, false},
+		{"users.ndjson", FormatJSONL, false},
+		{"users.json.gz", FormatJSON, true},
+		{"export/users.xml", FormatXML, false},
+	}
+
+	for _, tt := range tests {
+		format, compress, err := FormatFromFilename(tt.filename)
+		if err != nil || format != tt.format || compress != tt.compress {
+			t.Errorf("FormatFromFilename(%q) = (%q, %v, %v), expected (%q, %v)", tt.filename, format, compress, err, tt.format, tt.compress)
+		}
+	}
+
+	for _, bad := range []string{"users", "users.gz", "users.parquet"} {
+		if _, _, err := FormatFromFilename(bad); err == nil {
+			t.Errorf("Expected FormatFromFilename(%q) to fail", bad)
+		}
+	}
+}